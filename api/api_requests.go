@@ -17,24 +17,161 @@
 package api
 
 import (
+	"encoding/json"
 	"errors"
+	"net/url"
+	"strconv"
+	"time"
 
-	openvzcmd "github.com/romiras/go-openvz-cmd"
+	"github.com/robfig/cron/v3"
+	"github.com/romiras/go-openvz-api/models"
 )
 
-const MissingParamError = " is missing or empty"
+// Events webhooks can subscribe to
+const (
+	JobDoneEvent          = "job.done"
+	JobFailedEvent        = "job.failed"
+	ContainerCreatedEvent = "container.created"
+	ContainerDeletedEvent = "container.deleted"
+)
+
+// Kinds of drift between containers in the database and on a host
+const (
+	DriftMissing   = "missing"   // a container is not found on a host
+	DriftUnmanaged = "unmanaged" // a container on a host is not in the database
+	DriftMismatch  = "mismatch"  // a field of a container differs
+)
+
+// Resolutions of drift
+const (
+	DriftImported = "imported"
+	DriftMarked   = "marked"
+	DriftRestored = "restored"
+)
+
+var WebhookEvents = []string{JobDoneEvent, JobFailedEvent, ContainerCreatedEvent, ContainerDeletedEvent}
+
+const (
+	MissingParamError = " is missing or empty"
+	UnknownParamError = " is unknown"
+	InvalidParamError = " is invalid"
+
+	IdempotencyKeyHeader    = "Idempotency-Key"
+	MaxIdempotencyKeyLength = 255
+
+	// DryRunParam is a query parameter making a request only render commands it would run
+	DryRunParam = "dry_run"
+
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+
+	MaxSnapshotNameLength = 255
+
+	// MaxRetentionDays limits how long a backup may be retained
+	MaxRetentionDays = 3650
+	// MaxPolicyNameLength leaves room for a time in names of snapshots taken by a policy
+	MaxPolicyNameLength = 128
+	// MaxKeep limits numbers of artifacts retained by a policy rule
+	MaxKeep = 1000
+	// MaxPreviewRuns limits a number of upcoming runs of a policy in a preview
+	MaxPreviewRuns = 100
+)
+
+// Container power lifecycle actions
+const (
+	StartAction   = "start"
+	StopAction    = "stop"
+	RestartAction = "restart"
+	SuspendAction = "suspend"
+	ResumeAction  = "resume"
+)
 
 type (
 	AddContainerRequest struct {
 		Name       string `json:"name"`
 		OSTemplate string `json:"ostemplate"`
+		// Parameters are set right after a container is created
+		Parameters map[string]json.RawMessage `json:"parameters"`
+		// Patch holds validated Parameters
+		Patch ContainerParametersPatch `json:"-"`
 	}
 
-	// ContainerParameters map[string]string
-
+	// UpdateContainerRequest merges given parameters into parameters of a container,
+	// or replaces all of them when Replace is set
 	UpdateContainerRequest struct {
 		ID         string
-		Parameters openvzcmd.Options `json:"parameters"`
+		Parameters map[string]json.RawMessage `json:"parameters"`
+		Replace    bool                       `json:"-"`
+		// Patch holds validated Parameters
+		Patch ContainerParametersPatch `json:"-"`
+	}
+
+	// CloneContainerRequest copies a container to a new one named Name,
+	// overriding its parameters with given ones, e.g. hostname or ipadd
+	CloneContainerRequest struct {
+		ID         string                     `json:"-"`
+		Name       string                     `json:"name"`
+		Parameters map[string]json.RawMessage `json:"parameters"`
+		// Patch holds validated Parameters
+		Patch ContainerParametersPatch `json:"-"`
+	}
+
+	AddSnapshotRequest struct {
+		ContainerID string `json:"-"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		// PolicyID is set if a snapshot is taken by a policy
+		PolicyID string `json:"-"`
+	}
+
+	AddBackupRequest struct {
+		ContainerID string `json:"-"`
+		Description string `json:"description"`
+		// RetentionDays is how many days a backup is retained, 0 means forever
+		RetentionDays int `json:"retention_days"`
+		// PolicyID is set if a backup is made by a policy
+		PolicyID string `json:"-"`
+	}
+
+	AddPolicyRequest struct {
+		Name string `json:"name"`
+		// Kind is what a policy makes, a backup or a snapshot
+		Kind string `json:"kind"`
+		// Schedule is a cron expression, e.g. "0 3 * * *" for 3 am daily, or a descriptor like "@daily"
+		Schedule    string `json:"schedule"`
+		KeepLast    int    `json:"keep_last"`
+		KeepDaily   int    `json:"keep_daily"`
+		KeepWeekly  int    `json:"keep_weekly"`
+		KeepMonthly int    `json:"keep_monthly"`
+	}
+
+	AttachPolicyRequest struct {
+		ID          string `json:"-"`
+		ContainerID string `json:"container_id"`
+	}
+
+	// RestoreBackupRequest restores a backup into an existing container given by ContainerID,
+	// or into a new container named Name
+	RestoreBackupRequest struct {
+		ID          string `json:"-"`
+		ContainerID string `json:"container_id"`
+		Name        string `json:"name"`
+	}
+
+	AddWebhookRequest struct {
+		URL string `json:"url"`
+		// Secret signing payloads, generated when empty
+		Secret string   `json:"secret"`
+		Events []string `json:"events"`
+	}
+
+	ListJobsRequest struct {
+		Status       string     `form:"status"`
+		Type         string     `form:"type"`
+		EntityID     string     `form:"entity_id"`
+		CreatedAfter *time.Time `form:"created_after" time_format:"2006-01-02T15:04:05Z07:00"`
+		Limit        int        `form:"limit"`
+		Offset       int        `form:"offset"`
 	}
 )
 
@@ -42,6 +179,14 @@ func missingParam(param string) error {
 	return errors.New(param + MissingParamError)
 }
 
+func unknownParam(param string) error {
+	return errors.New(param + UnknownParamError)
+}
+
+func invalidParam(param string) error {
+	return errors.New(param + InvalidParamError)
+}
+
 func InvalidRequest(err error) *ApiResponse {
 	return &ApiResponse{
 		Code:    100,
@@ -50,6 +195,14 @@ func InvalidRequest(err error) *ApiResponse {
 	}
 }
 
+// InvalidFields returns a response listing errors of invalid fields of a request
+func InvalidFields(errs ValidationErrors) *ValidationErrorResponse {
+	return &ValidationErrorResponse{
+		ApiResponse: *InvalidRequest(errors.New("invalid parameters")),
+		Errors:      errs,
+	}
+}
+
 func FailedRequest(err error) *ApiResponse {
 	return &ApiResponse{
 		Code:    200,
@@ -62,13 +215,67 @@ func ValidateAddContainerRequest(req *AddContainerRequest) error {
 	if req.Name == "" {
 		return missingParam("name")
 	}
+	if !isValidHostname(req.Name) {
+		return invalidParam("name")
+	}
 	if req.OSTemplate == "" {
 		return missingParam("ostemplate")
 	}
+	if !isValidTemplateName(req.OSTemplate) {
+		return invalidParam("ostemplate")
+	}
+
+	patch, err := parseContainerParameters(req.Parameters, true)
+	if err != nil {
+		return err
+	}
+	req.Patch = patch
+
+	return nil
+}
+
+func ValidateCloneContainerRequest(req *CloneContainerRequest) error {
+	if req.ID == "" {
+		return missingParam("id")
+	}
+	if req.Name == "" {
+		return missingParam("name")
+	}
+	if !isValidHostname(req.Name) {
+		return invalidParam("name")
+	}
+
+	patch, err := parseContainerParameters(req.Parameters, true)
+	if err != nil {
+		return err
+	}
+	req.Patch = patch
 
 	return nil
 }
 
+func ValidateIdempotencyKey(key string) error {
+	if len(key) > MaxIdempotencyKeyLength {
+		return invalidParam(IdempotencyKeyHeader)
+	}
+
+	return nil
+}
+
+// ParseDryRun parses a value of DryRunParam, which is false if empty
+func ParseDryRun(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, invalidParam(DryRunParam)
+	}
+
+	return dryRun, nil
+}
+
 func ValidateGetContainerByIdRequest(id string) error {
 	if id == "" {
 		return missingParam("id")
@@ -85,6 +292,199 @@ func ValidateGetJobByIdRequest(id string) error {
 	return nil
 }
 
+func ValidateContainerActionRequest(id, action string) error {
+	if id == "" {
+		return missingParam("id")
+	}
+
+	switch action {
+	case StartAction, StopAction, RestartAction, SuspendAction, ResumeAction:
+		return nil
+	case "":
+		return missingParam("action")
+	default:
+		return unknownParam("action")
+	}
+}
+
+func ValidateListJobsRequest(req *ListJobsRequest) error {
+	if req.Status != "" {
+		if _, ok := models.ParseJobStatus(req.Status); !ok {
+			return unknownParam("status")
+		}
+	}
+	if req.Limit < 0 || req.Limit > MaxListLimit {
+		return invalidParam("limit")
+	}
+	if req.Offset < 0 {
+		return invalidParam("offset")
+	}
+
+	return nil
+}
+
+func ValidateAddSnapshotRequest(req *AddSnapshotRequest) error {
+	if req.ContainerID == "" {
+		return missingParam("id")
+	}
+	if len(req.Name) > MaxSnapshotNameLength {
+		return invalidParam("name")
+	}
+	if len(req.Description) > MaxDescriptionLength {
+		return invalidParam("description")
+	}
+
+	return nil
+}
+
+func ValidateAddBackupRequest(req *AddBackupRequest) error {
+	if req.ContainerID == "" {
+		return missingParam("id")
+	}
+	if len(req.Description) > MaxDescriptionLength {
+		return invalidParam("description")
+	}
+	if req.RetentionDays < 0 || req.RetentionDays > MaxRetentionDays {
+		return invalidParam("retention_days")
+	}
+
+	return nil
+}
+
+func ValidateRestoreBackupRequest(req *RestoreBackupRequest) error {
+	if req.ID == "" {
+		return missingParam("id")
+	}
+	if req.ContainerID == "" && req.Name == "" {
+		return missingParam("container_id or name")
+	}
+	if req.ContainerID != "" && req.Name != "" {
+		return errors.New("only one of container_id and name may be given")
+	}
+	if req.Name != "" && !isValidHostname(req.Name) {
+		return invalidParam("name")
+	}
+
+	return nil
+}
+
+func ValidateAddPolicyRequest(req *AddPolicyRequest) error {
+	if req.Name == "" {
+		return missingParam("name")
+	}
+	if len(req.Name) > MaxPolicyNameLength {
+		return invalidParam("name")
+	}
+
+	switch req.Kind {
+	case models.PolicyBackup, models.PolicySnapshot:
+	case "":
+		return missingParam("kind")
+	default:
+		return unknownParam("kind")
+	}
+
+	if req.Schedule == "" {
+		return missingParam("schedule")
+	}
+	if _, err := ParseSchedule(req.Schedule); err != nil {
+		return invalidParam("schedule")
+	}
+
+	keeps := map[string]int{
+		"keep_last":    req.KeepLast,
+		"keep_daily":   req.KeepDaily,
+		"keep_weekly":  req.KeepWeekly,
+		"keep_monthly": req.KeepMonthly,
+	}
+	total := 0
+	for param, keep := range keeps {
+		if keep < 0 || keep > MaxKeep {
+			return invalidParam(param)
+		}
+		total += keep
+	}
+	if total == 0 {
+		return errors.New("at least one of keep_last, keep_daily, keep_weekly and keep_monthly must be positive")
+	}
+
+	return nil
+}
+
+// ParseSchedule parses a standard cron expression of a policy
+func ParseSchedule(schedule string) (cron.Schedule, error) {
+	return cron.ParseStandard(schedule)
+}
+
+func ValidateAttachPolicyRequest(req *AttachPolicyRequest) error {
+	if req.ID == "" {
+		return missingParam("id")
+	}
+	if req.ContainerID == "" {
+		return missingParam("container_id")
+	}
+
+	return nil
+}
+
+// ParsePreviewRuns parses a number of upcoming runs in a preview of a policy
+func ParsePreviewRuns(value string, defaultRuns int) (int, error) {
+	if value == "" {
+		return defaultRuns, nil
+	}
+
+	runs, err := strconv.Atoi(value)
+	if err != nil || runs < 0 || runs > MaxPreviewRuns {
+		return 0, invalidParam("runs")
+	}
+
+	return runs, nil
+}
+
+func ValidateAddWebhookRequest(req *AddWebhookRequest) error {
+	if req.URL == "" {
+		return missingParam("url")
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return invalidParam("url")
+	}
+
+	if len(req.Events) == 0 {
+		return missingParam("events")
+	}
+	for _, event := range req.Events {
+		if !isWebhookEvent(event) {
+			return unknownParam("event " + event)
+		}
+	}
+
+	return nil
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
 func ValidateUpdateContainerRequest(req *UpdateContainerRequest) error {
+	if req.ID == "" {
+		return missingParam("id")
+	}
+	if req.Parameters == nil {
+		return missingParam("parameters")
+	}
+
+	patch, err := parseContainerParameters(req.Parameters, req.Replace)
+	if err != nil {
+		return err
+	}
+	req.Patch = patch
+
 	return nil
 }
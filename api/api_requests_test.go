@@ -0,0 +1,83 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"testing"
+)
+
+func TestValidateAddContainerRequest(t *testing.T) {
+	for _, tc := range []struct {
+		req  AddContainerRequest
+		want string
+	}{
+		{AddContainerRequest{OSTemplate: "centos-7-x86_64"}, "name" + MissingParamError},
+		{AddContainerRequest{Name: "-foo", OSTemplate: "centos-7-x86_64"}, "name" + InvalidParamError},
+		{AddContainerRequest{Name: "a b", OSTemplate: "centos-7-x86_64"}, "name" + InvalidParamError},
+		{AddContainerRequest{Name: "web"}, "ostemplate" + MissingParamError},
+		{AddContainerRequest{Name: "web", OSTemplate: "--help"}, "ostemplate" + InvalidParamError},
+		{AddContainerRequest{Name: "web", OSTemplate: "centos 7"}, "ostemplate" + InvalidParamError},
+		{AddContainerRequest{Name: "web", OSTemplate: "../centos-7"}, "ostemplate" + InvalidParamError},
+		{AddContainerRequest{Name: "web.example", OSTemplate: "ubuntu-20.04-x86_64"}, ""},
+	} {
+		if got := errString(ValidateAddContainerRequest(&tc.req)); got != tc.want {
+			t.Errorf("Create %+v: got error %q, want %q", tc.req, got, tc.want)
+		}
+	}
+}
+
+func TestValidateCloneContainerRequest(t *testing.T) {
+	for name, want := range map[string]string{
+		"":            "name" + MissingParamError,
+		"-web":        "name" + InvalidParamError,
+		"--help":      "name" + InvalidParamError,
+		"web server":  "name" + InvalidParamError,
+		"web.example": "",
+		"web-2":       "",
+	} {
+		err := ValidateCloneContainerRequest(&CloneContainerRequest{ID: "ct-1", Name: name})
+		if got := errString(err); got != want {
+			t.Errorf("Clone named %q: got error %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestValidateRestoreBackupRequest(t *testing.T) {
+	for _, tc := range []struct {
+		req  RestoreBackupRequest
+		want string
+	}{
+		{RestoreBackupRequest{ID: "b-1"}, "container_id or name" + MissingParamError},
+		{RestoreBackupRequest{ID: "b-1", ContainerID: "ct-1", Name: "web"}, "only one of container_id and name may be given"},
+		{RestoreBackupRequest{ID: "b-1", Name: "-web"}, "name" + InvalidParamError},
+		{RestoreBackupRequest{ID: "b-1", Name: "web_1"}, "name" + InvalidParamError},
+		{RestoreBackupRequest{ID: "b-1", Name: "web-1"}, ""},
+		{RestoreBackupRequest{ID: "b-1", ContainerID: "ct-1"}, ""},
+	} {
+		if got := errString(ValidateRestoreBackupRequest(&tc.req)); got != tc.want {
+			t.Errorf("Restore %+v: got error %q, want %q", tc.req, got, tc.want)
+		}
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,440 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/romiras/go-openvz-api/commanders"
+)
+
+// Parameters of a container, named after vars of the ct-set command
+const (
+	HostnameParam     = "hostname"
+	CPUsParam         = "cpus"
+	MemSizeParam      = "memsize"
+	MemSizeUnitsParam = "memsize_units"
+	IPAddParam        = "ipadd"
+	SizeParam         = "size"
+	SizeUnitsParam    = "size_units"
+	NameserverParam   = "nameserver"
+	DescriptionParam  = "description"
+
+	// DefaultSizeUnits are units of memsize and size given without them
+	DefaultSizeUnits = "M"
+
+	MaxCPUs              = 1024
+	MaxDescriptionLength = 1024
+)
+
+type (
+	// ContainerParametersPatch maps parameters to their new values, a nil value removes a parameter
+	ContainerParametersPatch map[string]*string
+
+	// ValidationErrors maps invalid fields of a request to their errors
+	ValidationErrors map[string]string
+
+	// parameterParser validates a JSON value of a parameter and formats it as a command var
+	parameterParser func(raw json.RawMessage) (string, error)
+)
+
+// UnitsParameters maps size parameters to parameters of their units
+var UnitsParameters = map[string]string{
+	MemSizeParam: MemSizeUnitsParam,
+	SizeParam:    SizeUnitsParam,
+}
+
+var containerParameters = map[string]parameterParser{
+	HostnameParam:     parseHostname,
+	CPUsParam:         parseInt(1, MaxCPUs),
+	MemSizeParam:      parseInt(1, 0),
+	MemSizeUnitsParam: parseOneOf("K", "M", "G"),
+	IPAddParam:        parseList(parseIPAddress),
+	SizeParam:         parseInt(1, 0),
+	SizeUnitsParam:    parseOneOf("K", "M", "G", "T"),
+	NameserverParam:   parseList(parseIP),
+	DescriptionParam:  parseDescription,
+}
+
+var hostnameRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+var templateNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{0,254}$`)
+
+func (errs ValidationErrors) Error() string {
+	fields := make([]string, 0, len(errs))
+	for field := range errs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field+" "+errs[field])
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// parseContainerParameters validates parameters given in a request. Null removes a parameter,
+// unless all parameters are replaced.
+func parseContainerParameters(params map[string]json.RawMessage, replace bool) (ContainerParametersPatch, error) {
+	patch := make(ContainerParametersPatch, len(params))
+	errs := make(ValidationErrors)
+
+	for name, raw := range params {
+		parse, ok := containerParameters[name]
+		if !ok {
+			errs[name] = strings.TrimSpace(UnknownParamError)
+			continue
+		}
+
+		if string(raw) == "null" {
+			if replace {
+				errs[name] = "must not be null"
+				continue
+			}
+			patch[name] = nil
+			continue
+		}
+
+		value, err := parse(raw)
+		if err != nil {
+			errs[name] = err.Error()
+			continue
+		}
+		patch[name] = &value
+	}
+
+	for param, unitsParam := range UnitsParameters {
+		if units, ok := patch[unitsParam]; ok && units != nil {
+			if value, ok := patch[param]; !ok || value == nil {
+				errs[unitsParam] = "requires " + param
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return patch, nil
+}
+
+func parseString(raw json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", errors.New("must be a string")
+	}
+
+	return s, nil
+}
+
+// isValidHostname reports whether s is a host name, which is also a safe name of a container
+// as it never starts with "-" to be taken for an option by vzctl or prlctl
+func isValidHostname(s string) bool {
+	return len(s) <= 253 && hostnameRegexp.MatchString(s)
+}
+
+// isValidTemplateName reports whether s is a safe name of an OS template, e.g. centos-7-x86_64,
+// which never starts with "-" either
+func isValidTemplateName(s string) bool {
+	return templateNameRegexp.MatchString(s)
+}
+
+func parseHostname(raw json.RawMessage) (string, error) {
+	s, err := parseString(raw)
+	if err != nil {
+		return "", err
+	}
+	if !isValidHostname(s) {
+		return "", errors.New("must be a valid host name")
+	}
+
+	return s, nil
+}
+
+// parseInt returns a parser of integers not less than min and, unless max is 0, not greater than max
+func parseInt(min, max int) parameterParser {
+	return func(raw json.RawMessage) (string, error) {
+		var i int
+		if err := json.Unmarshal(raw, &i); err != nil {
+			return "", errors.New("must be an integer")
+		}
+		if i < min {
+			return "", errors.New("must be at least " + strconv.Itoa(min))
+		}
+		if max > 0 && i > max {
+			return "", errors.New("must be at most " + strconv.Itoa(max))
+		}
+
+		return strconv.Itoa(i), nil
+	}
+}
+
+func parseOneOf(values ...string) parameterParser {
+	return func(raw json.RawMessage) (string, error) {
+		s, err := parseString(raw)
+		if err != nil {
+			return "", err
+		}
+		for _, v := range values {
+			if s == v {
+				return s, nil
+			}
+		}
+
+		return "", errors.New("must be one of " + strings.Join(values, ", "))
+	}
+}
+
+// parseList returns a parser of a value or a list of values, which are joined with commanders.ListSeparator,
+// so that a command gets every value in its own argument
+func parseList(parse parameterParser) parameterParser {
+	return func(raw json.RawMessage) (string, error) {
+		var elements []json.RawMessage
+		if err := json.Unmarshal(raw, &elements); err != nil {
+			return parse(raw)
+		}
+		if len(elements) == 0 {
+			return "", errors.New("must not be empty")
+		}
+
+		values := make([]string, 0, len(elements))
+		for i, element := range elements {
+			value, err := parse(element)
+			if err != nil {
+				return "", fmt.Errorf("element %d %s", i+1, err.Error())
+			}
+			values = append(values, value)
+		}
+
+		return strings.Join(values, commanders.ListSeparator), nil
+	}
+}
+
+func parseIP(raw json.RawMessage) (string, error) {
+	s, err := parseString(raw)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(s) == nil {
+		return "", errors.New("must be an IP address")
+	}
+
+	return s, nil
+}
+
+// parseIPAddress parses an IP address with an optional prefix length
+func parseIPAddress(raw json.RawMessage) (string, error) {
+	s, err := parseString(raw)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(s) == nil {
+		if _, _, err := net.ParseCIDR(s); err != nil {
+			return "", errors.New("must be an IP address with an optional prefix length")
+		}
+	}
+
+	return s, nil
+}
+
+func parseDescription(raw json.RawMessage) (string, error) {
+	s, err := parseString(raw)
+	if err != nil {
+		return "", err
+	}
+	if len(s) > MaxDescriptionLength {
+		return "", errors.New("must be at most " + strconv.Itoa(MaxDescriptionLength) + " characters long")
+	}
+
+	return s, nil
+}
+
+// parameterComparers compare values of parameters which may be written in different ways,
+// e.g. by a host. Other parameters are compared as they are.
+var parameterComparers = map[string]func(a, b string) bool{
+	HostnameParam:   strings.EqualFold,
+	IPAddParam:      sameList(sameIPAddress),
+	NameserverParam: sameList(sameIP),
+}
+
+// hostDefaultParameters are limits a host sets on its own unless they are set for a container
+var hostDefaultParameters = map[string]bool{CPUsParam: true, MemSizeParam: true, SizeParam: true}
+
+// sizeUnits are units of sizes, each 1024 times larger than the previous one
+const sizeUnits = "KMGT"
+
+// ParameterMismatches returns sorted names of parameters of a container differing from ones
+// reported by a host. Only parameters reported by a host are compared, sizes along with their units.
+// Limits not set for a container are host defaults rather than mismatches.
+func ParameterMismatches(expected, actual map[string]string) []string {
+	isUnits := make(map[string]bool, len(UnitsParameters))
+	for _, unitsParam := range UnitsParameters {
+		isUnits[unitsParam] = true
+	}
+
+	names := make([]string, 0)
+	for name := range actual {
+		if isUnits[name] {
+			continue
+		}
+		if _, ok := expected[name]; !ok && hostDefaultParameters[name] {
+			continue
+		}
+		if !sameParameter(name, expected, actual) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// FormatParameter returns a value of a parameter, followed by its units if it is a size
+func FormatParameter(parameters map[string]string, name string) string {
+	value := parameters[name]
+	if unitsParam, ok := UnitsParameters[name]; ok && value != "" {
+		units := parameters[unitsParam]
+		if units == "" {
+			units = DefaultSizeUnits
+		}
+		return value + units
+	}
+
+	return value
+}
+
+func sameParameter(name string, expected, actual map[string]string) bool {
+	a, b := expected[name], actual[name]
+
+	if unitsParam, ok := UnitsParameters[name]; ok {
+		aBytes, aOK := sizeBytes(a, expected[unitsParam])
+		bBytes, bOK := sizeBytes(b, actual[unitsParam])
+		return aOK && bOK && aBytes == bBytes
+	}
+	if compare, ok := parameterComparers[name]; ok {
+		return compare(a, b)
+	}
+
+	return a == b
+}
+
+// sizeBytes returns a size given in units in bytes
+func sizeBytes(value, units string) (int64, bool) {
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if units == "" {
+		units = DefaultSizeUnits
+	}
+	i := strings.Index(sizeUnits, units)
+	if i < 0 || len(units) != 1 {
+		return 0, false
+	}
+
+	return size << (10 * (i + 1)), true
+}
+
+// sameList returns a comparer of lists with elements in any order
+func sameList(same func(a, b string) bool) func(a, b string) bool {
+	split := func(s string) []string {
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, commanders.ListSeparator)
+	}
+
+	return func(a, b string) bool {
+		as, bs := split(a), split(b)
+		if len(as) != len(bs) {
+			return false
+		}
+
+		matched := make([]bool, len(bs))
+		for _, x := range as {
+			found := false
+			for i, y := range bs {
+				if !matched[i] && same(x, y) {
+					matched[i], found = true, true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+
+		return true
+	}
+}
+
+func sameIP(a, b string) bool {
+	aIP, bIP := net.ParseIP(a), net.ParseIP(b)
+	if aIP == nil || bIP == nil {
+		return a == b
+	}
+
+	return aIP.Equal(bIP)
+}
+
+// sameIPAddress compares IP addresses, and their prefix lengths unless one of them has none,
+// as hosts may report addresses without them or with netmasks instead
+func sameIPAddress(a, b string) bool {
+	aIP, aPrefix := splitIPAddress(a)
+	bIP, bPrefix := splitIPAddress(b)
+	if aIP == nil || bIP == nil {
+		return a == b
+	}
+
+	return aIP.Equal(bIP) && (aPrefix < 0 || bPrefix < 0 || aPrefix == bPrefix)
+}
+
+// splitIPAddress parses an IP address followed by an optional prefix length or netmask,
+// a prefix length is -1 if there is none
+func splitIPAddress(s string) (net.IP, int) {
+	address, suffix := s, ""
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		address, suffix = s[:i], s[i+1:]
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil || suffix == "" {
+		return ip, -1
+	}
+	if mask := net.ParseIP(suffix); mask != nil && mask.To4() != nil {
+		ones, bits := net.IPMask(mask.To4()).Size()
+		if bits == 0 {
+			return nil, -1
+		}
+		return ip, ones
+	}
+	prefix, err := strconv.Atoi(suffix)
+	if err != nil {
+		return nil, -1
+	}
+
+	return ip, prefix
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestValidateUpdateContainerRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  string
+		replace bool
+		want    ContainerParametersPatch
+		errs    ValidationErrors
+	}{
+		{
+			name:   "typed values",
+			params: `{"hostname": "web.example.com", "cpus": 2, "memsize": 512, "memsize_units": "G", "ipadd": "10.0.0.2/24", "nameserver": "8.8.8.8"}`,
+			want: ContainerParametersPatch{
+				HostnameParam: strPtr("web.example.com"), CPUsParam: strPtr("2"), MemSizeParam: strPtr("512"),
+				MemSizeUnitsParam: strPtr("G"), IPAddParam: strPtr("10.0.0.2/24"), NameserverParam: strPtr("8.8.8.8"),
+			},
+		},
+		{
+			name:   "null removes a parameter",
+			params: `{"description": null}`,
+			want:   ContainerParametersPatch{DescriptionParam: nil},
+		},
+		{
+			name:    "null on replace",
+			params:  `{"description": null}`,
+			replace: true,
+			errs:    ValidationErrors{DescriptionParam: "must not be null"},
+		},
+		{
+			name:   "invalid values",
+			params: `{"hostname": "-web", "cpus": "2", "memsize": 0, "size_units": "P", "nameserver": "dns", "userpasswd": "root:secret"}`,
+			errs: ValidationErrors{
+				HostnameParam:   "must be a valid host name",
+				CPUsParam:       "must be an integer",
+				MemSizeParam:    "must be at least 1",
+				SizeUnitsParam:  "must be one of K, M, G, T",
+				NameserverParam: "must be an IP address",
+				"userpasswd":    "is unknown",
+			},
+		},
+		{
+			name:   "units without a size",
+			params: `{"memsize_units": "G"}`,
+			errs:   ValidationErrors{MemSizeUnitsParam: "requires memsize"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &UpdateContainerRequest{ID: "ct-1", Replace: tt.replace}
+			if err := json.Unmarshal([]byte(tt.params), &req.Parameters); err != nil {
+				t.Fatal(err)
+			}
+
+			err := ValidateUpdateContainerRequest(req)
+			if tt.errs != nil {
+				if !reflect.DeepEqual(err, tt.errs) {
+					t.Errorf("ValidateUpdateContainerRequest() = %v, want %v", err, tt.errs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(req.Patch, tt.want) {
+				t.Errorf("Patch = %v, want %v", req.Patch, tt.want)
+			}
+		})
+	}
+}
+
+func TestParameterMismatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected map[string]string
+		actual   map[string]string
+		want     []string
+	}{
+		{
+			name:     "sizes in different units",
+			expected: map[string]string{MemSizeParam: "1", MemSizeUnitsParam: "G", SizeParam: "10240"},
+			actual:   map[string]string{MemSizeParam: "1048576", MemSizeUnitsParam: "K", SizeParam: "10485760", SizeUnitsParam: "K"},
+			want:     []string{},
+		},
+		{
+			name:     "different sizes",
+			expected: map[string]string{MemSizeParam: "512", CPUsParam: "2"},
+			actual:   map[string]string{MemSizeParam: "1048576", MemSizeUnitsParam: "K", CPUsParam: "4"},
+			want:     []string{CPUsParam, MemSizeParam},
+		},
+		{
+			name:     "limits set by a host",
+			expected: map[string]string{},
+			actual:   map[string]string{CPUsParam: "4", MemSizeParam: "1048576", MemSizeUnitsParam: "K", SizeParam: "1", SizeUnitsParam: "T"},
+			want:     []string{},
+		},
+		{
+			name:     "addresses in any order, with prefixes or netmasks",
+			expected: map[string]string{IPAddParam: "10.0.0.2/24,10.0.0.3", NameserverParam: "8.8.8.8,::1"},
+			actual:   map[string]string{IPAddParam: "10.0.0.3/32,10.0.0.2/255.255.255.0", NameserverParam: "0:0::1,8.8.8.8"},
+			want:     []string{},
+		},
+		{
+			name:     "different addresses",
+			expected: map[string]string{IPAddParam: "10.0.0.2/24", NameserverParam: "8.8.8.8"},
+			actual:   map[string]string{IPAddParam: "10.0.0.2/16", NameserverParam: "8.8.8.8,8.8.4.4"},
+			want:     []string{IPAddParam, NameserverParam},
+		},
+		{
+			name:     "hostname and description",
+			expected: map[string]string{HostnameParam: "CT1.example.com", DescriptionParam: "web"},
+			actual:   map[string]string{HostnameParam: "ct1.example.com", DescriptionParam: "Web"},
+			want:     []string{DescriptionParam},
+		},
+		{
+			name:     "parameters unset in the database",
+			expected: map[string]string{},
+			actual:   map[string]string{HostnameParam: "ct1", DescriptionParam: "", IPAddParam: ""},
+			want:     []string{HostnameParam},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParameterMismatches(tt.expected, tt.actual)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParameterMismatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatParameter(t *testing.T) {
+	parameters := map[string]string{MemSizeParam: "512", SizeParam: "10", SizeUnitsParam: "G", CPUsParam: "2"}
+
+	for name, want := range map[string]string{MemSizeParam: "512M", SizeParam: "10G", CPUsParam: "2", HostnameParam: ""} {
+		if got := FormatParameter(parameters, name); got != want {
+			t.Errorf("FormatParameter(%s) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -16,7 +16,11 @@
 
 package api
 
-import "github.com/romiras/go-openvz-api/models"
+import (
+	"time"
+
+	"github.com/romiras/go-openvz-api/models"
+)
 
 type (
 	ApiResponse struct {
@@ -25,9 +29,27 @@ type (
 		Message string `json:"message,omitempty"`
 	}
 
+	// ValidationErrorResponse lists errors of invalid fields of a request
+	ValidationErrorResponse struct {
+		ApiResponse
+		Errors ValidationErrors `json:"errors"`
+	}
+
 	AddContainerResponse struct {
 		ApiResponse
 		JobID string `json:"job_id,omitempty"`
+		// Commands are only rendered by dry runs
+		DryRun   bool     `json:"dry_run,omitempty"`
+		Commands []string `json:"commands,omitempty"`
+	}
+
+	// JobResponse is returned by requests executed asynchronously as jobs
+	JobResponse struct {
+		ApiResponse
+		JobID string `json:"job_id,omitempty"`
+		// Commands are only rendered by dry runs
+		DryRun   bool     `json:"dry_run,omitempty"`
+		Commands []string `json:"commands,omitempty"`
 	}
 
 	GetContainerByIdResponse struct {
@@ -40,10 +62,256 @@ type (
 		Containers []*models.Container `json:"containers"`
 	}
 
+	JobInfo struct {
+		ID          string     `json:"id"`
+		Type        string     `json:"type"`
+		Status      string     `json:"status"`
+		EntityType  *string    `json:"entity_type,omitempty"`
+		EntityID    *string    `json:"entity_id,omitempty"`
+		Attempts    int        `json:"attempts"`
+		MaxAttempts int        `json:"max_attempts"`
+		LastError   *string    `json:"last_error,omitempty"`
+		NextRunAt   *time.Time `json:"next_run_at,omitempty"`
+		DryRun      bool       `json:"dry_run,omitempty"`
+		CreatedAt   time.Time  `json:"created_at"`
+	}
+
 	GetJobByIdResponse struct {
 		ApiResponse
-		Status     string  `json:"status"`
-		EntityType *string `json:"entity_type,omitempty"`
-		EntityID   *string `json:"entity_id,omitempty"`
+		Status      string     `json:"status"`
+		EntityType  *string    `json:"entity_type,omitempty"`
+		EntityID    *string    `json:"entity_id,omitempty"`
+		Attempts    int        `json:"attempts"`
+		MaxAttempts int        `json:"max_attempts"`
+		LastError   *string    `json:"last_error,omitempty"`
+		NextRunAt   *time.Time `json:"next_run_at,omitempty"`
+		DryRun      bool       `json:"dry_run,omitempty"`
+		CreatedAt   time.Time  `json:"created_at"`
+	}
+
+	JobLogInfo struct {
+		Command    string     `json:"command"`
+		ExitCode   *int       `json:"exit_code,omitempty"`
+		Stdout     string     `json:"stdout"`
+		Stderr     string     `json:"stderr"`
+		StartedAt  time.Time  `json:"started_at"`
+		FinishedAt *time.Time `json:"finished_at,omitempty"`
+	}
+
+	ListJobLogsResponse struct {
+		ApiResponse
+		Logs []*JobLogInfo `json:"logs"`
+	}
+
+	JobStatusEvent struct {
+		Status    string  `json:"status"`
+		Attempts  int     `json:"attempts"`
+		EntityID  *string `json:"entity_id,omitempty"`
+		LastError *string `json:"last_error,omitempty"`
+	}
+
+	JobProgressEvent struct {
+		Percent int `json:"percent"`
+	}
+
+	// JobLogEvent carries a command line, output or an exit code of a command run by a job
+	JobLogEvent struct {
+		Stream string `json:"stream"`
+		Text   string `json:"text"`
+	}
+
+	ContainerEvent struct {
+		ID         string `json:"id"`
+		Name       string `json:"name"`
+		OSTemplate string `json:"ostemplate,omitempty"`
+	}
+
+	ListJobsResponse struct {
+		ApiResponse
+		Jobs []*JobInfo `json:"jobs"`
+	}
+
+	WebhookInfo struct {
+		ID        string    `json:"id"`
+		URL       string    `json:"url"`
+		Events    []string  `json:"events"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	AddWebhookResponse struct {
+		ApiResponse
+		Webhook *WebhookInfo `json:"webhook"`
+		// Secret signing payloads is only returned on creation of a webhook
+		Secret string `json:"secret"`
+	}
+
+	GetWebhookByIdResponse struct {
+		ApiResponse
+		Webhook *WebhookInfo `json:"webhook"`
+	}
+
+	ListWebhooksResponse struct {
+		ApiResponse
+		Webhooks []*WebhookInfo `json:"webhooks"`
+	}
+
+	WebhookDeliveryInfo struct {
+		ID           string     `json:"id"`
+		Event        string     `json:"event"`
+		Status       string     `json:"status"`
+		Attempts     int        `json:"attempts"`
+		ResponseCode *int       `json:"response_code,omitempty"`
+		LastError    *string    `json:"last_error,omitempty"`
+		CreatedAt    time.Time  `json:"created_at"`
+		DeliveredAt  *time.Time `json:"delivered_at,omitempty"`
+	}
+
+	ListWebhookDeliveriesResponse struct {
+		ApiResponse
+		Deliveries []*WebhookDeliveryInfo `json:"deliveries"`
+	}
+
+	SnapshotInfo struct {
+		ID          string    `json:"id"`
+		ContainerID string    `json:"container_id"`
+		Name        string    `json:"name"`
+		Description string    `json:"description"`
+		State       string    `json:"state"`
+		PolicyID    string    `json:"policy_id,omitempty"`
+		CreatedAt   time.Time `json:"created_at"`
+	}
+
+	// AddSnapshotResponse returns a snapshot being taken by a job. A dry run only renders commands.
+	AddSnapshotResponse struct {
+		ApiResponse
+		JobID    string        `json:"job_id,omitempty"`
+		Snapshot *SnapshotInfo `json:"snapshot,omitempty"`
+		DryRun   bool          `json:"dry_run,omitempty"`
+		Commands []string      `json:"commands,omitempty"`
+	}
+
+	GetSnapshotByIdResponse struct {
+		ApiResponse
+		Snapshot *SnapshotInfo `json:"snapshot"`
+	}
+
+	ListSnapshotsResponse struct {
+		ApiResponse
+		Snapshots []*SnapshotInfo `json:"snapshots"`
+	}
+
+	BackupInfo struct {
+		ID            string     `json:"id"`
+		ContainerID   string     `json:"container_id"`
+		ContainerName string     `json:"container_name"`
+		OSTemplate    string     `json:"ostemplate"`
+		Description   string     `json:"description"`
+		State         string     `json:"state"`
+		Archive       string     `json:"archive,omitempty"`
+		Size          *int64     `json:"size,omitempty"`
+		Checksum      string     `json:"checksum,omitempty"`
+		ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+		PolicyID      string     `json:"policy_id,omitempty"`
+		CreatedAt     time.Time  `json:"created_at"`
+	}
+
+	// AddBackupResponse returns a backup being made by a job. A dry run only renders commands.
+	AddBackupResponse struct {
+		ApiResponse
+		JobID    string      `json:"job_id,omitempty"`
+		Backup   *BackupInfo `json:"backup,omitempty"`
+		DryRun   bool        `json:"dry_run,omitempty"`
+		Commands []string    `json:"commands,omitempty"`
+	}
+
+	GetBackupByIdResponse struct {
+		ApiResponse
+		Backup *BackupInfo `json:"backup"`
+	}
+
+	ListBackupsResponse struct {
+		ApiResponse
+		Backups []*BackupInfo `json:"backups"`
+	}
+
+	PolicyInfo struct {
+		*models.Policy
+		// NextRunAt hides a nullable time of a policy
+		NextRunAt    *time.Time `json:"next_run_at,omitempty"`
+		ContainerIDs []string   `json:"container_ids"`
+	}
+
+	// PolicyResponse returns a policy along with containers it is attached to
+	PolicyResponse struct {
+		ApiResponse
+		Policy *PolicyInfo `json:"policy"`
+	}
+
+	ListPoliciesResponse struct {
+		ApiResponse
+		Policies []*PolicyInfo `json:"policies"`
+	}
+
+	// RetentionDecision tells whether an artifact made by a policy is kept, and why
+	RetentionDecision struct {
+		ID        string    `json:"id"`
+		State     string    `json:"state"`
+		CreatedAt time.Time `json:"created_at"`
+		// Reasons are rules keeping an artifact, e.g. "daily"
+		Reasons []string `json:"reasons,omitempty"`
+	}
+
+	// ContainerRetention lists artifacts of a container made by a policy which are kept or pruned
+	ContainerRetention struct {
+		ContainerID   string               `json:"container_id"`
+		ContainerName string               `json:"container_name"`
+		Keep          []*RetentionDecision `json:"keep"`
+		Prune         []*RetentionDecision `json:"prune"`
+	}
+
+	// PolicyPreviewResponse shows upcoming runs of a policy and how its retention rules apply now
+	PolicyPreviewResponse struct {
+		ApiResponse
+		NextRuns   []time.Time           `json:"next_runs"`
+		Containers []*ContainerRetention `json:"containers"`
+	}
+
+	ListTemplatesResponse struct {
+		ApiResponse
+		Templates []*models.Template `json:"templates"`
+	}
+
+	// DriftItem describes a difference between containers in the database and on a host
+	DriftItem struct {
+		Kind        string `json:"kind"`
+		Name        string `json:"name"`
+		ContainerID string `json:"container_id,omitempty"`
+		HostID      string `json:"host_id,omitempty"`
+		Field       string `json:"field,omitempty"`
+		Expected    string `json:"expected,omitempty"`
+		Actual      string `json:"actual,omitempty"`
+		// Resolution is an action taken by the reconciler, if any
+		Resolution string `json:"resolution,omitempty"`
+	}
+
+	DriftResponse struct {
+		ApiResponse
+		CheckedAt time.Time    `json:"checked_at"`
+		Drift     []*DriftItem `json:"drift"`
+	}
+
+	// CommandInfo describes a command run on a host by jobs of given types
+	CommandInfo struct {
+		Name      string   `json:"name"`
+		JobTypes  []string `json:"job_types"`
+		Program   string   `json:"program"`
+		Path      string   `json:"path,omitempty"`
+		Arguments []string `json:"arguments"`
+		Vars      []string `json:"vars"`
+	}
+
+	ListCommandsResponse struct {
+		ApiResponse
+		Commands []*CommandInfo `json:"commands"`
 	}
 )
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"context"
+	"fmt"
+
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+const (
+	VZBackend    = "vz"
+	VZCtlBackend = "vzctl"
+	FakeBackend  = "fake"
+)
+
+// States of containers on a host
+const (
+	HostStopped   = "stopped"
+	HostRunning   = "running"
+	HostSuspended = "suspended"
+)
+
+// DefaultCommandsPaths maps backends to their default commands configs
+var DefaultCommandsPaths = map[string]string{
+	VZBackend:    "vz_commands.yml",
+	VZCtlBackend: "vzctl_commands.yml",
+}
+
+// HostContainer describes a container as it is found on a host
+type HostContainer struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	State      string `json:"state"`
+	OSTemplate string `json:"ostemplate,omitempty"`
+	// Parameters are ones of ct-set vars a backend reports, in the form they are passed to ct-set
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// HostBackup describes a backup of a container as it is found on a host
+type HostBackup struct {
+	// ID identifies a backup in commands
+	ID string `json:"id"`
+	// Archive is a path to an archive of a backup if it is kept in a local directory
+	Archive string `json:"archive,omitempty"`
+}
+
+// HostTemplate describes an OS template installed on a host
+type HostTemplate struct {
+	Name string `json:"name"`
+}
+
+// Commander defines operations for management of containers on a host.
+// Cancelling ctx aborts an operation.
+type Commander interface {
+	CreateContainer(ctx context.Context, name, osTemplate string, options openvzcmd.Options) error
+	SetContainerParameters(ctx context.Context, name string, params openvzcmd.Options) error
+	DeleteContainer(ctx context.Context, name string) error
+	StartContainer(ctx context.Context, name string) error
+	StopContainer(ctx context.Context, name string) error
+	RestartContainer(ctx context.Context, name string) error
+	SuspendContainer(ctx context.Context, name string) error
+	ResumeContainer(ctx context.Context, name string) error
+	ListContainers(ctx context.Context) ([]HostContainer, error)
+	// CloneContainer copies a stopped container along with its parameters to a new one named cloneName
+	CloneContainer(ctx context.Context, name, cloneName string) error
+	// CreateSnapshot takes a snapshot of a container and returns its ID on a host,
+	// which is snapshotID unless a host assigns own IDs
+	CreateSnapshot(ctx context.Context, name, snapshotID, snapshotName, description string) (string, error)
+	DeleteSnapshot(ctx context.Context, name, snapshotID string) error
+	RevertSnapshot(ctx context.Context, name, snapshotID string) error
+	// BackupContainer backs up a container into dir and returns the backup, whose ID is backupID
+	// unless a host assigns own IDs
+	BackupContainer(ctx context.Context, name, backupID, dir string) (HostBackup, error)
+	// RestoreBackup restores a backup into a new container named name
+	RestoreBackup(ctx context.Context, name string, backup HostBackup) error
+	DeleteBackup(ctx context.Context, backup HostBackup) error
+	// ListTemplates returns OS templates containers can be created from
+	ListTemplates(ctx context.Context) ([]HostTemplate, error)
+}
+
+// NewCommander creates a commander for given backend. An empty commandsPath
+// stands for the default commands config of the backend.
+func NewCommander(backend, commandsPath string) (Commander, error) {
+	if commandsPath == "" {
+		commandsPath = DefaultCommandsPaths[backend]
+	}
+
+	switch backend {
+	case VZBackend:
+		return NewVZCommander(commandsPath)
+	case VZCtlBackend:
+		return NewVZCtlCommander(commandsPath)
+	case FakeBackend:
+		return NewFakeCommander(), nil
+	default:
+		return nil, fmt.Errorf("unknown commander backend %s", backend)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Commands lists names of commands a commands config must define
+var Commands = []string{CtCreate, CtSet, CtDelete, CtStart, CtStop, CtRestart, CtSuspend, CtResume, CtList, CtClone,
+	CtSnapshot, CtSnapshotDelete, CtSnapshotSwitch, CtBackup, CtBackupDelete, CtRestore, CtTemplateList}
+
+// ListVars are vars holding lists, e.g. of IP addresses, whose elements are joined with ListSeparator.
+// An argument holding a list var is repeated for every element, e.g. `--ipadd a --ipadd b`.
+var ListVars = map[string]bool{"ipadd": true, "nameserver": true}
+
+const ListSeparator = ","
+
+var placeholderRegexp = regexp.MustCompile(`{{\s*([^{}]*?)\s*}}`)
+
+type (
+	// CommandsMap maps names of commands to their definitions
+	CommandsMap map[string]openvzcmd.ExecCommandInfo
+
+	// CommandsError lists problems found in a commands config
+	CommandsError struct {
+		Path     string
+		Problems []string
+	}
+
+	// CommandCatalog is implemented by commanders running commands of a commands config
+	CommandCatalog interface {
+		Commands() CommandsMap
+	}
+
+	// Reloader is implemented by commanders whose configuration can be reloaded at runtime
+	Reloader interface {
+		Reload() error
+	}
+)
+
+func (e *CommandsError) Error() string {
+	return fmt.Sprintf("invalid commands config %s: %s", e.Path, strings.Join(e.Problems, "; "))
+}
+
+// LoadCommands reads a commands config and validates it
+func LoadCommands(path string) (CommandsMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	commands := make(CommandsMap)
+	err = yaml.UnmarshalStrict(data, &commands)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commands config %s: %w", path, err)
+	}
+
+	problems := ValidateCommands(commands)
+	if len(problems) > 0 {
+		return nil, &CommandsError{Path: path, Problems: problems}
+	}
+
+	return commands, nil
+}
+
+// ValidateCommands checks that all known commands and no others are defined, their programs
+// are found in PATH, and every placeholder in their arguments is declared in vars.
+func ValidateCommands(commands CommandsMap) []string {
+	problems := make([]string, 0)
+
+	for _, name := range Commands {
+		if _, ok := commands[name]; !ok {
+			problems = append(problems, name+" is not defined")
+		}
+	}
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !isKnownCommand(name) {
+			problems = append(problems, name+" is unknown")
+			continue
+		}
+
+		execInfo := commands[name]
+		if execInfo.Program == "" {
+			problems = append(problems, name+": program is not set")
+		} else if _, err := exec.LookPath(execInfo.Program); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: program %s is not found in PATH", name, execInfo.Program))
+		}
+
+		declared := make(map[string]bool, len(execInfo.Vars))
+		for _, v := range execInfo.Vars {
+			declared[v] = true
+		}
+		for _, arg := range execInfo.Arguments {
+			for _, match := range placeholderRegexp.FindAllStringSubmatch(arg, -1) {
+				if match[0] != "{{"+match[1]+"}}" {
+					problems = append(problems, fmt.Sprintf("%s: placeholder %s must have no spaces", name, match[0]))
+				} else if !declared[match[1]] {
+					problems = append(problems, fmt.Sprintf("%s: var %s is not declared", name, match[1]))
+				}
+			}
+		}
+	}
+
+	return problems
+}
+
+func isKnownCommand(name string) bool {
+	for _, command := range Commands {
+		if command == name {
+			return true
+		}
+	}
+
+	return false
+}
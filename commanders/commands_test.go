@@ -0,0 +1,119 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTestCommands writes a commands config defining every command with a given program
+func writeTestCommands(t *testing.T, path, program string, extra string) {
+	t.Helper()
+
+	var config strings.Builder
+	for _, name := range Commands {
+		config.WriteString(name + ":\n  program: " + program + "\n  arguments:\n  - \"{{name}}\"\n  vars:\n  - name\n")
+	}
+	config.WriteString(extra)
+
+	if err := ioutil.WriteFile(path, []byte(config.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadCommands(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands.yml")
+	writeTestCommands(t, path, "true", "")
+
+	commands, err := LoadCommands(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commands) != len(Commands) || commands[CtStart].Program != "true" {
+		t.Errorf("LoadCommands() = %v", commands)
+	}
+
+	writeTestCommands(t, path, "true", "ct-create:\n  program: true\n")
+	if _, err := LoadCommands(path); err == nil {
+		t.Error("LoadCommands() of a command defined twice succeeded")
+	}
+
+	writeTestCommands(t, path, "true", "ct-list:\n  programm: true\n")
+	if _, err := LoadCommands(path); err == nil {
+		t.Error("LoadCommands() of an unknown field succeeded")
+	}
+}
+
+func TestValidateCommands(t *testing.T) {
+	commands := CommandsMap{
+		CtCreate: {Program: "true", Arguments: []string{"{{name}}", "--ostemplate {{ ostemplate }}"}, Vars: []string{"name", "ostemplate"}},
+		CtSet:    {Program: "true", Arguments: []string{"{{name}}", "--cpus {{cpus}}"}, Vars: []string{"name"}},
+		CtDelete: {Program: "no-such-program-here", Arguments: []string{"{{name}}"}, Vars: []string{"name"}},
+		CtStart:  {Arguments: []string{"{{name}}"}, Vars: []string{"name"}},
+		"ct-fly": {Program: "true"},
+	}
+
+	want := make([]string, 0)
+	for _, name := range Commands {
+		if _, ok := commands[name]; !ok {
+			want = append(want, name+" is not defined")
+		}
+	}
+	want = append(want,
+		"ct-create: placeholder {{ ostemplate }} must have no spaces",
+		"ct-delete: program no-such-program-here is not found in PATH",
+		"ct-fly is unknown",
+		"ct-set: var cpus is not declared",
+		"ct-start: program is not set",
+	)
+	if got := ValidateCommands(commands); !reflect.DeepEqual(got, want) {
+		t.Errorf("ValidateCommands() = %q, want %q", got, want)
+	}
+}
+
+func TestVZCommanderReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands.yml")
+	writeTestCommands(t, path, "true", "")
+
+	cmd, err := NewVZCommander(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestCommands(t, path, "false", "")
+	if err := cmd.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if program := cmd.Commands()[CtStart].Program; program != "false" {
+		t.Errorf("Program = %s after a reload, want false", program)
+	}
+
+	// An invalid config keeps the current commands
+	if err := ioutil.WriteFile(path, []byte("ct-start: [\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Reload(); err == nil {
+		t.Error("Reload() of an invalid config succeeded")
+	}
+	if program := cmd.Commands()[CtStart].Program; program != "false" {
+		t.Errorf("Program = %s after a failed reload, want false", program)
+	}
+}
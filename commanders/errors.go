@@ -0,0 +1,112 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// permanentExitCodes maps programs to their exit codes telling failures which will not go away
+// on retry, as documented in EXIT STATUS of vzctl(8). Other exit codes are considered transient,
+// e.g. 9 of vzctl, when a container is locked by another vzctl.
+var permanentExitCodes = map[string]map[int]string{
+	"vzctl": {
+		14: "container does not exist",
+		20: "invalid command line parameter",
+		21: "invalid value of a command line parameter",
+		29: "OS template is not specified",
+		31: "container is not running",
+		32: "container is running",
+		43: "container private area does not exist",
+		44: "container private area already exists",
+		91: "OS template is not found",
+	},
+}
+
+// permanentMessages maps programs which exit with the same code on most failures, like prlctl,
+// to messages they print to stderr on failures which will not go away on retry
+var permanentMessages = map[string][]string{
+	"prlctl": {
+		"could not be found",
+		"already exists",
+		"Unrecognized option",
+		"Invalid usage",
+	},
+}
+
+// PermanentError marks a commander error which will not go away on retry
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks err as not retryable
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// classifyExit marks err of a program which exited with stderr as permanent, if its exit code
+// or message is known to tell a failure which will not go away on retry. A program may be
+// given by its path, e.g. /usr/sbin/vzctl.
+func classifyExit(program string, err error, stderr string) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+
+	program = filepath.Base(program)
+
+	if reason, ok := permanentExitCodes[program][exitErr.ExitCode()]; ok {
+		return Permanent(fmt.Errorf("%s: %w", reason, err))
+	}
+	for _, message := range permanentMessages[program] {
+		if strings.Contains(stderr, message) {
+			return Permanent(fmt.Errorf("%s: %w", message, err))
+		}
+	}
+
+	return err
+}
+
+// IsRetryable reports whether a command failed with err may succeed when run again.
+// A missing program or a command definition is permanent, as well as a cancelled
+// command and one failed with an exit code or a message known to be permanent,
+// see permanentExitCodes, while other failed commands are considered transient.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var permanentErr *PermanentError
+	if errors.As(err, &permanentErr) {
+		return false
+	}
+
+	return !errors.Is(err, exec.ErrNotFound)
+}
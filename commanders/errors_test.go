@@ -0,0 +1,44 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"os/exec"
+	"testing"
+)
+
+func TestClassifyExit(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 32").Run()
+	if exitErr == nil {
+		t.Fatal("Command exited with 0")
+	}
+
+	for _, tc := range []struct {
+		program, stderr string
+		want            bool
+	}{
+		{"vzctl", "", false},
+		{"/usr/sbin/vzctl", "", false},
+		{"vzlist", "", true},
+		{"prlctl", "Failed to start the CT: The CT is already running", true},
+		{"/usr/bin/prlctl", "The CT could not be found", false},
+	} {
+		if got := IsRetryable(classifyExit(tc.program, exitErr, tc.stderr)); got != tc.want {
+			t.Errorf("IsRetryable() of %s exited with 32 and %q = %v, want %v", tc.program, tc.stderr, got, tc.want)
+		}
+	}
+}
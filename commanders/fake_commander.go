@@ -0,0 +1,396 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+// FakeTemplates are OS templates installed on a fake host
+var FakeTemplates = []string{"centos-7-x86_64", "centos-8-x86_64", "debian-10-x86_64", "ubuntu-20.04-x86_64"}
+
+type (
+	fakeContainer struct {
+		OSTemplate string
+		Parameters openvzcmd.Options
+		State      string
+		Snapshots  map[string]*fakeSnapshot
+	}
+
+	fakeSnapshot struct {
+		Parameters openvzcmd.Options
+		State      string
+	}
+
+	// FakeCommander simulates a host in memory, so that API can be run without prlctl installed
+	FakeCommander struct {
+		mu         sync.Mutex
+		containers map[string]*fakeContainer
+		backups    map[string]*fakeContainer
+	}
+)
+
+func NewFakeCommander() *FakeCommander {
+	return &FakeCommander{
+		containers: make(map[string]*fakeContainer),
+		backups:    make(map[string]*fakeContainer),
+	}
+}
+
+func (cmd *FakeCommander) CreateContainer(ctx context.Context, name, osTemplate string, options openvzcmd.Options) error {
+	return cmd.run(ctx, []string{"create", name, osTemplate}, func() error {
+		if _, ok := cmd.containers[name]; ok {
+			return Permanent(fmt.Errorf("container %s already exists", name))
+		}
+
+		parameters := make(openvzcmd.Options)
+		for k, v := range options {
+			parameters[k] = v
+		}
+
+		cmd.containers[name] = &fakeContainer{
+			OSTemplate: osTemplate,
+			Parameters: parameters,
+			State:      HostStopped,
+			Snapshots:  make(map[string]*fakeSnapshot),
+		}
+
+		return nil
+	})
+}
+
+func (cmd *FakeCommander) SetContainerParameters(ctx context.Context, name string, params openvzcmd.Options) error {
+	args := []string{"set", name}
+	for k, v := range redactParams(params) {
+		args = append(args, k+"="+v)
+	}
+	sort.Strings(args[2:])
+
+	return cmd.run(ctx, args, func() error {
+		ct, err := cmd.find(name)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range params {
+			ct.Parameters[k] = v
+		}
+
+		return nil
+	})
+}
+
+func (cmd *FakeCommander) DeleteContainer(ctx context.Context, name string) error {
+	return cmd.run(ctx, []string{"delete", name}, func() error {
+		if _, err := cmd.find(name); err != nil {
+			return err
+		}
+		delete(cmd.containers, name)
+
+		return nil
+	})
+}
+
+func (cmd *FakeCommander) StartContainer(ctx context.Context, name string) error {
+	return cmd.transition(ctx, "start", name, HostRunning, HostStopped)
+}
+
+func (cmd *FakeCommander) StopContainer(ctx context.Context, name string) error {
+	return cmd.transition(ctx, "stop", name, HostStopped, HostRunning, HostSuspended)
+}
+
+func (cmd *FakeCommander) RestartContainer(ctx context.Context, name string) error {
+	return cmd.transition(ctx, "restart", name, HostRunning, HostRunning)
+}
+
+func (cmd *FakeCommander) SuspendContainer(ctx context.Context, name string) error {
+	return cmd.transition(ctx, "suspend", name, HostSuspended, HostRunning)
+}
+
+func (cmd *FakeCommander) ResumeContainer(ctx context.Context, name string) error {
+	return cmd.transition(ctx, "resume", name, HostRunning, HostSuspended)
+}
+
+// run records a simulated command and runs op holding a lock on the host
+func (cmd *FakeCommander) run(ctx context.Context, args []string, op func() error) error {
+	log := record(ctx, commandLine("fake", args))
+	if isDryRun(ctx) {
+		return nil
+	}
+
+	err := ctx.Err()
+	if err == nil {
+		cmd.mu.Lock()
+		err = op()
+		cmd.mu.Unlock()
+	}
+	if err != nil {
+		fmt.Fprintln(log.Stderr(), err.Error())
+		log.Finish(1)
+		return err
+	}
+	log.Finish(0)
+
+	return nil
+}
+
+func (cmd *FakeCommander) find(name string) (*fakeContainer, error) {
+	ct, ok := cmd.containers[name]
+	if !ok {
+		return nil, Permanent(fmt.Errorf("container %s does not exist", name))
+	}
+
+	return ct, nil
+}
+
+func (cmd *FakeCommander) ListContainers(ctx context.Context) ([]HostContainer, error) {
+	containers := make([]HostContainer, 0)
+
+	err := cmd.run(ctx, []string{"list"}, func() error {
+		for name, ct := range cmd.containers {
+			parameters := make(map[string]string, len(ct.Parameters))
+			for k, v := range ct.Parameters {
+				parameters[k] = v
+			}
+
+			containers = append(containers, HostContainer{
+				ID:         name,
+				Name:       name,
+				State:      ct.State,
+				OSTemplate: ct.OSTemplate,
+				Parameters: parameters,
+			})
+		}
+
+		return nil
+	})
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].Name < containers[j].Name
+	})
+
+	return containers, err
+}
+
+func (cmd *FakeCommander) CloneContainer(ctx context.Context, name, cloneName string) error {
+	return cmd.run(ctx, []string{"clone", name, cloneName}, func() error {
+		ct, err := cmd.find(name)
+		if err != nil {
+			return err
+		}
+		if ct.State != HostStopped {
+			return Permanent(fmt.Errorf("container %s is %s", name, ct.State))
+		}
+		if _, ok := cmd.containers[cloneName]; ok {
+			return Permanent(fmt.Errorf("container %s already exists", cloneName))
+		}
+
+		cmd.containers[cloneName] = &fakeContainer{
+			OSTemplate: ct.OSTemplate,
+			Parameters: copyOptions(ct.Parameters),
+			State:      HostStopped,
+			Snapshots:  make(map[string]*fakeSnapshot),
+		}
+
+		return nil
+	})
+}
+
+func (cmd *FakeCommander) CreateSnapshot(ctx context.Context, name, snapshotID, snapshotName, description string) (string, error) {
+	err := cmd.run(ctx, []string{"snapshot", name, snapshotID}, func() error {
+		ct, err := cmd.find(name)
+		if err != nil {
+			return err
+		}
+
+		ct.Snapshots[snapshotID] = &fakeSnapshot{
+			Parameters: copyOptions(ct.Parameters),
+			State:      ct.State,
+		}
+
+		return nil
+	})
+
+	return snapshotID, err
+}
+
+func (cmd *FakeCommander) DeleteSnapshot(ctx context.Context, name, snapshotID string) error {
+	return cmd.run(ctx, []string{"snapshot-delete", name, snapshotID}, func() error {
+		ct, err := cmd.findSnapshot(name, snapshotID)
+		if err != nil {
+			return err
+		}
+		delete(ct.Snapshots, snapshotID)
+
+		return nil
+	})
+}
+
+func (cmd *FakeCommander) RevertSnapshot(ctx context.Context, name, snapshotID string) error {
+	return cmd.run(ctx, []string{"snapshot-switch", name, snapshotID}, func() error {
+		ct, err := cmd.findSnapshot(name, snapshotID)
+		if err != nil {
+			return err
+		}
+
+		snapshot := ct.Snapshots[snapshotID]
+		ct.Parameters = copyOptions(snapshot.Parameters)
+		ct.State = snapshot.State
+
+		return nil
+	})
+}
+
+// BackupContainer keeps a backup in memory. Given a backup directory, it also writes parameters of
+// a container into an archive there, as vzdump does.
+func (cmd *FakeCommander) BackupContainer(ctx context.Context, name, backupID, dir string) (HostBackup, error) {
+	backup := HostBackup{ID: backupID}
+	if dir != "" {
+		backup.Archive = filepath.Join(dir, "fake-"+backupID+".json")
+	}
+
+	err := cmd.run(ctx, []string{"backup", name, backupID}, func() error {
+		ct, err := cmd.find(name)
+		if err != nil {
+			return err
+		}
+
+		saved := &fakeContainer{
+			OSTemplate: ct.OSTemplate,
+			Parameters: copyOptions(ct.Parameters),
+			State:      HostStopped,
+		}
+		if backup.Archive != "" {
+			data, err := json.Marshal(saved)
+			if err != nil {
+				return err
+			}
+			if err := ioutil.WriteFile(backup.Archive, data, 0600); err != nil {
+				return err
+			}
+		}
+		cmd.backups[backupID] = saved
+
+		return nil
+	})
+
+	return backup, err
+}
+
+func (cmd *FakeCommander) RestoreBackup(ctx context.Context, name string, backup HostBackup) error {
+	return cmd.run(ctx, []string{"restore", backup.ID, name}, func() error {
+		ct, ok := cmd.backups[backup.ID]
+		if !ok {
+			return Permanent(fmt.Errorf("backup %s does not exist", backup.ID))
+		}
+		if backup.Archive != "" {
+			if _, err := os.Stat(backup.Archive); err != nil {
+				return Permanent(err)
+			}
+		}
+		if _, ok := cmd.containers[name]; ok {
+			return Permanent(fmt.Errorf("container %s already exists", name))
+		}
+
+		cmd.containers[name] = &fakeContainer{
+			OSTemplate: ct.OSTemplate,
+			Parameters: copyOptions(ct.Parameters),
+			State:      HostStopped,
+			Snapshots:  make(map[string]*fakeSnapshot),
+		}
+
+		return nil
+	})
+}
+
+func (cmd *FakeCommander) DeleteBackup(ctx context.Context, backup HostBackup) error {
+	return cmd.run(ctx, []string{"backup-delete", backup.ID}, func() error {
+		if _, ok := cmd.backups[backup.ID]; !ok {
+			return Permanent(fmt.Errorf("backup %s does not exist", backup.ID))
+		}
+		if backup.Archive != "" {
+			if err := os.Remove(backup.Archive); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		delete(cmd.backups, backup.ID)
+
+		return nil
+	})
+}
+
+func (cmd *FakeCommander) ListTemplates(ctx context.Context) ([]HostTemplate, error) {
+	templates := make([]HostTemplate, 0, len(FakeTemplates))
+
+	err := cmd.run(ctx, []string{"template-list"}, func() error {
+		for _, name := range FakeTemplates {
+			templates = append(templates, HostTemplate{Name: name})
+		}
+
+		return nil
+	})
+
+	return templates, err
+}
+
+// findSnapshot returns a container having a snapshot
+func (cmd *FakeCommander) findSnapshot(name, snapshotID string) (*fakeContainer, error) {
+	ct, err := cmd.find(name)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ct.Snapshots[snapshotID]; !ok {
+		return nil, Permanent(fmt.Errorf("snapshot %s of container %s does not exist", snapshotID, name))
+	}
+
+	return ct, nil
+}
+
+func copyOptions(options openvzcmd.Options) openvzcmd.Options {
+	copied := make(openvzcmd.Options, len(options))
+	for k, v := range options {
+		copied[k] = v
+	}
+
+	return copied
+}
+
+func (cmd *FakeCommander) transition(ctx context.Context, verb, name, to string, from ...string) error {
+	return cmd.run(ctx, []string{verb, name}, func() error {
+		ct, err := cmd.find(name)
+		if err != nil {
+			return err
+		}
+
+		for _, state := range from {
+			if ct.State == state {
+				ct.State = to
+				return nil
+			}
+		}
+
+		return Permanent(fmt.Errorf("container %s is %s", name, ct.State))
+	})
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"context"
+	"testing"
+
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+func TestFakeCommanderTransitions(t *testing.T) {
+	ctx := context.Background()
+	cmd := NewFakeCommander()
+
+	if err := cmd.CreateContainer(ctx, "web", "centos-7", openvzcmd.Options{"cpus": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.CreateContainer(ctx, "web", "centos-7", nil); err == nil {
+		t.Error("CreateContainer() of an existing container succeeded")
+	}
+	if cpus := cmd.containers["web"].Parameters["cpus"]; cpus != "2" {
+		t.Errorf("cpus = %q, want 2", cpus)
+	}
+
+	steps := []struct {
+		name  string
+		run   func(context.Context, string) error
+		state string
+		fails bool
+	}{
+		{"stop stopped", cmd.StopContainer, HostStopped, true},
+		{"start", cmd.StartContainer, HostRunning, false},
+		{"start running", cmd.StartContainer, HostRunning, true},
+		{"suspend", cmd.SuspendContainer, HostSuspended, false},
+		{"restart suspended", cmd.RestartContainer, HostSuspended, true},
+		{"resume", cmd.ResumeContainer, HostRunning, false},
+		{"restart", cmd.RestartContainer, HostRunning, false},
+		{"stop", cmd.StopContainer, HostStopped, false},
+	}
+	for _, step := range steps {
+		err := step.run(ctx, "web")
+		if (err != nil) != step.fails {
+			t.Errorf("%s: error = %v, want failure %v", step.name, err, step.fails)
+		}
+		if state := cmd.containers["web"].State; state != step.state {
+			t.Errorf("%s: state = %s, want %s", step.name, state, step.state)
+		}
+	}
+}
+
+func TestFakeCommanderMissingContainer(t *testing.T) {
+	ctx := context.Background()
+	cmd := NewFakeCommander()
+
+	if err := cmd.SetContainerParameters(ctx, "web", openvzcmd.Options{"cpus": "2"}); err == nil {
+		t.Error("SetContainerParameters() of a missing container succeeded")
+	}
+	if err := cmd.StartContainer(ctx, "web"); err == nil {
+		t.Error("StartContainer() of a missing container succeeded")
+	}
+
+	if err := cmd.CreateContainer(ctx, "web", "centos-7", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.SetContainerParameters(ctx, "web", openvzcmd.Options{"cpus": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.DeleteContainer(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.DeleteContainer(ctx, "web"); err == nil {
+		t.Error("DeleteContainer() of a deleted container succeeded")
+	}
+}
+
+func TestFakeCommanderCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cmd := NewFakeCommander()
+	if err := cmd.CreateContainer(ctx, "web", "centos-7", nil); err != context.Canceled {
+		t.Errorf("CreateContainer() = %v, want %v", err, context.Canceled)
+	}
+	if _, ok := cmd.containers["web"]; ok {
+		t.Error("Cancelled container is created")
+	}
+	if IsRetryable(context.Canceled) {
+		t.Error("Cancelled command is retryable")
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+const redacted = "***"
+
+// secretVars are substrings of names of variables whose values are redacted in recorded command lines
+var secretVars = []string{"pass", "secret", "token", "key"}
+
+type (
+	// Recorder captures commands run by a commander, e.g. to keep them as logs of a job
+	Recorder interface {
+		Record(commandLine string) CommandLog
+	}
+
+	// CommandLog receives output and result of a recorded command
+	CommandLog interface {
+		Stdout() io.Writer
+		Stderr() io.Writer
+		Finish(exitCode int)
+	}
+
+	recorderKey struct{}
+
+	dryRunKey struct{}
+
+	discardLog struct{}
+)
+
+// WithRecorder returns a context making commanders record commands to r
+func WithRecorder(ctx context.Context, r Recorder) context.Context {
+	return context.WithValue(ctx, recorderKey{}, r)
+}
+
+// WithDryRun returns a context making commanders record commands without running them
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+func isDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// record starts recording of a command if ctx has a recorder
+func record(ctx context.Context, commandLine string) CommandLog {
+	if r, ok := ctx.Value(recorderKey{}).(Recorder); ok {
+		return r.Record(commandLine)
+	}
+
+	return discardLog{}
+}
+
+func (discardLog) Stdout() io.Writer { return ioutil.Discard }
+func (discardLog) Stderr() io.Writer { return ioutil.Discard }
+func (discardLog) Finish(int)        {}
+
+// isSecretVar reports whether a value of a command variable must not be recorded
+func isSecretVar(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretVars {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// commandLine renders a command line for recording
+func commandLine(program string, args []string) string {
+	quoted := make([]string, 0, len(args)+1)
+	quoted = append(quoted, program)
+	for _, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+			arg = strconv.Quote(arg)
+		}
+		quoted = append(quoted, arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,35 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import "testing"
+
+func TestIsSecretVar(t *testing.T) {
+	for name, want := range map[string]bool{"userpasswd": true, "API_TOKEN": true, "ssh_key": true, "cpus": false, "hostname": false} {
+		if got := isSecretVar(name); got != want {
+			t.Errorf("isSecretVar(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	got := commandLine("prlctl", []string{"set", "web", "--description", "a web server", ""})
+	want := `prlctl set web --description "a web server" ""`
+	if got != want {
+		t.Errorf("commandLine() = %s, want %s", got, want)
+	}
+}
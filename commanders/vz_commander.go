@@ -0,0 +1,591 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+const (
+	CtCreate  = "ct-create"
+	CtSet     = "ct-set"
+	CtDelete  = "ct-delete"
+	CtStart   = "ct-start"
+	CtStop    = "ct-stop"
+	CtRestart = "ct-restart"
+	CtSuspend = "ct-suspend"
+	CtResume  = "ct-resume"
+	CtList    = "ct-list"
+	CtClone   = "ct-clone"
+
+	CtSnapshot       = "ct-snapshot"
+	CtSnapshotDelete = "ct-snapshot-delete"
+	CtSnapshotSwitch = "ct-snapshot-switch"
+
+	CtBackup       = "ct-backup"
+	CtBackupDelete = "ct-backup-delete"
+	CtRestore      = "ct-restore"
+
+	CtTemplateList = "ct-template-list"
+)
+
+// uuidRegexp matches IDs of snapshots printed by prlctl
+var uuidRegexp = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+
+// archiveRegexp matches paths of archives printed by vzdump-like tools
+var archiveRegexp = regexp.MustCompile(`[^\s'"]+\.(?:tgz|tar(?:\.gz|\.lzo)?)`)
+
+// templateExtensions are extensions of template caches, which are stripped from their names
+var templateExtensions = []string{".tar.gz", ".tar.xz", ".tar.bz2", ".tar.lz4", ".tar"}
+
+type (
+	// VZCommander implements Commander by running commands defined in a commands config
+	// in the format of POCCommanderStub, so that they can be cancelled.
+	VZCommander struct {
+		path      string
+		commands  CommandsMap
+		mu        sync.RWMutex
+		parseList func(data []byte) ([]HostContainer, error)
+	}
+
+	// prlctlContainer is an entry of `prlctl list -a -i -j` output. Without -i entries have
+	// a uuid, a name and a status only.
+	prlctlContainer struct {
+		UUID        string     `json:"uuid"`
+		ID          string     `json:"ID"`
+		Name        string     `json:"Name"`
+		Status      string     `json:"status"`
+		State       string     `json:"State"`
+		OSTemplate  string     `json:"ostemplate"`
+		Hostname    string     `json:"Hostname"`
+		Description string     `json:"Description"`
+		DNSServers  prlctlList `json:"DNS Servers"`
+		// Hardware holds devices by names, e.g. cpu, memory, hdd0 or venet0
+		Hardware map[string]json.RawMessage `json:"Hardware"`
+	}
+
+	// prlctlDevice is a device of a container, having fields relevant to its kind only
+	prlctlDevice struct {
+		// CPUs is a number or "unlimited"
+		CPUs json.RawMessage `json:"cpus"`
+		// Size is like "1024Mb"
+		Size string     `json:"size"`
+		IPs  prlctlList `json:"ips"`
+	}
+
+	// prlctlList is a list printed by prlctl either as a string of space separated values or as an array
+	prlctlList []string
+)
+
+// prlctlSizeRegexp matches sizes printed by prlctl, which are in megabytes unless units are given
+var prlctlSizeRegexp = regexp.MustCompile(`^(\d+)\s*([KMGTkmgt]?)[Bb]?$`)
+
+// NewVZCommander creates a commander running prlctl of Virtuozzo
+func NewVZCommander(path string) (*VZCommander, error) {
+	return newVZCommander(path, parsePrlctlList)
+}
+
+func newVZCommander(path string, parseList func(data []byte) ([]HostContainer, error)) (*VZCommander, error) {
+	commands, err := LoadCommands(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VZCommander{
+		path:      path,
+		commands:  commands,
+		parseList: parseList,
+	}, nil
+}
+
+// Reload reads the commands config again. Commands are replaced only if a new config is valid,
+// commands already running are not affected.
+func (cmd *VZCommander) Reload() error {
+	commands, err := LoadCommands(cmd.path)
+	if err != nil {
+		return err
+	}
+
+	cmd.mu.Lock()
+	cmd.commands = commands
+	cmd.mu.Unlock()
+
+	return nil
+}
+
+// Commands returns a copy of the current commands config
+func (cmd *VZCommander) Commands() CommandsMap {
+	cmd.mu.RLock()
+	defer cmd.mu.RUnlock()
+
+	commands := make(CommandsMap, len(cmd.commands))
+	for name, execInfo := range cmd.commands {
+		commands[name] = execInfo
+	}
+
+	return commands
+}
+
+// CreateContainer runs ct-create. Options are bound to vars of the command, ones it does not refer to are omitted.
+func (cmd *VZCommander) CreateContainer(ctx context.Context, name, osTemplate string, options openvzcmd.Options) error {
+	return cmd.execCommand(ctx, CtCreate, createParams(options, name, osTemplate))
+}
+
+func (cmd *VZCommander) SetContainerParameters(ctx context.Context, name string, params openvzcmd.Options) error {
+	options := openvzcmd.Options{"name": name}
+	for k, v := range params {
+		options[k] = v
+	}
+
+	return cmd.execCommand(ctx, CtSet, options)
+}
+
+func (cmd *VZCommander) DeleteContainer(ctx context.Context, name string) error {
+	return cmd.execNamed(ctx, CtDelete, name)
+}
+
+func (cmd *VZCommander) StartContainer(ctx context.Context, name string) error {
+	return cmd.execNamed(ctx, CtStart, name)
+}
+
+func (cmd *VZCommander) StopContainer(ctx context.Context, name string) error {
+	return cmd.execNamed(ctx, CtStop, name)
+}
+
+func (cmd *VZCommander) RestartContainer(ctx context.Context, name string) error {
+	return cmd.execNamed(ctx, CtRestart, name)
+}
+
+func (cmd *VZCommander) SuspendContainer(ctx context.Context, name string) error {
+	return cmd.execNamed(ctx, CtSuspend, name)
+}
+
+func (cmd *VZCommander) ResumeContainer(ctx context.Context, name string) error {
+	return cmd.execNamed(ctx, CtResume, name)
+}
+
+func (cmd *VZCommander) ListContainers(ctx context.Context) ([]HostContainer, error) {
+	var out bytes.Buffer
+
+	err := cmd.runCommand(ctx, CtList, nil, &out)
+	if err != nil || isDryRun(ctx) {
+		return nil, err
+	}
+
+	containers, err := cmd.parseList(out.Bytes())
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("%s output cannot be parsed: %w", CtList, err))
+	}
+
+	return containers, nil
+}
+
+func (cmd *VZCommander) CloneContainer(ctx context.Context, name, cloneName string) error {
+	return cmd.execCommand(ctx, CtClone, openvzcmd.Options{"name": name, "clone_name": cloneName})
+}
+
+// CreateSnapshot runs ct-snapshot. A host ID of a snapshot is the first UUID printed by the command,
+// e.g. by prlctl which assigns own IDs, or snapshotID if none is printed.
+func (cmd *VZCommander) CreateSnapshot(ctx context.Context, name, snapshotID, snapshotName, description string) (string, error) {
+	var out bytes.Buffer
+
+	params := openvzcmd.Options{"name": name, "snapshot_id": snapshotID}
+	if snapshotName != "" {
+		params["snapshot_name"] = snapshotName
+	}
+	if description != "" {
+		params["snapshot_description"] = description
+	}
+
+	err := cmd.runCommand(ctx, CtSnapshot, params, &out)
+	if err != nil {
+		return "", err
+	}
+
+	if hostID := uuidRegexp.FindString(out.String()); hostID != "" {
+		return hostID, nil
+	}
+
+	return snapshotID, nil
+}
+
+func (cmd *VZCommander) DeleteSnapshot(ctx context.Context, name, snapshotID string) error {
+	return cmd.execCommand(ctx, CtSnapshotDelete, openvzcmd.Options{"name": name, "snapshot_id": snapshotID})
+}
+
+func (cmd *VZCommander) RevertSnapshot(ctx context.Context, name, snapshotID string) error {
+	return cmd.execCommand(ctx, CtSnapshotSwitch, openvzcmd.Options{"name": name, "snapshot_id": snapshotID})
+}
+
+func (cmd *VZCommander) BackupContainer(ctx context.Context, name, backupID, dir string) (HostBackup, error) {
+	return cmd.backup(ctx, openvzcmd.Options{"name": name, "backup_id": backupID, "backup_dir": dir}, backupID)
+}
+
+// backup runs ct-backup. A backup is identified by the first UUID printed by the command, e.g. by prlctl
+// which assigns own IDs, or backupID if none is printed. An archive of a backup is the first path
+// of an archive printed by the command, e.g. by vzdump, if any.
+func (cmd *VZCommander) backup(ctx context.Context, params openvzcmd.Options, backupID string) (HostBackup, error) {
+	var out bytes.Buffer
+
+	err := cmd.runCommand(ctx, CtBackup, params, &out)
+	if err != nil {
+		return HostBackup{}, err
+	}
+
+	backup := HostBackup{
+		ID:      backupID,
+		Archive: archiveRegexp.FindString(out.String()),
+	}
+	if hostID := uuidRegexp.FindString(out.String()); hostID != "" {
+		backup.ID = hostID
+	}
+
+	return backup, nil
+}
+
+func (cmd *VZCommander) RestoreBackup(ctx context.Context, name string, backup HostBackup) error {
+	return cmd.execCommand(ctx, CtRestore, backupParams(openvzcmd.Options{"name": name}, backup))
+}
+
+func (cmd *VZCommander) DeleteBackup(ctx context.Context, backup HostBackup) error {
+	return cmd.execCommand(ctx, CtBackupDelete, backupParams(openvzcmd.Options{}, backup))
+}
+
+// backupParams adds vars of a backup to params. An archive is omitted if a backup has none.
+func backupParams(params openvzcmd.Options, backup HostBackup) openvzcmd.Options {
+	params["backup_id"] = backup.ID
+	if backup.Archive != "" {
+		params["archive"] = backup.Archive
+	}
+
+	return params
+}
+
+// ListTemplates runs ct-template-list. A template is the first field of every line printed by the command,
+// e.g. by `vzpkg list -O` or `ls` of a template cache directory, whose archive extensions are stripped.
+func (cmd *VZCommander) ListTemplates(ctx context.Context) ([]HostTemplate, error) {
+	var out bytes.Buffer
+
+	err := cmd.runCommand(ctx, CtTemplateList, nil, &out)
+	if err != nil || isDryRun(ctx) {
+		return nil, err
+	}
+
+	return parseTemplates(out.String()), nil
+}
+
+// createParams returns params of ct-create, in which name and osTemplate override options
+func createParams(options openvzcmd.Options, name, osTemplate string) openvzcmd.Options {
+	params := make(openvzcmd.Options, len(options)+2)
+	for k, v := range options {
+		params[k] = v
+	}
+	params["name"] = name
+	params["ostemplate"] = osTemplate
+
+	return params
+}
+
+func (cmd *VZCommander) execNamed(ctx context.Context, command, name string) error {
+	return cmd.execCommand(ctx, command, openvzcmd.Options{"name": name})
+}
+
+func (cmd *VZCommander) execCommand(ctx context.Context, command string, params openvzcmd.Options) error {
+	return cmd.runCommand(ctx, command, params, nil)
+}
+
+// runCommand runs a command, copying its stdout to out unless it is nil
+func (cmd *VZCommander) runCommand(ctx context.Context, command string, params openvzcmd.Options, out io.Writer) error {
+	cmd.mu.RLock()
+	execInfo, ok := cmd.commands[command]
+	cmd.mu.RUnlock()
+	if !ok {
+		return Permanent(fmt.Errorf("command %s is not defined", command))
+	}
+
+	log := record(ctx, commandLine(execInfo.Program, bindVars(&execInfo, redactParams(params))))
+	if isDryRun(ctx) {
+		return nil
+	}
+
+	execCmd := exec.CommandContext(ctx, execInfo.Program, bindVars(&execInfo, params)...)
+	execCmd.Stdout = log.Stdout()
+	if out != nil {
+		execCmd.Stdout = io.MultiWriter(out, log.Stdout())
+	}
+	var stderr bytes.Buffer
+	execCmd.Stderr = io.MultiWriter(&stderr, log.Stderr())
+
+	err := execCmd.Run()
+	log.Finish(exitCode(err))
+
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return classifyExit(execInfo.Program, err, stderr.String())
+}
+
+func parsePrlctlList(data []byte) ([]HostContainer, error) {
+	var entries []prlctlContainer
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	containers := make([]HostContainer, 0, len(entries))
+	for _, entry := range entries {
+		id := entry.UUID
+		if id == "" {
+			id = strings.Trim(entry.ID, "{}")
+		}
+		status := entry.Status
+		if status == "" {
+			status = entry.State
+		}
+
+		containers = append(containers, HostContainer{
+			ID:    id,
+			Name:  entry.Name,
+			State: hostState(status),
+			// A leading dot marks a template cached on a host
+			OSTemplate: strings.TrimPrefix(entry.OSTemplate, "."),
+			Parameters: entry.parameters(),
+		})
+	}
+
+	return containers, nil
+}
+
+// parameters returns ct-set vars of a container as they are set by vz_commands.yml, sizes in kilobytes.
+// An entry without -i has none. Limits which are not set are left out.
+func (entry *prlctlContainer) parameters() map[string]string {
+	if entry.Hardware == nil {
+		return nil
+	}
+
+	parameters := map[string]string{
+		"hostname":    entry.Hostname,
+		"description": entry.Description,
+		"nameserver":  strings.Join(entry.DNSServers, ListSeparator),
+	}
+
+	var ips []string
+	names := make([]string, 0, len(entry.Hardware))
+	for name := range entry.Hardware {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// Devices which are not objects, e.g. flags, have nothing of interest
+		var device prlctlDevice
+		if json.Unmarshal(entry.Hardware[name], &device) != nil {
+			continue
+		}
+
+		switch {
+		case name == "cpu":
+			if cpus, err := strconv.Atoi(strings.Trim(string(device.CPUs), `"`)); err == nil {
+				parameters["cpus"] = strconv.Itoa(cpus)
+			}
+		case name == "memory":
+			if size, ok := prlctlSizeK(device.Size); ok {
+				parameters["memsize"] = strconv.FormatInt(size, 10)
+				parameters["memsize_units"] = "K"
+			}
+		case name == "hdd0":
+			if size, ok := prlctlSizeK(device.Size); ok {
+				parameters["size"] = strconv.FormatInt(size, 10)
+				parameters["size_units"] = "K"
+			}
+		case strings.HasPrefix(name, "venet") || strings.HasPrefix(name, "net"):
+			ips = append(ips, device.IPs...)
+		}
+	}
+	parameters["ipadd"] = strings.Join(ips, ListSeparator)
+
+	return parameters
+}
+
+// prlctlSizeK returns a size printed by prlctl in kilobytes
+func prlctlSizeK(s string) (int64, bool) {
+	m := prlctlSizeRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || size == 0 {
+		return 0, false
+	}
+
+	units := strings.ToUpper(m[2])
+	if units == "" {
+		units = "M"
+	}
+	for i := strings.Index("KMGT", units); i > 0; i-- {
+		size *= 1024
+	}
+
+	return size, true
+}
+
+func (l *prlctlList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = strings.Fields(s)
+		return nil
+	}
+
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*l = values
+
+	return nil
+}
+
+// hostState maps a status reported by prlctl or vzlist to a state of a container on a host
+func hostState(status string) string {
+	switch status {
+	case "running":
+		return HostRunning
+	case "suspended", "paused":
+		return HostSuspended
+	default:
+		return HostStopped
+	}
+}
+
+// exitCode returns an exit code of a command run with err, or -1 if it has not exited
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+func redactParams(params openvzcmd.Options) openvzcmd.Options {
+	redactedParams := make(openvzcmd.Options, len(params))
+	for k, v := range params {
+		if isSecretVar(k) {
+			v = redacted
+		}
+		redactedParams[k] = v
+	}
+
+	return redactedParams
+}
+
+// bindVars substitutes vars in arguments of a command. An argument like "--cpus {{cpus}}"
+// is split into words before substitution, so that values may contain spaces. Arguments
+// referring to vars missing in params are omitted, so that commands like ct-set apply
+// given parameters only.
+func bindVars(execInfo *openvzcmd.ExecCommandInfo, params openvzcmd.Options) []string {
+	args := make([]string, 0, len(execInfo.Arguments))
+
+	for _, arg := range execInfo.Arguments {
+		// An argument is repeated for every element of a list var it holds
+		repeats := [][]string{strings.Fields(arg)}
+		bound := true
+		for _, v := range execInfo.Vars {
+			placeholder := "{{" + v + "}}"
+			if !strings.Contains(arg, placeholder) {
+				continue
+			}
+			value, ok := params[v]
+			if !ok {
+				bound = false
+				break
+			}
+			elements := []string{value}
+			if ListVars[v] {
+				elements = strings.Split(value, ListSeparator)
+			}
+
+			bindings := make([][]string, 0, len(repeats)*len(elements))
+			for _, words := range repeats {
+				for _, element := range elements {
+					replaced := make([]string, len(words))
+					for i := range words {
+						replaced[i] = strings.ReplaceAll(words[i], placeholder, element)
+					}
+					bindings = append(bindings, replaced)
+				}
+			}
+			repeats = bindings
+		}
+		if bound {
+			for _, words := range repeats {
+				args = append(args, words...)
+			}
+		}
+	}
+
+	return args
+}
+
+// parseTemplates returns sorted unique templates named by the first fields of lines
+func parseTemplates(output string) []HostTemplate {
+	found := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		name := fields[0]
+		for _, ext := range templateExtensions {
+			if strings.HasSuffix(name, ext) {
+				name = strings.TrimSuffix(name, ext)
+				break
+			}
+		}
+		found[name] = true
+	}
+
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	templates := make([]HostTemplate, 0, len(names))
+	for _, name := range names {
+		templates = append(templates, HostTemplate{Name: name})
+	}
+
+	return templates
+}
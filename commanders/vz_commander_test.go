@@ -0,0 +1,193 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+func TestBindVars(t *testing.T) {
+	execInfo := &openvzcmd.ExecCommandInfo{
+		Program:   "vzctl",
+		Arguments: []string{"set", "{{name}}", "--ipadd {{ipadd}}", "--nameserver {{nameserver}}", "--description {{description}}", "--save"},
+		Vars:      []string{"name", "ipadd", "nameserver", "description"},
+	}
+
+	for _, tc := range []struct {
+		params openvzcmd.Options
+		want   []string
+	}{
+		{
+			params: openvzcmd.Options{"name": "c1", "ipadd": "10.0.0.2/24"},
+			want:   []string{"set", "c1", "--ipadd", "10.0.0.2/24", "--save"},
+		},
+		{
+			params: openvzcmd.Options{"name": "c1", "ipadd": "10.0.0.2/24,10.0.0.3", "nameserver": "8.8.8.8,1.1.1.1"},
+			want:   []string{"set", "c1", "--ipadd", "10.0.0.2/24", "--ipadd", "10.0.0.3", "--nameserver", "8.8.8.8", "--nameserver", "1.1.1.1", "--save"},
+		},
+		{
+			params: openvzcmd.Options{"name": "c1", "description": "web, db"},
+			want:   []string{"set", "c1", "--description", "web, db", "--save"},
+		},
+	} {
+		if got := bindVars(execInfo, tc.params); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("got %q, want %q", got, tc.want)
+		}
+	}
+}
+
+func TestParseTemplates(t *testing.T) {
+	templates := parseTemplates(`ubuntu-20.04-x86_64.tar.gz
+centos-7-x86_64.tar.xz
+
+centos-7-x86_64 2021-03-01 10:00:00
+debian-10-x86_64.tar
+`)
+	want := []HostTemplate{{Name: "centos-7-x86_64"}, {Name: "debian-10-x86_64"}, {Name: "ubuntu-20.04-x86_64"}}
+	if !reflect.DeepEqual(templates, want) {
+		t.Errorf("parseTemplates() = %v, want %v", templates, want)
+	}
+	if templates := parseTemplates(""); len(templates) != 0 {
+		t.Errorf("parseTemplates() of no output = %v", templates)
+	}
+}
+
+// lineRecorder keeps command lines recorded by a commander
+type lineRecorder []string
+
+func (r *lineRecorder) Record(commandLine string) CommandLog {
+	*r = append(*r, commandLine)
+	return discardLog{}
+}
+
+func TestVZCommanderCreateContainerBindsOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands.yml")
+	writeTestCommands(t, path, "true", "")
+	config, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = []byte(strings.Replace(string(config), CtCreate+":\n  program: true\n  arguments:\n  - \"{{name}}\"\n  vars:\n  - name\n",
+		CtCreate+":\n  program: echo\n  arguments:\n  - create\n  - \"{{name}}\"\n  - \"--ostemplate {{ostemplate}}\"\n  - \"--hostname {{hostname}}\"\n"+
+			"  vars:\n  - name\n  - ostemplate\n  - hostname\n", 1))
+	if err := ioutil.WriteFile(path, config, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd, err := NewVZCommander(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lines lineRecorder
+	ctx := WithDryRun(WithRecorder(context.Background(), &lines))
+	for _, options := range []openvzcmd.Options{
+		{"hostname": "web.example.com", "name": "other", "cpus": "2"},
+		nil,
+	} {
+		if err := cmd.CreateContainer(ctx, "web", "centos-7", options); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := lineRecorder{
+		"echo create web --ostemplate centos-7 --hostname web.example.com",
+		"echo create web --ostemplate centos-7",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("Commands = %q, want %q", lines, want)
+	}
+}
+
+func TestParsePrlctlList(t *testing.T) {
+	containers, err := parsePrlctlList([]byte(`[{
+		"ID": "{6f0c5e3a-1d2b-4c5d-8e9f-0a1b2c3d4e5f}",
+		"EnvID": "101",
+		"Name": "web",
+		"Description": "web server",
+		"Type": "CT",
+		"State": "running",
+		"OS": "centos7",
+		"OSTemplate": ".centos-7-x86_64",
+		"Hardware": {
+			"cpu": {"sockets": 1, "cpus": 2, "VT-x": true},
+			"memory": {"size": "1024Mb"},
+			"memory_guarantee": "auto",
+			"hdd0": {"enabled": true, "image": "/vz/private/101/root.hdd", "size": "10Gb"},
+			"venet0": {"enabled": true, "type": "routed", "ips": "10.0.0.2/255.255.255.0 10.0.0.3/255.255.255.0 "}
+		},
+		"Hostname": "web.example",
+		"DNS Servers": "8.8.8.8 1.1.1.1"
+	}, {
+		"ID": "{7a1d6f4b-2e3c-4d6e-9fa0-1b2c3d4e5f60}",
+		"Name": "db",
+		"State": "stopped",
+		"Hardware": {"cpu": {"cpus": "unlimited"}, "memory": {"size": "512Mb"}}
+	}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []HostContainer{
+		{
+			ID:         "6f0c5e3a-1d2b-4c5d-8e9f-0a1b2c3d4e5f",
+			Name:       "web",
+			State:      HostRunning,
+			OSTemplate: "centos-7-x86_64",
+			Parameters: map[string]string{
+				"hostname":      "web.example",
+				"description":   "web server",
+				"cpus":          "2",
+				"memsize":       "1048576",
+				"memsize_units": "K",
+				"size":          "10485760",
+				"size_units":    "K",
+				"ipadd":         "10.0.0.2/255.255.255.0,10.0.0.3/255.255.255.0",
+				"nameserver":    "8.8.8.8,1.1.1.1",
+			},
+		},
+		{
+			ID:    "7a1d6f4b-2e3c-4d6e-9fa0-1b2c3d4e5f60",
+			Name:  "db",
+			State: HostStopped,
+			Parameters: map[string]string{
+				"hostname":      "",
+				"description":   "",
+				"memsize":       "524288",
+				"memsize_units": "K",
+				"ipadd":         "",
+				"nameserver":    "",
+			},
+		},
+	}
+	if !reflect.DeepEqual(containers, want) {
+		t.Errorf("parsePrlctlList() = %+v, want %+v", containers, want)
+	}
+
+	// A listing without -i has no parameters
+	containers, err = parsePrlctlList([]byte(`[{"uuid": "{6f0c5e3a}", "status": "suspended", "name": "web"}]`))
+	if err != nil || len(containers) != 1 || containers[0].State != HostSuspended || containers[0].Parameters != nil {
+		t.Errorf("parsePrlctlList() without -i = %+v, %v", containers, err)
+	}
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+const (
+	// MinCTID is the least numeric ID given to containers created by vzctl,
+	// lower ones are reserved by OpenVZ
+	MinCTID = 101
+
+	// pageSizeK is a size of pages physpages are counted in, in kilobytes
+	pageSizeK = 4
+	// unlimited is a value of limits which are not set
+	unlimited = math.MaxInt64
+)
+
+type (
+	// VZCtlCommander runs vzctl and vzlist of legacy OpenVZ hosts. Containers are referred
+	// to by names, while numeric IDs required by vzctl create are allocated on creation.
+	VZCtlCommander struct {
+		*VZCommander
+		// createMu serializes allocation of IDs
+		createMu sync.Mutex
+	}
+
+	// vzlistContainer is an entry of `vzlist -a -j` output
+	vzlistContainer struct {
+		CTID        int      `json:"ctid"`
+		Name        string   `json:"name"`
+		Status      string   `json:"status"`
+		OSTemplate  string   `json:"ostemplate"`
+		Hostname    string   `json:"hostname"`
+		Description string   `json:"description"`
+		IP          []string `json:"ip"`
+		Nameserver  []string `json:"nameserver"`
+		// CPUs is null when a number of CPUs is not limited
+		CPUs      *int            `json:"cpus"`
+		PhysPages *vzlistResource `json:"physpages"`
+		DiskSpace *vzlistResource `json:"diskspace"`
+	}
+
+	// vzlistResource is a resource of a container, counted in pages for physpages
+	// and in kilobytes for diskspace
+	vzlistResource struct {
+		Limit     int64 `json:"limit"`
+		HardLimit int64 `json:"hardlimit"`
+	}
+)
+
+// NewVZCtlCommander creates a commander running vzctl of OpenVZ 6
+func NewVZCtlCommander(path string) (*VZCtlCommander, error) {
+	cmd, err := newVZCommander(path, parseVzlistList)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VZCtlCommander{VZCommander: cmd}, nil
+}
+
+func (cmd *VZCtlCommander) CreateContainer(ctx context.Context, name, osTemplate string, options openvzcmd.Options) error {
+	cmd.createMu.Lock()
+	defer cmd.createMu.Unlock()
+
+	ctid := MinCTID
+	if !isDryRun(ctx) {
+		containers, err := cmd.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+		ctid = nextCTID(containers)
+	}
+
+	params := createParams(options, name, osTemplate)
+	params["ctid"] = strconv.Itoa(ctid)
+
+	return cmd.execCommand(ctx, CtCreate, params)
+}
+
+// CloneContainer copies a container with ct-clone to a newly allocated ID, then names the copy with ct-set,
+// since vzmlocal refers to containers by IDs only.
+func (cmd *VZCtlCommander) CloneContainer(ctx context.Context, name, cloneName string) error {
+	cmd.createMu.Lock()
+	defer cmd.createMu.Unlock()
+
+	ctid, cloneCTID := name, MinCTID
+	if !isDryRun(ctx) {
+		containers, err := cmd.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+
+		ctid, err = findCTID(containers, name)
+		if err != nil {
+			return err
+		}
+		cloneCTID = nextCTID(containers)
+	}
+
+	err := cmd.execCommand(ctx, CtClone, openvzcmd.Options{"ctid": ctid, "clone_ctid": strconv.Itoa(cloneCTID)})
+	if err != nil {
+		return err
+	}
+
+	return cmd.rename(ctx, cloneCTID, cloneName)
+}
+
+// BackupContainer runs ct-backup given an ID of a container, since vzdump refers to containers by IDs only
+func (cmd *VZCtlCommander) BackupContainer(ctx context.Context, name, backupID, dir string) (HostBackup, error) {
+	ctid := name
+	if !isDryRun(ctx) {
+		containers, err := cmd.ListContainers(ctx)
+		if err != nil {
+			return HostBackup{}, err
+		}
+
+		ctid, err = findCTID(containers, name)
+		if err != nil {
+			return HostBackup{}, err
+		}
+	}
+
+	return cmd.backup(ctx, openvzcmd.Options{"ctid": ctid, "backup_id": backupID, "backup_dir": dir}, backupID)
+}
+
+// RestoreBackup restores a backup with ct-restore to a newly allocated ID, then names the container
+// with ct-set, since vzrestore refers to containers by IDs only
+func (cmd *VZCtlCommander) RestoreBackup(ctx context.Context, name string, backup HostBackup) error {
+	cmd.createMu.Lock()
+	defer cmd.createMu.Unlock()
+
+	ctid := MinCTID
+	if !isDryRun(ctx) {
+		containers, err := cmd.ListContainers(ctx)
+		if err != nil {
+			return err
+		}
+		ctid = nextCTID(containers)
+	}
+
+	err := cmd.execCommand(ctx, CtRestore, backupParams(openvzcmd.Options{"ctid": strconv.Itoa(ctid)}, backup))
+	if err != nil {
+		return err
+	}
+
+	return cmd.rename(ctx, ctid, name)
+}
+
+// rename sets a name of a container given by its ID
+func (cmd *VZCtlCommander) rename(ctx context.Context, ctid int, name string) error {
+	return cmd.execCommand(ctx, CtSet, openvzcmd.Options{"name": strconv.Itoa(ctid), "new_name": name})
+}
+
+// findCTID returns an ID of a container with given name
+func findCTID(containers []HostContainer, name string) (string, error) {
+	for _, ct := range containers {
+		if ct.Name == name {
+			return ct.ID, nil
+		}
+	}
+
+	return "", Permanent(fmt.Errorf("container %s does not exist", name))
+}
+
+// nextCTID returns an ID following the greatest ID of containers
+func nextCTID(containers []HostContainer) int {
+	ctid := MinCTID
+	for _, ct := range containers {
+		if id, err := strconv.Atoi(ct.ID); err == nil && id >= ctid {
+			ctid = id + 1
+		}
+	}
+
+	return ctid
+}
+
+func parseVzlistList(data []byte) ([]HostContainer, error) {
+	var entries []vzlistContainer
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	containers := make([]HostContainer, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name
+		if name == "" {
+			// Containers created without a name are referred to by their IDs
+			name = strconv.Itoa(entry.CTID)
+		}
+
+		containers = append(containers, HostContainer{
+			ID:         strconv.Itoa(entry.CTID),
+			Name:       name,
+			State:      hostState(entry.Status),
+			OSTemplate: entry.OSTemplate,
+			Parameters: entry.parameters(),
+		})
+	}
+
+	return containers, nil
+}
+
+// parameters returns ct-set vars of a container as they are set by vzctl_commands.yml,
+// e.g. --ram sets the limit of physpages. Limits which are not set are left out.
+func (entry *vzlistContainer) parameters() map[string]string {
+	parameters := map[string]string{
+		"hostname":    entry.Hostname,
+		"description": entry.Description,
+		"ipadd":       strings.Join(entry.IP, ListSeparator),
+		"nameserver":  strings.Join(entry.Nameserver, ListSeparator),
+	}
+	if entry.CPUs != nil {
+		parameters["cpus"] = strconv.Itoa(*entry.CPUs)
+	}
+	if entry.PhysPages != nil && entry.PhysPages.Limit > 0 && entry.PhysPages.Limit < unlimited/pageSizeK {
+		parameters["memsize"] = strconv.FormatInt(entry.PhysPages.Limit*pageSizeK, 10)
+		parameters["memsize_units"] = "K"
+	}
+	if entry.DiskSpace != nil && entry.DiskSpace.HardLimit > 0 && entry.DiskSpace.HardLimit < unlimited {
+		parameters["size"] = strconv.FormatInt(entry.DiskSpace.HardLimit, 10)
+		parameters["size_units"] = "K"
+	}
+
+	return parameters
+}
@@ -0,0 +1,246 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package commanders
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+// setenv sets an environment variable for a test
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+
+	prev, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// newStandinCommander returns a commander running vzctl_commands.yml against shell-script stand-ins
+// of OpenVZ 6 tools, which keep containers in a temporary directory
+func newStandinCommander(t *testing.T) *VZCtlCommander {
+	t.Helper()
+
+	standins, err := filepath.Abs("../scripts/standins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setenv(t, "PATH", standins+string(os.PathListSeparator)+os.Getenv("PATH"))
+	setenv(t, "VZ_STANDIN_DIR", t.TempDir())
+
+	cmd, err := NewVZCtlCommander("../vzctl_commands.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cmd
+}
+
+// findContainer returns a container on a host by name
+func findContainer(t *testing.T, cmd Commander, name string) *HostContainer {
+	t.Helper()
+
+	containers, err := cmd.ListContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ct := range containers {
+		if ct.Name == name {
+			return &ct
+		}
+	}
+
+	return nil
+}
+
+func TestVZCtlCommanderContainers(t *testing.T) {
+	ctx := context.Background()
+	cmd := newStandinCommander(t)
+
+	if err := cmd.CreateContainer(ctx, "web", "centos-7", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.SetContainerParameters(ctx, "web", openvzcmd.Options{"hostname": "web.example.com", "cpus": "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ct := findContainer(t, cmd, "web")
+	if ct == nil || ct.ID != "101" || ct.State != HostStopped || ct.OSTemplate != "centos-7" || ct.Parameters["hostname"] != "web.example.com" {
+		t.Fatalf("Container = %+v, want web 101 stopped", ct)
+	}
+
+	if err := cmd.StartContainer(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if ct := findContainer(t, cmd, "web"); ct.State != HostRunning {
+		t.Errorf("State = %s, want %s", ct.State, HostRunning)
+	}
+	if err := cmd.SuspendContainer(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if ct := findContainer(t, cmd, "web"); ct.State != HostSuspended {
+		t.Errorf("State = %s, want %s", ct.State, HostSuspended)
+	}
+	if err := cmd.ResumeContainer(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.DeleteContainer(ctx, "web"); err == nil {
+		t.Error("DeleteContainer() of a running container succeeded")
+	}
+	if err := cmd.StopContainer(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmd.CloneContainer(ctx, "web", "web2"); err != nil {
+		t.Fatal(err)
+	}
+	clone := findContainer(t, cmd, "web2")
+	if clone == nil || clone.ID != "102" || clone.Parameters["hostname"] != "web.example.com" {
+		t.Errorf("Clone = %+v, want web2 102 with parameters of web", clone)
+	}
+
+	// IDs are allocated after the greatest one on a host
+	if err := cmd.CreateContainer(ctx, "db", "centos-7", nil); err != nil {
+		t.Fatal(err)
+	}
+	if ct := findContainer(t, cmd, "db"); ct == nil || ct.ID != "103" {
+		t.Errorf("Container = %+v, want db 103", ct)
+	}
+	if err := cmd.CreateContainer(ctx, "db", "centos-7", nil); err == nil {
+		t.Error("CreateContainer() of a taken name succeeded")
+	}
+
+	if err := cmd.DeleteContainer(ctx, "web"); err != nil {
+		t.Fatal(err)
+	}
+	if ct := findContainer(t, cmd, "web"); ct != nil {
+		t.Errorf("Deleted container = %+v", ct)
+	}
+	if err := cmd.StartContainer(ctx, "web"); err == nil {
+		t.Error("StartContainer() of a deleted container succeeded")
+	}
+}
+
+func TestVZCtlCommanderSnapshots(t *testing.T) {
+	ctx := context.Background()
+	cmd := newStandinCommander(t)
+
+	if err := cmd.CreateContainer(ctx, "web", "centos-7", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.SetContainerParameters(ctx, "web", openvzcmd.Options{"hostname": "before"}); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotID, err := cmd.CreateSnapshot(ctx, "web", "{5c1a}", "before upgrade", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.SetContainerParameters(ctx, "web", openvzcmd.Options{"hostname": "after"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.RevertSnapshot(ctx, "web", snapshotID); err != nil {
+		t.Fatal(err)
+	}
+	if ct := findContainer(t, cmd, "web"); ct.Parameters["hostname"] != "before" {
+		t.Errorf("Parameters = %v, want hostname before", ct.Parameters)
+	}
+
+	if err := cmd.DeleteSnapshot(ctx, "web", snapshotID); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.RevertSnapshot(ctx, "web", snapshotID); err == nil {
+		t.Error("Deleted snapshot is reverted to")
+	}
+}
+
+func TestParseVzlistList(t *testing.T) {
+	containers, err := parseVzlistList([]byte(`[
+		{"ctid": 101, "name": "web", "hostname": "web.example.com", "status": "running", "ostemplate": "centos-7",
+		 "ip": ["10.0.0.2", "10.0.0.3"], "nameserver": ["8.8.8.8"], "cpus": 2,
+		 "physpages": {"limit": 131072}, "diskspace": {"hardlimit": 10485760}},
+		{"ctid": 102, "name": "", "hostname": "", "status": "stopped", "ostemplate": "debian-10",
+		 "ip": [], "nameserver": [], "cpus": null,
+		 "physpages": {"limit": 9223372036854775807}, "diskspace": {"hardlimit": 9223372036854775807}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []HostContainer{
+		{ID: "101", Name: "web", State: HostRunning, OSTemplate: "centos-7", Parameters: map[string]string{
+			"hostname": "web.example.com", "description": "", "ipadd": "10.0.0.2,10.0.0.3", "nameserver": "8.8.8.8",
+			"cpus": "2", "memsize": "524288", "memsize_units": "K", "size": "10485760", "size_units": "K",
+		}},
+		{ID: "102", Name: "102", State: HostStopped, OSTemplate: "debian-10", Parameters: map[string]string{
+			"hostname": "", "description": "", "ipadd": "", "nameserver": "",
+		}},
+	}
+	if !reflect.DeepEqual(containers, want) {
+		t.Errorf("parseVzlistList() = %+v, want %+v", containers, want)
+	}
+}
+
+func TestVZCtlCommanderBackups(t *testing.T) {
+	ctx := context.Background()
+	cmd := newStandinCommander(t)
+	dir := t.TempDir()
+
+	if err := cmd.CreateContainer(ctx, "web", "centos-7", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.SetContainerParameters(ctx, "web", openvzcmd.Options{"hostname": "web.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := cmd.BackupContainer(ctx, "web", "b1", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.ID != "b1" || !strings.HasPrefix(backup.Archive, dir+"/vzdump-openvz-101-") {
+		t.Fatalf("Backup = %+v, want an archive in %s", backup, dir)
+	}
+
+	if err := cmd.RestoreBackup(ctx, "restored", backup); err != nil {
+		t.Fatal(err)
+	}
+	ct := findContainer(t, cmd, "restored")
+	if ct == nil || ct.ID != "102" || ct.State != HostStopped || ct.Parameters["hostname"] != "web.example.com" {
+		t.Errorf("Restored container = %+v", ct)
+	}
+
+	if err := cmd.DeleteBackup(ctx, backup); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(backup.Archive); !os.IsNotExist(err) {
+		t.Errorf("Archive of a deleted backup: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// SubscriptionBuffer is a number of events buffered for a subscriber.
+// Events are dropped for subscribers which fall behind.
+const SubscriptionBuffer = 64
+
+type (
+	// Event is published by subsystems to the bus
+	Event struct {
+		Type string `json:"type"`
+		// Subject is ID of a job or an entity the event is about
+		Subject string      `json:"subject"`
+		Time    time.Time   `json:"time"`
+		Data    interface{} `json:"data,omitempty"`
+	}
+
+	// Filter selects events delivered to a subscription
+	Filter func(e *Event) bool
+
+	Subscription struct {
+		C      <-chan *Event
+		c      chan *Event
+		filter Filter
+	}
+
+	// Bus delivers events published within a process to its subscribers
+	Bus struct {
+		mu            sync.RWMutex
+		subscriptions map[*Subscription]struct{}
+	}
+)
+
+func NewBus() *Bus {
+	return &Bus{
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish delivers an event to subscribers without blocking
+func (b *Bus) Publish(eventType, subject string, data interface{}) {
+	e := &Event{
+		Type:    eventType,
+		Subject: subject,
+		Time:    time.Now().UTC(),
+		Data:    data,
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for s := range b.subscriptions {
+		if s.filter != nil && !s.filter(e) {
+			continue
+		}
+
+		select {
+		case s.c <- e:
+		default:
+			log.Printf("Dropped %s event of %s for a slow subscriber.", e.Type, e.Subject)
+		}
+	}
+}
+
+// Subscribe returns a subscription to events selected by filter, or all events if it is nil
+func (b *Bus) Subscribe(filter Filter) *Subscription {
+	return b.SubscribeBuffered(filter, SubscriptionBuffer)
+}
+
+// SubscribeBuffered is like Subscribe, with a buffer of given size for subscribers
+// which must not miss events
+func (b *Bus) SubscribeBuffered(filter Filter, size int) *Subscription {
+	c := make(chan *Event, size)
+	s := &Subscription{
+		C:      c,
+		c:      c,
+		filter: filter,
+	}
+
+	b.mu.Lock()
+	b.subscriptions[s] = struct{}{}
+	b.mu.Unlock()
+
+	return s
+}
+
+func (b *Bus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	delete(b.subscriptions, s)
+	b.mu.Unlock()
+}
+
+// ByType selects events of given types
+func ByType(types ...string) Filter {
+	return func(e *Event) bool {
+		for _, t := range types {
+			if e.Type == t {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// BySubject selects events about given subject
+func BySubject(subject string) Filter {
+	return func(e *Event) bool {
+		return e.Subject == subject
+	}
+}
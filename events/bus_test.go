@@ -0,0 +1,67 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import "testing"
+
+func TestBusDeliversSubscribedEvents(t *testing.T) {
+	bus := NewBus()
+	all := bus.Subscribe(nil)
+	job1 := bus.Subscribe(BySubject("job1"))
+
+	bus.Publish("job.status", "job1", "running")
+	bus.Publish("job.status", "job2", "running")
+
+	for _, want := range []string{"job1", "job2"} {
+		if e := <-all.C; e.Subject != want || e.Type != "job.status" || e.Data != "running" {
+			t.Errorf("Event = %+v, want one of %s", e, want)
+		}
+	}
+	if e := <-job1.C; e.Subject != "job1" {
+		t.Errorf("Event = %+v, want one of job1", e)
+	}
+	select {
+	case e := <-job1.C:
+		t.Errorf("Event %+v is not filtered out", e)
+	default:
+	}
+
+	bus.Unsubscribe(job1)
+	bus.Publish("job.status", "job1", "done")
+	select {
+	case e := <-job1.C:
+		t.Errorf("Event %+v is delivered after unsubscribing", e)
+	default:
+	}
+}
+
+func TestBusDropsEventsOfSlowSubscribers(t *testing.T) {
+	bus := NewBus()
+	sub := bus.Subscribe(nil)
+
+	// Publishing never blocks on a subscriber which does not read events
+	for i := 0; i < SubscriptionBuffer+10; i++ {
+		bus.Publish("job.progress", "job1", i)
+	}
+
+	if n := len(sub.C); n != SubscriptionBuffer {
+		t.Errorf("Buffered %d events, want %d", n, SubscriptionBuffer)
+	}
+	if e := <-sub.C; e.Data != 0 {
+		t.Errorf("First event = %+v, want the oldest one", e)
+	}
+}
@@ -1,11 +1,15 @@
 module github.com/romiras/go-openvz-api
 
-go 1.14
+go 1.16
 
 require (
 	github.com/gin-gonic/gin v1.9.0
 	github.com/google/uuid v1.3.0
 	github.com/jmoiron/sqlx v1.3.4
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.9
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/romiras/go-openvz-cmd v0.0.0-20200929102312-455940cf8ff9
+	golang.org/x/net v0.7.0
+	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/registries"
+	"github.com/romiras/go-openvz-api/services"
+)
+
+// CreateBackup - Backs up a container
+func CreateBackup(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	var req *api.AddBackupRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	req.ContainerID = c.Param("id")
+
+	err = api.ValidateAddBackupRequest(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	dryRun, err := handleDryRun(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.BackupAPIService.Create(req, dryRun)
+	if err != nil {
+		handleBackupError(c, err)
+		return
+	}
+
+	c.JSON(jobStatusCode(resp.DryRun), resp)
+}
+
+// ListContainerBackups - List backups of a container
+func ListContainerBackups(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.BackupAPIService.ListByContainer(c.Param("id"))
+	if err != nil {
+		handleBackupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ListBackups - List backups, optionally of a container given by container_id
+func ListBackups(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.BackupAPIService.List(c.Query("container_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetBackupById - Find backup by ID
+func GetBackupById(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.BackupAPIService.GetById(c.Param("bid"))
+	if err != nil {
+		handleBackupError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeleteBackup - Deletes a backup
+func DeleteBackup(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	dryRun, err := handleDryRun(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.BackupAPIService.Delete(c.Param("bid"), dryRun)
+	if err != nil {
+		handleBackupError(c, err)
+		return
+	}
+
+	c.JSON(jobStatusCode(resp.DryRun), resp)
+}
+
+// RestoreBackup - Restores a backup into an existing or a new container
+func RestoreBackup(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	var req *api.RestoreBackupRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	req.ID = c.Param("bid")
+
+	err = api.ValidateRestoreBackupRequest(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	dryRun, err := handleDryRun(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.BackupAPIService.Restore(req, dryRun)
+	if err != nil {
+		handleBackupError(c, err)
+		return
+	}
+
+	c.JSON(jobStatusCode(resp.DryRun), resp)
+}
+
+func handleBackupError(c *gin.Context, err error) {
+	switch err {
+	case sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, api.InvalidRequest(errors.New("no such container or backup")))
+	case services.ErrInvalidContainerState:
+		c.JSON(http.StatusConflict, api.InvalidRequest(errors.New("operation is not allowed in current container state")))
+	case services.ErrInvalidBackupState:
+		c.JSON(http.StatusConflict, api.InvalidRequest(errors.New("operation is not allowed in current backup state")))
+	case services.ErrDuplicateName:
+		c.JSON(http.StatusUnprocessableEntity, api.InvalidRequest(errors.New("A container with given name already exists")))
+	default:
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+	}
+}
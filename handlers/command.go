@@ -0,0 +1,36 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/registries"
+)
+
+// ListCommands - List commands run on a host by jobs
+func ListCommands(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.CommandAPIService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
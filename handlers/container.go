@@ -24,6 +24,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/romiras/go-openvz-api/api"
 	"github.com/romiras/go-openvz-api/registries"
+	"github.com/romiras/go-openvz-api/services"
 )
 
 // ListContainers - List containers
@@ -48,22 +49,46 @@ func CreateContainer(c *gin.Context, registry *registries.Registry) {
 	}
 
 	err = api.ValidateAddContainerRequest(req)
+	if err != nil {
+		if errs, ok := err.(api.ValidationErrors); ok {
+			c.JSON(http.StatusBadRequest, api.InvalidFields(errs))
+			return
+		}
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	idempotencyKey, err := handleIdempotencyKey(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
 		return
 	}
 
-	resp, err := registry.ContainerAPIService.Create(req)
+	dryRun, err := handleDryRun(c)
 	if err != nil {
-		if err.Error() == "duplicate-name" {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.ContainerAPIService.Create(req, idempotencyKey, dryRun)
+	if err != nil {
+		if err == services.ErrDuplicateName {
 			c.JSON(http.StatusUnprocessableEntity, api.InvalidRequest(errors.New("A container with given name already exists")))
 			return
 		}
+		if err == services.ErrIdempotencyKeyReused {
+			c.JSON(http.StatusUnprocessableEntity, api.InvalidRequest(errors.New("Idempotency-Key is already used by another request")))
+			return
+		}
+		if err == services.ErrUnknownTemplate {
+			c.JSON(http.StatusUnprocessableEntity, api.InvalidFields(api.ValidationErrors{"ostemplate": "is not installed on a host"}))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
 		return
 	}
 
-	c.JSON(http.StatusAccepted, resp)
+	c.JSON(jobStatusCode(resp.DryRun), resp)
 }
 
 // DeleteContainer - Deletes a container
@@ -71,15 +96,40 @@ func DeleteContainer(c *gin.Context, registry *registries.Registry) {
 	id, err := handleFindByID(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	idempotencyKey, err := handleIdempotencyKey(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	dryRun, err := handleDryRun(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
 	}
 
-	resp, err := registry.ContainerAPIService.Delete(id)
+	resp, err := registry.ContainerAPIService.Delete(id, idempotencyKey, dryRun)
 	if err != nil {
+		if err == services.ErrIdempotencyKeyReused {
+			c.JSON(http.StatusUnprocessableEntity, api.InvalidRequest(errors.New("Idempotency-Key is already used by another request")))
+			return
+		}
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, api.InvalidRequest(errors.New("no such container")))
+			return
+		}
+		if err == services.ErrInvalidContainerState {
+			c.JSON(http.StatusConflict, api.InvalidRequest(errors.New("container cannot be deleted in current state")))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, resp)
+	c.JSON(jobStatusCode(resp.DryRun), resp)
 }
 
 // GetContainerById - Find container by ID
@@ -102,8 +152,120 @@ func GetContainerById(c *gin.Context, registry *registries.Registry) {
 	c.JSON(http.StatusOK, container)
 }
 
-// UpdateContainer - Updates a container
+// ContainerAction - Runs a power lifecycle action on a container
+func ContainerAction(c *gin.Context, registry *registries.Registry) {
+	id := c.Param("id")
+	action := c.Param("action")
+
+	err := api.ValidateContainerActionRequest(id, action)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	idempotencyKey, err := handleIdempotencyKey(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	dryRun, err := handleDryRun(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.ContainerAPIService.Action(id, action, idempotencyKey, dryRun)
+	if err != nil {
+		if err == services.ErrIdempotencyKeyReused {
+			c.JSON(http.StatusUnprocessableEntity, api.InvalidRequest(errors.New("Idempotency-Key is already used by another request")))
+			return
+		}
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, api.InvalidRequest(errors.New("no such container")))
+			return
+		}
+		if err == services.ErrInvalidContainerState {
+			c.JSON(http.StatusConflict, api.InvalidRequest(errors.New("action is not allowed in current container state")))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+		return
+	}
+
+	c.JSON(jobStatusCode(resp.DryRun), resp)
+}
+
+// CloneContainer - Copies a stopped container to a new one
+func CloneContainer(c *gin.Context, registry *registries.Registry) {
+	var req *api.CloneContainerRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	req.ID = c.Param("id")
+
+	err = api.ValidateCloneContainerRequest(req)
+	if err != nil {
+		if errs, ok := err.(api.ValidationErrors); ok {
+			c.JSON(http.StatusBadRequest, api.InvalidFields(errs))
+			return
+		}
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	idempotencyKey, err := handleIdempotencyKey(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	dryRun, err := handleDryRun(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.ContainerAPIService.Clone(req, idempotencyKey, dryRun)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, api.InvalidRequest(errors.New("no such container")))
+			return
+		}
+		if err == services.ErrInvalidContainerState {
+			c.JSON(http.StatusConflict, api.InvalidRequest(errors.New("only a stopped container can be cloned")))
+			return
+		}
+		if err == services.ErrDuplicateName {
+			c.JSON(http.StatusUnprocessableEntity, api.InvalidRequest(errors.New("A container with given name already exists")))
+			return
+		}
+		if err == services.ErrIdempotencyKeyReused {
+			c.JSON(http.StatusUnprocessableEntity, api.InvalidRequest(errors.New("Idempotency-Key is already used by another request")))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+		return
+	}
+
+	c.JSON(jobStatusCode(resp.DryRun), resp)
+}
+
+// UpdateContainer - Merges given parameters into parameters of a container
 func UpdateContainer(c *gin.Context, registry *registries.Registry) {
+	updateContainer(c, registry, false)
+}
+
+// ReplaceContainer - Replaces all parameters of a container
+func ReplaceContainer(c *gin.Context, registry *registries.Registry) {
+	updateContainer(c, registry, true)
+}
+
+func updateContainer(c *gin.Context, registry *registries.Registry, replace bool) {
 	var req *api.UpdateContainerRequest
 
 	err := c.ShouldBindJSON(&req)
@@ -112,18 +274,50 @@ func UpdateContainer(c *gin.Context, registry *registries.Registry) {
 		return
 	}
 
-	req.ID, err = handleFindByID(c)
+	req.ID = c.Param("id")
+	req.Replace = replace
+
+	err = api.ValidateUpdateContainerRequest(req)
 	if err != nil {
+		if errs, ok := err.(api.ValidationErrors); ok {
+			c.JSON(http.StatusBadRequest, api.InvalidFields(errs))
+			return
+		}
 		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
 	}
 
-	resp, err := registry.ContainerAPIService.Update(req)
+	idempotencyKey, err := handleIdempotencyKey(c)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	dryRun, err := handleDryRun(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.ContainerAPIService.Update(req, idempotencyKey, dryRun)
+	if err != nil {
+		if err == services.ErrIdempotencyKeyReused {
+			c.JSON(http.StatusUnprocessableEntity, api.InvalidRequest(errors.New("Idempotency-Key is already used by another request")))
+			return
+		}
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, api.InvalidRequest(errors.New("no such container")))
+			return
+		}
+		if err == services.ErrInvalidContainerState {
+			c.JSON(http.StatusConflict, api.InvalidRequest(errors.New("container cannot be updated in current state")))
+			return
+		}
 		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
 		return
 	}
 
-	c.JSON(http.StatusOK, resp)
+	c.JSON(jobStatusCode(resp.DryRun), resp)
 }
 
 func handleFindByID(c *gin.Context) (string, error) {
@@ -136,3 +330,32 @@ func handleFindByID(c *gin.Context) (string, error) {
 
 	return id, nil
 }
+
+func handleIdempotencyKey(c *gin.Context) (string, error) {
+	key := c.GetHeader(api.IdempotencyKeyHeader)
+
+	return key, api.ValidateIdempotencyKey(key)
+}
+
+// handleNoIdempotencyKey rejects an Idempotency-Key header sent to an endpoint not replaying
+// requests, so that a client does not rely on it
+func handleNoIdempotencyKey(c *gin.Context) error {
+	if c.GetHeader(api.IdempotencyKeyHeader) != "" {
+		return errors.New(api.IdempotencyKeyHeader + " is not supported by this endpoint")
+	}
+
+	return nil
+}
+
+func handleDryRun(c *gin.Context) (bool, error) {
+	return api.ParseDryRun(c.Query(api.DryRunParam))
+}
+
+// jobStatusCode returns a status of a response to a request run as a job. A dry run is already done.
+func jobStatusCode(dryRun bool) int {
+	if dryRun {
+		return http.StatusOK
+	}
+
+	return http.StatusAccepted
+}
@@ -0,0 +1,37 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/registries"
+)
+
+// GetDrift - Get differences between containers in the database and on a host.
+// With refresh=true containers are reconciled at once.
+func GetDrift(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.ReconcileService.Drift(c.Query("refresh") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
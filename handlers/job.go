@@ -19,11 +19,15 @@ package handlers
 import (
 	"database/sql"
 	"errors"
+	"io"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/romiras/go-openvz-api/api"
 	"github.com/romiras/go-openvz-api/registries"
+	"github.com/romiras/go-openvz-api/services"
 )
 
 // GetJobById - Find container by ID
@@ -46,7 +50,110 @@ func GetJobById(c *gin.Context, registry *registries.Registry) {
 	c.JSON(http.StatusOK, resp)
 }
 
-// unused
+// ListJobs - List jobs
+func ListJobs(c *gin.Context, registry *registries.Registry) {
+	var req api.ListJobsRequest
+
+	err := c.ShouldBindQuery(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	err = api.ValidateListJobsRequest(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.JobAPIService.List(&req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetJobLogs - Get logs of commands run by a job. With follow=true logs are
+// streamed as plain text until the job is finished.
+func GetJobLogs(c *gin.Context, registry *registries.Registry) {
+	id, err := handleFindJobByID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	if c.Query("follow") != "true" {
+		resp, err := registry.JobAPIService.GetLogs(id)
+		if err != nil {
+			handleJobError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	follower, err := registry.JobAPIService.FollowLogs(id)
+	if err != nil {
+		handleJobError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Stream(func(w io.Writer) bool {
+		more, err := follower.Next(w)
+		if err != nil {
+			log.Println(err.Error())
+			return false
+		}
+		if more {
+			time.Sleep(services.LogPollInterval)
+		}
+
+		return more
+	})
+}
+
+func handleJobError(c *gin.Context, err error) {
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, api.InvalidRequest(errors.New("no such job")))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+}
+
+// CancelJob - Cancels a pending or running job
+func CancelJob(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	id, err := handleFindJobByID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.JobAPIService.Cancel(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, api.InvalidRequest(errors.New("no such job")))
+			return
+		}
+		if err == services.ErrJobNotCancellable {
+			c.JSON(http.StatusConflict, api.InvalidRequest(errors.New("job is already finished")))
+			return
+		}
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
 func handleFindJobByID(c *gin.Context) (string, error) {
 	id := c.Param("id")
 
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/registries"
+	"github.com/romiras/go-openvz-api/services"
+	"golang.org/x/net/websocket"
+)
+
+// JobEvents - Streams status, progress and log events of a job as Server-Sent Events
+func JobEvents(c *gin.Context, registry *registries.Registry) {
+	id, err := handleFindJobByID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	sub, current, err := registry.JobAPIService.Watch(id)
+	if err != nil {
+		handleJobError(c, err)
+		return
+	}
+	defer registry.Events.Unsubscribe(sub)
+
+	c.SSEvent(current.Type, current)
+	if services.IsFinalJobEvent(current) {
+		return
+	}
+	// The current status is sent at once, not with the next event
+	c.Writer.Flush()
+
+	poll := time.NewTicker(services.StatusPollInterval)
+	defer poll.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e := <-sub.C:
+			c.SSEvent(e.Type, e)
+			return !services.IsFinalJobEvent(e)
+		case <-poll.C:
+			// A job run by another process finishes without events
+			e, err := pollJobStatus(registry, id)
+			if err != nil || e == nil {
+				return err == nil
+			}
+			c.SSEvent(e.Type, e)
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// JobEventsWebSocket - Streams status, progress and log events of a job over WebSocket
+func JobEventsWebSocket(c *gin.Context, registry *registries.Registry) {
+	id, err := handleFindJobByID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	sub, current, err := registry.JobAPIService.Watch(id)
+	if err != nil {
+		handleJobError(c, err)
+		return
+	}
+	defer registry.Events.Unsubscribe(sub)
+
+	server := websocket.Server{
+		// Accept connections from any origin, like the rest of API
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			// Closing of a connection by a client is noticed on reading
+			closed := make(chan struct{})
+			go func() {
+				io.Copy(ioutil.Discard, ws)
+				close(closed)
+			}()
+
+			poll := time.NewTicker(services.StatusPollInterval)
+			defer poll.Stop()
+
+			e := current
+			for {
+				if err := websocket.JSON.Send(ws, e); err != nil || services.IsFinalJobEvent(e) {
+					return
+				}
+
+				select {
+				case e = <-sub.C:
+				case <-poll.C:
+					// A job run by another process finishes without events
+					polled, err := pollJobStatus(registry, id)
+					if err != nil {
+						return
+					}
+					if polled == nil {
+						continue
+					}
+					e = polled
+				case <-closed:
+					return
+				}
+			}
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+// pollJobStatus returns the status of a job read from the database when the job is finished,
+// or nil while it is pending
+func pollJobStatus(registry *registries.Registry, id string) (*events.Event, error) {
+	e, err := registry.JobAPIService.Status(id)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, err
+	}
+	if !services.IsFinalJobEvent(e) {
+		return nil, nil
+	}
+
+	return e, nil
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/registries"
+	"github.com/romiras/go-openvz-api/services"
+	"golang.org/x/net/websocket"
+)
+
+// newTestJobServer serves job events of a registry with a fake host, and returns a pending job
+func newTestJobServer(t *testing.T) (*httptest.Server, *registries.Registry, string) {
+	t.Helper()
+
+	driver, dsn, backend, commandsPath := "sqlite3", ":memory:", "fake", ""
+	registry := registries.NewRegistry(&driver, &dsn, &backend, &commandsPath, services.NewDefaultRetryPolicy())
+	t.Cleanup(func() { registry.DB.Close() })
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/jobs/:id/events", func(c *gin.Context) { JobEvents(c, registry) })
+	router.GET("/jobs/:id/ws", func(c *gin.Context) { JobEventsWebSocket(c, registry) })
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	resp, err := registry.ContainerAPIService.Create(&api.AddContainerRequest{Name: "web", OSTemplate: "centos-7"}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server, registry, resp.JobID
+}
+
+func TestJobEventsOverSSE(t *testing.T) {
+	server, registry, jobID := newTestJobServer(t)
+
+	resp, err := http.Get(server.URL + "/jobs/" + jobID + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Content-Type = %s", ct)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	readStatus := func() string {
+		t.Helper()
+
+		var e struct {
+			Type string             `json:"type"`
+			Data api.JobStatusEvent `json:"data"`
+		}
+		for {
+			line, err := body.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasPrefix(line, "data:") {
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data:")), &e); err != nil {
+					t.Fatal(err)
+				}
+				return e.Type + " " + e.Data.Status
+			}
+		}
+	}
+
+	if got := readStatus(); got != services.JobStatusEventType+" pending" {
+		t.Errorf("First event = %s, want the current status", got)
+	}
+	if _, err := registry.JobAPIService.Cancel(jobID); err != nil {
+		t.Fatal(err)
+	}
+	if got := readStatus(); got != services.JobStatusEventType+" cancelled" {
+		t.Errorf("Next event = %s, want cancelled", got)
+	}
+	// The stream ends after the final status
+	rest, err := ioutil.ReadAll(body)
+	if err != nil || strings.Contains(string(rest), "data:") {
+		t.Errorf("Stream continues with %q, %v", rest, err)
+	}
+}
+
+func TestJobEventsOverWebSocket(t *testing.T) {
+	server, registry, jobID := newTestJobServer(t)
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/jobs/"+jobID+"/ws", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	var e struct {
+		Type string             `json:"type"`
+		Data api.JobStatusEvent `json:"data"`
+	}
+	if err := websocket.JSON.Receive(ws, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != services.JobStatusEventType || e.Data.Status != "pending" {
+		t.Errorf("First event = %+v, want the current status", e)
+	}
+
+	if _, err := registry.JobAPIService.Cancel(jobID); err != nil {
+		t.Fatal(err)
+	}
+	if err := websocket.JSON.Receive(ws, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != services.JobStatusEventType || e.Data.Status != "cancelled" {
+		t.Errorf("Next event = %+v, want cancelled", e)
+	}
+	// The connection is closed after the final status
+	if err := websocket.JSON.Receive(ws, &e); err == nil {
+		t.Errorf("Event after the final one = %+v", e)
+	}
+}
+
+func TestJobEventsOfJobsFinishedByOtherProcesses(t *testing.T) {
+	server, registry, jobID := newTestJobServer(t)
+
+	resp, err := http.Get(server.URL + "/jobs/" + jobID + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := bufio.NewReader(resp.Body)
+	if line, err := body.ReadString('\n'); err != nil || !strings.HasPrefix(line, "event:") {
+		t.Fatalf("First line = %q, %v", line, err)
+	}
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/jobs/"+jobID+"/ws", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	var e struct {
+		Type string             `json:"type"`
+		Data api.JobStatusEvent `json:"data"`
+	}
+	if err := websocket.JSON.Receive(ws, &e); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process publishes no events to this one, so the status is read from the database
+	if _, err := registry.JobAPIService.JobRepo.CancelPending(jobID); err != nil {
+		t.Fatal(err)
+	}
+
+	rest, err := ioutil.ReadAll(body)
+	if err != nil || !strings.Contains(string(rest), `"status":"cancelled"`) {
+		t.Errorf("SSE stream = %q, %v, want cancelled", rest, err)
+	}
+	if err := websocket.JSON.Receive(ws, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != services.JobStatusEventType || e.Data.Status != "cancelled" {
+		t.Errorf("Polled event = %+v, want cancelled", e)
+	}
+}
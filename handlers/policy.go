@@ -0,0 +1,170 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/registries"
+	"github.com/romiras/go-openvz-api/services"
+)
+
+// CreatePolicy - Adds a policy making backups or snapshots on a schedule
+func CreatePolicy(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	var req *api.AddPolicyRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	err = api.ValidateAddPolicyRequest(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.PolicyAPIService.Create(req)
+	if err != nil {
+		handlePolicyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// ListPolicies - List policies
+func ListPolicies(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.PolicyAPIService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetPolicyById - Find policy by ID
+func GetPolicyById(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.PolicyAPIService.GetById(c.Param("pid"))
+	if err != nil {
+		handlePolicyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeletePolicy - Deletes a policy
+func DeletePolicy(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.PolicyAPIService.Delete(c.Param("pid"))
+	if err != nil {
+		handlePolicyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// AttachPolicy - Attaches a policy to a container
+func AttachPolicy(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	var req *api.AttachPolicyRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	req.ID = c.Param("pid")
+
+	err = api.ValidateAttachPolicyRequest(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.PolicyAPIService.Attach(req)
+	if err != nil {
+		handlePolicyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DetachPolicy - Detaches a policy from a container
+func DetachPolicy(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.PolicyAPIService.Detach(c.Param("pid"), c.Param("cid"))
+	if err != nil {
+		handlePolicyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// PreviewPolicy - Shows upcoming runs of a policy and backups or snapshots it keeps and prunes
+func PreviewPolicy(c *gin.Context, registry *registries.Registry) {
+	runs, err := api.ParsePreviewRuns(c.Query("runs"), services.DefaultPreviewRuns)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.PolicyAPIService.Preview(c.Param("pid"), runs)
+	if err != nil {
+		handlePolicyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func handlePolicyError(c *gin.Context, err error) {
+	switch err {
+	case sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, api.InvalidRequest(errors.New("no such policy or container")))
+	default:
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/registries"
+	"github.com/romiras/go-openvz-api/services"
+)
+
+// CreateSnapshot - Takes a snapshot of a container
+func CreateSnapshot(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	var req *api.AddSnapshotRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	req.ContainerID = c.Param("id")
+
+	err = api.ValidateAddSnapshotRequest(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	dryRun, err := handleDryRun(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.SnapshotAPIService.Create(req, dryRun)
+	if err != nil {
+		handleSnapshotError(c, err)
+		return
+	}
+
+	c.JSON(jobStatusCode(resp.DryRun), resp)
+}
+
+// ListSnapshots - List snapshots of a container
+func ListSnapshots(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.SnapshotAPIService.List(c.Param("id"))
+	if err != nil {
+		handleSnapshotError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetSnapshotById - Find snapshot of a container by ID
+func GetSnapshotById(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.SnapshotAPIService.GetById(c.Param("id"), c.Param("sid"))
+	if err != nil {
+		handleSnapshotError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeleteSnapshot - Deletes a snapshot of a container
+func DeleteSnapshot(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	dryRun, err := handleDryRun(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.SnapshotAPIService.Delete(c.Param("id"), c.Param("sid"), dryRun)
+	if err != nil {
+		handleSnapshotError(c, err)
+		return
+	}
+
+	c.JSON(jobStatusCode(resp.DryRun), resp)
+}
+
+// RevertSnapshot - Switches a container to a snapshot
+func RevertSnapshot(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	dryRun, err := handleDryRun(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.SnapshotAPIService.Revert(c.Param("id"), c.Param("sid"), dryRun)
+	if err != nil {
+		handleSnapshotError(c, err)
+		return
+	}
+
+	c.JSON(jobStatusCode(resp.DryRun), resp)
+}
+
+func handleSnapshotError(c *gin.Context, err error) {
+	switch err {
+	case sql.ErrNoRows:
+		c.JSON(http.StatusNotFound, api.InvalidRequest(errors.New("no such container or snapshot")))
+	case services.ErrInvalidContainerState:
+		c.JSON(http.StatusConflict, api.InvalidRequest(errors.New("operation is not allowed in current container state")))
+	case services.ErrInvalidSnapshotState:
+		c.JSON(http.StatusConflict, api.InvalidRequest(errors.New("operation is not allowed in current snapshot state")))
+	default:
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+	}
+}
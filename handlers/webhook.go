@@ -0,0 +1,114 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/registries"
+)
+
+// CreateWebhook - Registers a webhook
+func CreateWebhook(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	var req *api.AddWebhookRequest
+
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	err = api.ValidateAddWebhookRequest(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.WebhookAPIService.Create(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// ListWebhooks - List webhooks
+func ListWebhooks(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.WebhookAPIService.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetWebhookById - Find webhook by ID
+func GetWebhookById(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.WebhookAPIService.GetById(c.Param("id"))
+	if err != nil {
+		handleWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeleteWebhook - Deletes a webhook
+func DeleteWebhook(c *gin.Context, registry *registries.Registry) {
+	if err := handleNoIdempotencyKey(c); err != nil {
+		c.JSON(http.StatusBadRequest, api.InvalidRequest(err))
+		return
+	}
+
+	resp, err := registry.WebhookAPIService.Delete(c.Param("id"))
+	if err != nil {
+		handleWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ListWebhookDeliveries - List delivery history of a webhook
+func ListWebhookDeliveries(c *gin.Context, registry *registries.Registry) {
+	resp, err := registry.WebhookAPIService.ListDeliveries(c.Param("id"))
+	if err != nil {
+		handleWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func handleWebhookError(c *gin.Context, err error) {
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, api.InvalidRequest(errors.New("no such webhook")))
+		return
+	}
+	c.JSON(http.StatusInternalServerError, api.FailedRequest(err))
+}
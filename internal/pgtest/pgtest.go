@@ -0,0 +1,100 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package pgtest starts throwaway PostgreSQL servers for tests of packages keeping data in PostgreSQL
+package pgtest
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+const (
+	// Image is a Docker image servers are run from
+	Image = "postgres:13-alpine"
+	// StartTimeout is how long a server is waited for to accept connections
+	StartTimeout = time.Minute
+)
+
+// Start runs a PostgreSQL server in a Docker container listening on a random port of localhost,
+// and waits until it accepts connections. It returns a connection string of an empty database
+// and a function removing the container.
+func Start() (string, func(), error) {
+	out, err := docker("run", "--detach", "--rm", "--publish", "127.0.0.1::5432",
+		"--env", "POSTGRES_PASSWORD=test", "--env", "POSTGRES_DB=openvz_test", Image)
+	if err != nil {
+		return "", nil, err
+	}
+	id := strings.TrimSpace(out)
+	stop := func() {
+		docker("rm", "--force", id)
+	}
+
+	out, err = docker("port", id, "5432/tcp")
+	if err != nil {
+		stop()
+		return "", nil, err
+	}
+	// A port may be published on several addresses, one per line
+	addr := strings.TrimSpace(strings.SplitN(out, "\n", 2)[0])
+	dsn := fmt.Sprintf("postgres://postgres:test@%s/openvz_test?sslmode=disable", addr)
+
+	if err := waitForConnections(dsn); err != nil {
+		stop()
+		return "", nil, err
+	}
+
+	return dsn, stop, nil
+}
+
+// waitForConnections pings a server until it answers or StartTimeout passes
+func waitForConnections(dsn string) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	deadline := time.Now().Add(StartTimeout)
+	for {
+		err := db.Ping()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("PostgreSQL is not started in %s: %w", StartTimeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+func docker(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("docker %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return stdout.String(), nil
+}
@@ -18,25 +18,145 @@ package main
 
 import (
 	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
+	"github.com/romiras/go-openvz-api/commanders"
 	"github.com/romiras/go-openvz-api/registries"
+	"github.com/romiras/go-openvz-api/repositories"
 	"github.com/romiras/go-openvz-api/routes"
+	"github.com/romiras/go-openvz-api/services"
 )
 
-const DefaultJobInterval = 3 // in seconds
+const (
+	DefaultJobInterval = 3 // in seconds
+	DefaultWorkers     = 1
+)
 
 func main() {
+	driver := flag.String("driver", repositories.SQLiteDriver, "Database driver: sqlite3 or postgres.")
 	dsn := flag.String("dsn", ":memory:", "Data source name.")
 	jobInterval := flag.Int64("jobinterval", DefaultJobInterval, "Job check interval")
+	workers := flag.Int("workers", DefaultWorkers, "Number of job workers")
+	host := flag.String("host", "", "Name of the host jobs are run on, which servers sharing a database must agree on")
+	hostConcurrency := flag.Int("hostconcurrency", 0, "Max number of jobs running on the host at once, 0 for no limit")
+	jobLease := flag.Int64("joblease", int64(services.DefaultLeaseDuration/time.Second), "Job lease duration in seconds, after which a job without heartbeats is reaped")
+	maxAttempts := flag.Int("maxattempts", services.DefaultMaxAttempts, "Max number of attempts of a job")
+	retryBackoff := flag.Int64("retrybackoff", int64(services.DefaultRetryBackoff/time.Second), "Delay in seconds before the first retry of a failed job")
+	backend := flag.String("commander", commanders.VZBackend, "Commander backend: vz (prlctl), vzctl (OpenVZ 6) or fake.")
+	reconcileInterval := flag.Int64("reconcileinterval", int64(services.DefaultReconcileInterval/time.Second), "Interval in seconds of reconciliation of containers with a host and discovery of its OS templates, 0 to reconcile never and discover templates once")
+	importUnmanaged := flag.Bool("importunmanaged", false, "Import containers found on a host only on reconciliation")
+	markVanished := flag.Bool("markvanished", false, "Mark containers not found on a host as missing on reconciliation")
+	dryRun := flag.Bool("dry-run", false, "Only render commands of requested jobs, without running them on a host.")
+	schedulerInterval := flag.Int64("schedulerinterval", int64(services.DefaultSchedulerInterval/time.Second), "Interval in seconds of running due policies and pruning backups and snapshots, 0 to disable")
+	backupDir := flag.String("backupdir", services.DefaultBackupDir, "Local directory backups of containers are made into.")
+	commandsPath := flag.String("commands", "", "Path to a commands config, reloaded on SIGHUP. Defaults to vz_commands.yml or vzctl_commands.yml by backend.")
 	flag.Parse()
 
-	registry := registries.NewRegistry(dsn)
+	if flag.Arg(0) == "migrate" {
+		migrate(*driver, *dsn, flag.Args()[1:])
+		return
+	}
+
+	// prlctl makes backups into its own backup path, which is set by prlsrvctl
+	if *backend == commanders.VZBackend && isFlagSet("backupdir") {
+		log.Fatal("-backupdir is not supported by the vz commander, set a backup path of prlctl with prlsrvctl instead")
+	}
+
+	retries := services.NewDefaultRetryPolicy()
+	retries.MaxAttempts = *maxAttempts
+	retries.Backoff = time.Duration(*retryBackoff) * time.Second
+
+	registry := registries.NewRegistry(driver, dsn, backend, commandsPath, retries)
 	defer registry.DB.Close()
 
 	// Run a job service in background.
-	go registry.JobService.ConsumeJobs(time.Duration(*jobInterval) * time.Second)
+	registry.JobService.Host = *host
+	registry.JobService.HostConcurrency = *hostConcurrency
+	registry.JobService.LeaseDuration = time.Duration(*jobLease) * time.Second
+	registry.JobService.DryRun = *dryRun
+	if *backend == commanders.VZBackend {
+		registry.JobService.BackupDir = ""
+	} else {
+		registry.JobService.BackupDir = backupPath(*backupDir)
+	}
+	if *dryRun {
+		// Jobs enqueued before are left pending, so that nothing runs on a host.
+		log.Printf("Dry run: commands are rendered but not run.")
+	} else {
+		go registry.JobService.ConsumeJobs(*workers, time.Duration(*jobInterval)*time.Second)
+	}
+
+	// Deliver webhooks in background.
+	go registry.WebhookService.Run(time.Duration(*jobInterval) * time.Second)
+
+	// Reconcile containers with a host in background.
+	registry.ReconcileService.ImportUnmanaged = *importUnmanaged
+	registry.ReconcileService.MarkVanished = *markVanished
+	if *reconcileInterval > 0 {
+		go registry.ReconcileService.Run(time.Duration(*reconcileInterval) * time.Second)
+	}
+
+	// Run policies in background. Under a dry run they would make nothing.
+	if *schedulerInterval > 0 && !*dryRun {
+		go registry.SchedulerService.Run(time.Duration(*schedulerInterval) * time.Second)
+	}
+
+	// Discover OS templates on a host in background, along with reconciliation.
+	go registry.TemplateAPIService.Run(time.Duration(*reconcileInterval) * time.Second)
+
+	go reloadOnHangup(registry.Commander)
 
 	// Our server will live in the routes package
 	routes.Run(registry)
 }
+
+// isFlagSet reports whether a flag is given on a command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+// backupPath returns an absolute path of a backup directory, which is created if missing,
+// so that commands get the same path regardless of their working directory
+func backupPath(dir string) string {
+	path, err := filepath.Abs(dir)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if err := os.MkdirAll(path, 0750); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	return path
+}
+
+// reloadOnHangup reloads configuration of a commander on SIGHUP. An invalid configuration
+// is logged and the current one is kept.
+func reloadOnHangup(cmd commanders.Commander) {
+	reloader, ok := cmd.(commanders.Reloader)
+	if !ok {
+		return
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	for range hangup {
+		if err := reloader.Reload(); err != nil {
+			log.Printf("Commands are not reloaded: %s", err.Error())
+			continue
+		}
+		log.Printf("Commands are reloaded.")
+	}
+}
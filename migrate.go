@@ -0,0 +1,88 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/romiras/go-openvz-api/migrations"
+	"github.com/romiras/go-openvz-api/registries"
+)
+
+const migrateUsage = "usage: go-openvz-api [flags] migrate up [version] | down [steps] | status"
+
+// migrate runs a migrate subcommand: up to a version (all by default), down a number of steps (1 by default), or status
+func migrate(driver, dsn string, args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		log.Fatal(migrateUsage)
+	}
+
+	n := 0
+	if len(args) == 2 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			log.Fatal(migrateUsage)
+		}
+	}
+
+	db := registries.OpenDB(driver, dsn)
+	defer db.Close()
+
+	migrator, err := migrations.NewMigrator(db, driver)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(n)
+		for _, m := range applied {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	case "down":
+		if len(args) == 1 {
+			n = 1
+		}
+		reverted, err := migrator.Down(n)
+		for _, m := range reverted {
+			fmt.Printf("Reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = "applied at " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
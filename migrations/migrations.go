@@ -0,0 +1,246 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const SQL_CREATE_SCHEMA_MIGRATIONS = "CREATE TABLE IF NOT EXISTS schema_migrations (version integer NOT NULL, name VARCHAR(255) NOT NULL, applied_at timestamp NOT NULL, CONSTRAINT schema_migrations_pkey PRIMARY KEY (version))"
+
+// Migrations of every driver are kept in a directory named after it,
+// in files named <version>_<name>.up.sql and <version>_<name>.down.sql.
+//
+//go:embed */*.sql
+var files embed.FS
+
+var fileNameRegexp = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrator applies migrations of a driver to a database and tracks them in schema_migrations
+type Migrator struct {
+	DB         *sqlx.DB
+	Migrations []Migration
+}
+
+func NewMigrator(db *sqlx.DB, driver string) (*Migrator, error) {
+	migrations, err := Load(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		DB:         db,
+		Migrations: migrations,
+	}, nil
+}
+
+// Load returns migrations of a driver sorted by version
+func Load(driver string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, driver)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for driver %s", driver)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileNameRegexp.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("bad migration file name %s", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", m.Name, match[2])
+		}
+
+		data, err := fs.ReadFile(files, path.Join(driver, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Version returns a version of the latest applied migration, or 0 if none is applied
+func (m *Migrator) Version() (int, error) {
+	err := m.init()
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	err = m.DB.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations")
+
+	return version, err
+}
+
+// Up applies pending migrations up to a target version, or all of them if target is 0
+func (m *Migrator) Up(target int) ([]Migration, error) {
+	version, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]Migration, 0)
+	for _, migration := range m.Migrations {
+		if migration.Version <= version {
+			continue
+		}
+		if target > 0 && migration.Version > target {
+			break
+		}
+
+		err = m.apply(migration.Up, func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(tx.Rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"), migration.Version, migration.Name, time.Now().UTC())
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// Down reverts a number of the latest applied migrations
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	version, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	reverted := make([]Migration, 0, steps)
+	for i := len(m.Migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		migration := m.Migrations[i]
+		if migration.Version > version {
+			continue
+		}
+
+		err = m.apply(migration.Down, func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(tx.Rebind("DELETE FROM schema_migrations WHERE version=?"), migration.Version)
+			return err
+		})
+		if err != nil {
+			return reverted, fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+		reverted = append(reverted, migration)
+	}
+
+	return reverted, nil
+}
+
+// Status returns all known migrations along with times they were applied at
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	err := m.init()
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	err = m.DB.Select(&rows, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	appliedAt := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		appliedAt[row.Version] = row.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.Migrations))
+	for _, migration := range m.Migrations {
+		status := MigrationStatus{Migration: migration}
+		if t, ok := appliedAt[migration.Version]; ok {
+			status.AppliedAt = &t
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+func (m *Migrator) init() error {
+	_, err := m.DB.Exec(SQL_CREATE_SCHEMA_MIGRATIONS)
+	return err
+}
+
+// apply runs statements of a migration and records it in a single transaction
+func (m *Migrator) apply(statements string, record func(tx *sqlx.Tx) error) error {
+	tx, err := m.DB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(statements)
+	if err == nil {
+		err = record(tx)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrations
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testPostgresDSNEnv names a variable with a connection string of a scratch PostgreSQL database.
+// When it is set, migrations are tested against the database too, and its public schema is dropped.
+// Tests built with the postgres tag start a throwaway database when it is not set.
+const testPostgresDSNEnv = "OPENVZ_API_TEST_POSTGRES_DSN"
+
+// testPostgresDSN is a connection string of a PostgreSQL database migrations are tested against
+var testPostgresDSN = os.Getenv(testPostgresDSNEnv)
+
+// forEachDriver runs a test with a migrator of an empty database of every driver available
+func forEachDriver(t *testing.T, test func(t *testing.T, m *Migrator)) {
+	t.Run("sqlite3", func(t *testing.T) {
+		db := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+		db.SetMaxOpenConns(1)
+		test(t, newTestMigrator(t, db))
+	})
+
+	t.Run("postgres", func(t *testing.T) {
+		if testPostgresDSN == "" {
+			t.Skipf("%s is not set, run tests with -tags postgres to start a database", testPostgresDSNEnv)
+		}
+
+		db := sqlx.MustConnect("postgres", testPostgresDSN)
+		db.MustExec("DROP SCHEMA public CASCADE")
+		db.MustExec("CREATE SCHEMA public")
+		test(t, newTestMigrator(t, db))
+	})
+}
+
+func newTestMigrator(t *testing.T, db *sqlx.DB) *Migrator {
+	t.Helper()
+	t.Cleanup(func() { db.Close() })
+
+	m, err := NewMigrator(db, db.DriverName())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func assertVersion(t *testing.T, m *Migrator, expected int) {
+	t.Helper()
+
+	version, err := m.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != expected {
+		t.Fatalf("expected version %d, got %d", expected, version)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	sqlite, err := Load("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqlite) == 0 {
+		t.Fatal("expected migrations")
+	}
+	for i, m := range sqlite {
+		if m.Version != i+1 {
+			t.Errorf("expected version %d, got %d_%s", i+1, m.Version, m.Name)
+		}
+	}
+
+	// Every driver has the same migrations
+	postgres, err := Load("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("expected %d postgres migrations, got %d", len(sqlite), len(postgres))
+	}
+	for i, m := range postgres {
+		if m.Version != sqlite[i].Version || m.Name != sqlite[i].Name {
+			t.Errorf("expected migration %d_%s, got %d_%s", sqlite[i].Version, sqlite[i].Name, m.Version, m.Name)
+		}
+	}
+
+	if _, err = Load("oracle"); err == nil {
+		t.Error("expected an error for an unknown driver")
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, m *Migrator) {
+		latest := m.Migrations[len(m.Migrations)-1].Version
+		assertVersion(t, m, 0)
+
+		applied, err := m.Up(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != 1 {
+			t.Fatalf("expected 1 applied migration, got %d", len(applied))
+		}
+		assertVersion(t, m, 1)
+
+		applied, err = m.Up(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != len(m.Migrations)-1 {
+			t.Fatalf("expected %d applied migrations, got %d", len(m.Migrations)-1, len(applied))
+		}
+		assertVersion(t, m, latest)
+
+		applied, err = m.Up(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != 0 {
+			t.Fatalf("expected no pending migrations, got %d", len(applied))
+		}
+
+		statuses, err := m.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range statuses {
+			if s.AppliedAt == nil {
+				t.Errorf("expected migration %d_%s to be applied", s.Version, s.Name)
+			}
+		}
+
+		reverted, err := m.Down(len(m.Migrations))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reverted) != len(m.Migrations) {
+			t.Fatalf("expected %d reverted migrations, got %d", len(m.Migrations), len(reverted))
+		}
+		assertVersion(t, m, 0)
+
+		// Every down migration must leave a schema which its up migration can be applied to again
+		if _, err = m.Up(0); err != nil {
+			t.Fatal(err)
+		}
+		assertVersion(t, m, latest)
+	})
+}
+
+func TestMigrateDownOneStep(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, m *Migrator) {
+		latest := m.Migrations[len(m.Migrations)-1].Version
+
+		if _, err := m.Up(0); err != nil {
+			t.Fatal(err)
+		}
+
+		reverted, err := m.Down(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reverted) != 1 || reverted[0].Version != latest {
+			t.Fatalf("expected migration %d to be reverted, got %v", latest, reverted)
+		}
+		assertVersion(t, m, m.Migrations[len(m.Migrations)-2].Version)
+
+		statuses, err := m.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statuses[len(statuses)-1].AppliedAt != nil {
+			t.Error("expected the latest migration to be pending")
+		}
+	})
+}
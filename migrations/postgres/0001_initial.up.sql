@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS containers (id VARCHAR(36) NOT NULL, name VARCHAR(255) NOT NULL, os_template VARCHAR(255) NOT NULL, parameters TEXT, created_at timestamptz NOT NULL DEFAULT current_timestamp, CONSTRAINT containers_pkey PRIMARY KEY (id));
+CREATE TABLE IF NOT EXISTS jobs (id VARCHAR(36) NOT NULL, type VARCHAR(255) NOT NULL, payload TEXT NOT NULL, status integer NOT NULL, entity_type VARCHAR(255), entity_id VARCHAR(36), created_at timestamptz NOT NULL DEFAULT current_timestamp, locked_at timestamptz, error_descr VARCHAR(255), CONSTRAINT jobs_pkey PRIMARY KEY (id));
+CREATE INDEX IF NOT EXISTS jobs_status_locked_at_created_at_index ON jobs (status, locked_at, created_at);
@@ -0,0 +1,9 @@
+ALTER TABLE jobs ADD COLUMN error_descr VARCHAR(255);
+UPDATE jobs SET error_descr=substr(last_error, 1, 255);
+ALTER TABLE jobs DROP COLUMN last_error;
+ALTER TABLE jobs DROP COLUMN cancel_requested;
+ALTER TABLE jobs DROP COLUMN next_run_at;
+ALTER TABLE jobs DROP COLUMN max_attempts;
+ALTER TABLE jobs DROP COLUMN attempts;
+ALTER TABLE jobs DROP COLUMN heartbeat_at;
+ALTER TABLE jobs DROP COLUMN locked_by;
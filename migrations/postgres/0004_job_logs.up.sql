@@ -0,0 +1,2 @@
+CREATE TABLE job_logs (id BIGSERIAL NOT NULL, job_id VARCHAR(36) NOT NULL, command TEXT NOT NULL, exit_code integer, stdout TEXT NOT NULL, stderr TEXT NOT NULL, started_at timestamptz NOT NULL, finished_at timestamptz, CONSTRAINT job_logs_pkey PRIMARY KEY (id));
+CREATE INDEX job_logs_job_id_index ON job_logs (job_id);
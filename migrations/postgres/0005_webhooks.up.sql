@@ -0,0 +1,3 @@
+CREATE TABLE webhooks (id VARCHAR(36) NOT NULL, url TEXT NOT NULL, secret VARCHAR(255) NOT NULL, events TEXT NOT NULL, created_at timestamptz NOT NULL, CONSTRAINT webhooks_pkey PRIMARY KEY (id));
+CREATE TABLE webhook_deliveries (id VARCHAR(36) NOT NULL, webhook_id VARCHAR(36) NOT NULL, event VARCHAR(255) NOT NULL, payload TEXT NOT NULL, status VARCHAR(16) NOT NULL, attempts integer NOT NULL DEFAULT 0, response_code integer, last_error TEXT, next_run_at timestamptz NOT NULL, created_at timestamptz NOT NULL, delivered_at timestamptz, CONSTRAINT webhook_deliveries_pkey PRIMARY KEY (id));
+CREATE INDEX webhook_deliveries_status_next_run_at_index ON webhook_deliveries (status, next_run_at);
@@ -0,0 +1,5 @@
+DROP INDEX jobs_reserved_name_index;
+DROP INDEX jobs_idempotency_key_index;
+ALTER TABLE jobs DROP COLUMN reserved_name;
+ALTER TABLE jobs DROP COLUMN request_hash;
+ALTER TABLE jobs DROP COLUMN idempotency_key;
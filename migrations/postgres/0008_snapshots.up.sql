@@ -0,0 +1,2 @@
+CREATE TABLE snapshots (id VARCHAR(36) NOT NULL, container_id VARCHAR(36) NOT NULL, name VARCHAR(255) NOT NULL, description TEXT NOT NULL, host_id VARCHAR(255), state VARCHAR(16) NOT NULL, parameters TEXT, created_at timestamptz NOT NULL, CONSTRAINT snapshots_pkey PRIMARY KEY (id));
+CREATE INDEX snapshots_container_id_index ON snapshots (container_id);
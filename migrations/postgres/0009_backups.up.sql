@@ -0,0 +1,2 @@
+CREATE TABLE backups (id VARCHAR(36) NOT NULL, container_id VARCHAR(36) NOT NULL, container_name VARCHAR(255) NOT NULL, ostemplate VARCHAR(255) NOT NULL, description TEXT NOT NULL, host_id VARCHAR(255), archive TEXT, size BIGINT, checksum VARCHAR(64), state VARCHAR(16) NOT NULL, parameters TEXT, expires_at timestamptz, created_at timestamptz NOT NULL, CONSTRAINT backups_pkey PRIMARY KEY (id));
+CREATE INDEX backups_container_id_index ON backups (container_id);
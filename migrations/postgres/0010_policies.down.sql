@@ -0,0 +1,4 @@
+ALTER TABLE backups DROP COLUMN policy_id;
+ALTER TABLE snapshots DROP COLUMN policy_id;
+DROP TABLE policy_containers;
+DROP TABLE policies;
@@ -0,0 +1,5 @@
+CREATE TABLE policies (id VARCHAR(36) NOT NULL, name VARCHAR(255) NOT NULL, kind VARCHAR(16) NOT NULL, schedule VARCHAR(255) NOT NULL, keep_last integer NOT NULL DEFAULT 0, keep_daily integer NOT NULL DEFAULT 0, keep_weekly integer NOT NULL DEFAULT 0, keep_monthly integer NOT NULL DEFAULT 0, next_run_at timestamptz, created_at timestamptz NOT NULL, CONSTRAINT policies_pkey PRIMARY KEY (id));
+CREATE TABLE policy_containers (policy_id VARCHAR(36) NOT NULL, container_id VARCHAR(36) NOT NULL, created_at timestamptz NOT NULL, CONSTRAINT policy_containers_pkey PRIMARY KEY (policy_id, container_id));
+CREATE INDEX policy_containers_container_id_index ON policy_containers (container_id);
+ALTER TABLE snapshots ADD COLUMN policy_id VARCHAR(36);
+ALTER TABLE backups ADD COLUMN policy_id VARCHAR(36);
@@ -0,0 +1 @@
+CREATE TABLE templates (name VARCHAR(255) NOT NULL, created_at timestamptz NOT NULL, synced_at timestamptz NOT NULL, CONSTRAINT templates_pkey PRIMARY KEY (name));
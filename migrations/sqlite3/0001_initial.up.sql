@@ -0,0 +1,3 @@
+CREATE TABLE IF NOT EXISTS containers (id CHAR(36) NOT NULL, name VARCHAR(255) NOT NULL, os_template VARCHAR(255) NOT NULL, parameters TEXT, created_at datetime default current_timestamp, CONSTRAINT rid_pkey PRIMARY KEY (id));
+CREATE TABLE IF NOT EXISTS jobs (id uuid NOT NULL, type VARCHAR(255) NOT NULL, payload text NOT NULL, status integer NOT NULL, entity_type integer, entity_id integer, created_at timestamp NOT NULL default current_timestamp, locked_at timestamp, error_descr varchar(255), CONSTRAINT rid_pkey PRIMARY KEY (id));
+CREATE INDEX IF NOT EXISTS jobs_status_locked_at_created_at_index ON jobs (status, locked_at, created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE containers ADD COLUMN state VARCHAR(16) NOT NULL DEFAULT 'creating';
+-- States of containers created before are unknown, they are assumed to be stopped.
+UPDATE containers SET state='stopped';
@@ -0,0 +1,10 @@
+ALTER TABLE jobs ADD COLUMN locked_by VARCHAR(36);
+ALTER TABLE jobs ADD COLUMN heartbeat_at timestamp;
+ALTER TABLE jobs ADD COLUMN attempts integer NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN max_attempts integer NOT NULL DEFAULT 1;
+ALTER TABLE jobs ADD COLUMN next_run_at timestamp;
+ALTER TABLE jobs ADD COLUMN cancel_requested boolean NOT NULL DEFAULT false;
+ALTER TABLE jobs ADD COLUMN last_error TEXT;
+UPDATE jobs SET last_error=error_descr;
+UPDATE jobs SET heartbeat_at=locked_at WHERE locked_at IS NOT NULL;
+ALTER TABLE jobs DROP COLUMN error_descr;
@@ -0,0 +1,2 @@
+CREATE TABLE job_logs (id INTEGER PRIMARY KEY AUTOINCREMENT, job_id uuid NOT NULL, command TEXT NOT NULL, exit_code integer, stdout TEXT NOT NULL, stderr TEXT NOT NULL, started_at timestamp NOT NULL, finished_at timestamp);
+CREATE INDEX job_logs_job_id_index ON job_logs (job_id);
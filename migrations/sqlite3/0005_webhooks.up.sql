@@ -0,0 +1,3 @@
+CREATE TABLE webhooks (id uuid NOT NULL, url TEXT NOT NULL, secret VARCHAR(255) NOT NULL, events TEXT NOT NULL, created_at timestamp NOT NULL, CONSTRAINT rid_pkey PRIMARY KEY (id));
+CREATE TABLE webhook_deliveries (id uuid NOT NULL, webhook_id uuid NOT NULL, event VARCHAR(255) NOT NULL, payload TEXT NOT NULL, status VARCHAR(16) NOT NULL, attempts integer NOT NULL DEFAULT 0, response_code integer, last_error TEXT, next_run_at timestamp NOT NULL, created_at timestamp NOT NULL, delivered_at timestamp, CONSTRAINT rid_pkey PRIMARY KEY (id));
+CREATE INDEX webhook_deliveries_status_next_run_at_index ON webhook_deliveries (status, next_run_at);
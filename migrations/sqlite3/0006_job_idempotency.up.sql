@@ -0,0 +1,6 @@
+ALTER TABLE jobs ADD COLUMN idempotency_key VARCHAR(255);
+ALTER TABLE jobs ADD COLUMN request_hash CHAR(64);
+ALTER TABLE jobs ADD COLUMN reserved_name VARCHAR(255);
+CREATE UNIQUE INDEX jobs_idempotency_key_index ON jobs (idempotency_key);
+-- Names are reserved by pending jobs only, status 0 is models.PENDING
+CREATE UNIQUE INDEX jobs_reserved_name_index ON jobs (reserved_name) WHERE status=0;
@@ -0,0 +1 @@
+ALTER TABLE jobs ADD COLUMN dry_run BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+CREATE TABLE backups (id uuid NOT NULL, container_id uuid NOT NULL, container_name VARCHAR(255) NOT NULL, ostemplate VARCHAR(255) NOT NULL, description TEXT NOT NULL, host_id VARCHAR(255), archive TEXT, size BIGINT, checksum VARCHAR(64), state VARCHAR(16) NOT NULL, parameters TEXT, expires_at timestamp, created_at timestamp NOT NULL, CONSTRAINT rid_pkey PRIMARY KEY (id));
+CREATE INDEX backups_container_id_index ON backups (container_id);
@@ -0,0 +1,5 @@
+CREATE TABLE policies (id uuid NOT NULL, name VARCHAR(255) NOT NULL, kind VARCHAR(16) NOT NULL, schedule VARCHAR(255) NOT NULL, keep_last integer NOT NULL DEFAULT 0, keep_daily integer NOT NULL DEFAULT 0, keep_weekly integer NOT NULL DEFAULT 0, keep_monthly integer NOT NULL DEFAULT 0, next_run_at timestamp, created_at timestamp NOT NULL, CONSTRAINT rid_pkey PRIMARY KEY (id));
+CREATE TABLE policy_containers (policy_id uuid NOT NULL, container_id uuid NOT NULL, created_at timestamp NOT NULL, PRIMARY KEY (policy_id, container_id));
+CREATE INDEX policy_containers_container_id_index ON policy_containers (container_id);
+ALTER TABLE snapshots ADD COLUMN policy_id uuid;
+ALTER TABLE backups ADD COLUMN policy_id uuid;
@@ -0,0 +1 @@
+CREATE TABLE templates (name VARCHAR(255) NOT NULL, created_at timestamp NOT NULL, synced_at timestamp NOT NULL, CONSTRAINT templates_pkey PRIMARY KEY (name));
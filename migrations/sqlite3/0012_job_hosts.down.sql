@@ -0,0 +1,2 @@
+DROP INDEX jobs_host_locked_at_index;
+ALTER TABLE jobs DROP COLUMN host;
@@ -0,0 +1,2 @@
+ALTER TABLE jobs ADD COLUMN host VARCHAR(255) NOT NULL DEFAULT '';
+CREATE INDEX jobs_host_locked_at_index ON jobs (host, locked_at);
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	BackupCreating  = "creating"
+	BackupReady     = "ready"
+	BackupRestoring = "restoring"
+	BackupDeleting  = "deleting"
+	BackupFailed    = "failed"
+	// BackupDeleteFailed is a backup whose deletion failed for good, it is no longer pruned
+	BackupDeleteFailed = "delete-failed"
+)
+
+// Backup of a container. It outlives the container, so that the container can be restored.
+type Backup struct {
+	ID          string `json:"id" db:"id"`
+	ContainerID string `json:"container_id" db:"container_id"`
+	// ContainerName and OSTemplate are ones of a container at the time a backup is made
+	ContainerName string `json:"container_name" db:"container_name"`
+	OSTemplate    string `json:"ostemplate" db:"ostemplate"`
+	Description   string `json:"description" db:"description"`
+	// HostID identifies a backup in commands, it is set once a backup is made
+	HostID sql.NullString `json:"host_id" db:"host_id"`
+	// Archive, Size and Checksum are set if a backup is kept in a local directory
+	Archive  sql.NullString `json:"archive" db:"archive"`
+	Size     sql.NullInt64  `json:"size" db:"size"`
+	Checksum sql.NullString `json:"checksum" db:"checksum"`
+	State    string         `json:"state" db:"state"`
+	// ParametersJSON keeps parameters of a container at the time a backup is made
+	ParametersJSON sql.NullString `json:"-" db:"parameters"`
+	// ExpiresAt is when a backup is no longer retained, never if null
+	ExpiresAt sql.NullTime `json:"expires_at" db:"expires_at"`
+	// PolicyID is set if a backup is made by a policy
+	PolicyID  sql.NullString `json:"policy_id" db:"policy_id"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+}
+
+func (b *Backup) Parameters() (map[string]string, error) {
+	var parameters map[string]string
+	if !b.ParametersJSON.Valid {
+		return parameters, nil
+	}
+
+	err := json.Unmarshal([]byte(b.ParametersJSON.String), &parameters)
+
+	return parameters, err
+}
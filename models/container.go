@@ -22,12 +22,25 @@ import (
 	"time"
 )
 
+type ContainerState string
+
+const (
+	CREATING  ContainerState = "creating"
+	STOPPED   ContainerState = "stopped"
+	RUNNING   ContainerState = "running"
+	SUSPENDED ContainerState = "suspended"
+	ERROR     ContainerState = "error"
+	// MISSING containers are not found on a host anymore
+	MISSING ContainerState = "missing"
+)
+
 type Container struct {
 	ID             string            `json:"id" db:"id"`
 	Name           string            `json:"name" db:"name"`
 	OSTemplate     string            `json:"ostemplate" db:"os_template"`
+	State          ContainerState    `json:"state" db:"state"`
 	Parameters     map[string]string `json:"parameters" db:"-"`
-	ParametersJSON sql.NullString    `db:"parameters"`
+	ParametersJSON sql.NullString    `json:"-" db:"parameters"`
 	CreatedAt      time.Time         `json:"created_at" db:"created_at"`
 }
 
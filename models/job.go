@@ -1,8 +1,25 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
 package models
 
 import (
 	"database/sql"
 	"encoding/json"
+	"time"
 )
 
 type JobStatus int
@@ -11,13 +28,40 @@ const (
 	PENDING = iota
 	DONE
 	FAILED
+	CANCELLED
 )
 
+// JobStatusNames defines names of job statuses used by API
+var JobStatusNames = map[JobStatus]string{
+	PENDING:   "pending",
+	DONE:      "done",
+	FAILED:    "failed",
+	CANCELLED: "cancelled",
+}
+
+// ParseJobStatus returns a job status by its name
+func ParseJobStatus(name string) (JobStatus, bool) {
+	for status, statusName := range JobStatusNames {
+		if statusName == name {
+			return status, true
+		}
+	}
+
+	return 0, false
+}
+
 type Job struct {
-	ID         string          `json:"id" db:"id"`
-	Type       string          `json:"type" db:"type"`
-	Status     JobStatus       `json:"status,omitempty" db:"status"`
-	Payload    json.RawMessage `json:"payload" db:"payload"`
-	EntityType sql.NullString  `json:"entity_type,omitempty" db:"entity_type"`
-	EntityID   sql.NullString  `json:"entity_id,omitempty" db:"entity_id"`
+	ID          string          `json:"id" db:"id"`
+	Type        string          `json:"type" db:"type"`
+	Status      JobStatus       `json:"status,omitempty" db:"status"`
+	Payload     json.RawMessage `json:"payload" db:"payload"`
+	EntityType  sql.NullString  `json:"entity_type,omitempty" db:"entity_type"`
+	EntityID    sql.NullString  `json:"entity_id,omitempty" db:"entity_id"`
+	Attempts    int             `json:"attempts" db:"attempts"`
+	MaxAttempts int             `json:"max_attempts" db:"max_attempts"`
+	LastError   sql.NullString  `json:"last_error,omitempty" db:"last_error"`
+	NextRunAt   sql.NullTime    `json:"next_run_at,omitempty" db:"next_run_at"`
+	LockedBy    sql.NullString  `json:"-" db:"locked_by"`
+	DryRun      bool            `json:"dry_run" db:"dry_run"` // a dry run only records commands
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
 }
@@ -0,0 +1,34 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// JobLog is a log of a command run by a job
+type JobLog struct {
+	ID         int64         `json:"id" db:"id"`
+	JobID      string        `json:"job_id" db:"job_id"`
+	Command    string        `json:"command" db:"command"`
+	ExitCode   sql.NullInt64 `json:"exit_code" db:"exit_code"`
+	Stdout     string        `json:"stdout" db:"stdout"`
+	Stderr     string        `json:"stderr" db:"stderr"`
+	StartedAt  time.Time     `json:"started_at" db:"started_at"`
+	FinishedAt sql.NullTime  `json:"finished_at" db:"finished_at"`
+}
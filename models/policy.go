@@ -0,0 +1,47 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Kinds of artifacts policies make
+const (
+	PolicyBackup   = "backup"
+	PolicySnapshot = "snapshot"
+)
+
+// Policy makes backups or snapshots of containers it is attached to on a schedule,
+// and prunes ones it made beyond its retention rules
+type Policy struct {
+	ID   string `json:"id" db:"id"`
+	Name string `json:"name" db:"name"`
+	Kind string `json:"kind" db:"kind"`
+	// Schedule is a cron expression
+	Schedule string `json:"schedule" db:"schedule"`
+	// KeepLast is a number of the latest artifacts retained, while KeepDaily, KeepWeekly
+	// and KeepMonthly are numbers of days, weeks and months whose latest artifacts are retained
+	KeepLast    int `json:"keep_last" db:"keep_last"`
+	KeepDaily   int `json:"keep_daily" db:"keep_daily"`
+	KeepWeekly  int `json:"keep_weekly" db:"keep_weekly"`
+	KeepMonthly int `json:"keep_monthly" db:"keep_monthly"`
+	// NextRunAt is when a policy is run next
+	NextRunAt sql.NullTime `json:"next_run_at" db:"next_run_at"`
+	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	SnapshotCreating  = "creating"
+	SnapshotReady     = "ready"
+	SnapshotReverting = "reverting"
+	SnapshotDeleting  = "deleting"
+	SnapshotFailed    = "failed"
+)
+
+type Snapshot struct {
+	ID          string `json:"id" db:"id"`
+	ContainerID string `json:"container_id" db:"container_id"`
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+	// HostID identifies a snapshot in commands, it is set once a snapshot is taken
+	HostID sql.NullString `json:"host_id" db:"host_id"`
+	State  string         `json:"state" db:"state"`
+	// ParametersJSON keeps parameters of a container at the time a snapshot is taken
+	ParametersJSON sql.NullString `json:"-" db:"parameters"`
+	// PolicyID is set if a snapshot is taken by a policy
+	PolicyID  sql.NullString `json:"policy_id" db:"policy_id"`
+	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+}
+
+func (s *Snapshot) Parameters() (map[string]string, error) {
+	var parameters map[string]string
+	if !s.ParametersJSON.Valid {
+		return parameters, nil
+	}
+
+	err := json.Unmarshal([]byte(s.ParametersJSON.String), &parameters)
+
+	return parameters, err
+}
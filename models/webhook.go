@@ -0,0 +1,56 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+type Webhook struct {
+	ID     string `json:"id" db:"id"`
+	URL    string `json:"url" db:"url"`
+	Secret string `json:"-" db:"secret"`
+	// Events is a comma separated list of events the webhook subscribes to
+	Events    string    `json:"events" db:"events"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+func (w *Webhook) EventList() []string {
+	return strings.Split(w.Events, ",")
+}
+
+type WebhookDelivery struct {
+	ID           string         `json:"id" db:"id"`
+	WebhookID    string         `json:"webhook_id" db:"webhook_id"`
+	Event        string         `json:"event" db:"event"`
+	Payload      []byte         `json:"-" db:"payload"`
+	Status       string         `json:"status" db:"status"`
+	Attempts     int            `json:"attempts" db:"attempts"`
+	ResponseCode sql.NullInt64  `json:"response_code" db:"response_code"`
+	LastError    sql.NullString `json:"last_error" db:"last_error"`
+	NextRunAt    time.Time      `json:"next_run_at" db:"next_run_at"`
+	CreatedAt    time.Time      `json:"created_at" db:"created_at"`
+	DeliveredAt  sql.NullTime   `json:"delivered_at" db:"delivered_at"`
+}
@@ -20,71 +20,121 @@ import (
 	"log"
 
 	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/migrations"
+	"github.com/romiras/go-openvz-api/repositories"
 	"github.com/romiras/go-openvz-api/services"
-	openvzcmd "github.com/romiras/go-openvz-cmd"
-)
-
-const (
-	SQL_CREATE_CONTAINERS = "CREATE TABLE IF NOT EXISTS containers (id CHAR(36) NOT NULL, name VARCHAR(255) NOT NULL, os_template VARCHAR(255) NOT NULL, parameters TEXT, created_at datetime default current_timestamp, CONSTRAINT rid_pkey PRIMARY KEY (id))"
-	SQL_CREATE_JOBS       = "CREATE TABLE jobs (id uuid NOT NULL, type VARCHAR(255) NOT NULL, payload text NOT NULL, status integer NOT NULL, entity_type integer, entity_id integer, created_at timestamp NOT NULL default current_timestamp, locked_at timestamp, error_descr varchar(255), CONSTRAINT rid_pkey PRIMARY KEY (id))"
-	SQL_CREATE_JOBS_INDEX = "CREATE INDEX jobs_status_locked_at_created_at_index ON jobs (status, locked_at, created_at)"
 )
 
 type Registry struct {
 	ContainerAPIService *services.ContainerAPIService
 	JobAPIService       *services.JobAPIService
 	JobService          *services.JobService
+	WebhookAPIService   *services.WebhookAPIService
+	WebhookService      *services.WebhookService
+	CommandAPIService   *services.CommandAPIService
+	ReconcileService    *services.ReconcileService
+	SnapshotAPIService  *services.SnapshotAPIService
+	BackupAPIService    *services.BackupAPIService
+	PolicyAPIService    *services.PolicyAPIService
+	SchedulerService    *services.SchedulerService
+	TemplateAPIService  *services.TemplateAPIService
 	DB                  services.DBConnection
-	Commander           *openvzcmd.POCCommanderStub
+	Commander           commanders.Commander
+	Events              *events.Bus
 }
 
-func NewRegistry(dsn *string) *Registry {
-	db := InitializeDB("sqlite3", *dsn)
+// NewRegistry wires services together. Retry policies of job types are derived from retries,
+// which is the default one.
+func NewRegistry(driver, dsn, backend, commandsPath *string, retries services.RetryPolicy) *Registry {
+	db := InitializeDB(*driver, *dsn)
 
-	cmd, err := openvzcmd.NewPOCCommanderStub("vz_commands.yml")
+	cmd, err := commanders.NewCommander(*backend, *commandsPath)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
+	bus := events.NewBus()
+	jobService := services.NewJobService(db, cmd, bus)
+	setRetryPolicies(jobService, retries)
+	snapshotAPIService := services.NewSnapshotAPIService(db, jobService)
+	backupAPIService := services.NewBackupAPIService(db, jobService)
+	policyAPIService := services.NewPolicyAPIService(db)
+
 	return &Registry{
-		ContainerAPIService: services.NewContainerAPIService(db, cmd),
-		JobAPIService:       services.NewJobAPIService(db, cmd),
-		JobService:          services.NewJobService(db, cmd),
+		ContainerAPIService: services.NewContainerAPIService(db, cmd, jobService),
+		JobAPIService:       services.NewJobAPIService(db, cmd, jobService, bus),
+		JobService:          jobService,
+		WebhookAPIService:   services.NewWebhookAPIService(db),
+		WebhookService:      jobService.Webhooks,
+		CommandAPIService:   services.NewCommandAPIService(cmd),
+		ReconcileService:    services.NewReconcileService(db, cmd),
+		SnapshotAPIService:  snapshotAPIService,
+		BackupAPIService:    backupAPIService,
+		PolicyAPIService:    policyAPIService,
+		SchedulerService:    services.NewSchedulerService(policyAPIService, backupAPIService, snapshotAPIService),
+		TemplateAPIService:  services.NewTemplateAPIService(db, cmd),
 		DB:                  db,
 		Commander:           cmd,
+		Events:              bus,
 	}
 }
 
-func createTables(db services.DBConnection) error {
-	_, err := db.Exec(SQL_CREATE_CONTAINERS)
-	if err != nil {
-		return err
-	}
+// InitializeDB opens a database and applies pending migrations to it
+func InitializeDB(driver, dsn string) services.DBConnection {
+	db := OpenDB(driver, dsn)
 
-	_, err = db.Exec(SQL_CREATE_JOBS)
+	migrator, err := migrations.NewMigrator(db, driver)
 	if err != nil {
-		return err
+		log.Fatal(err.Error())
 	}
 
-	_, err = db.Exec(SQL_CREATE_JOBS_INDEX)
+	applied, err := migrator.Up(0)
 	if err != nil {
-		return err
+		log.Fatal(err.Error())
+	}
+	for _, m := range applied {
+		log.Printf("Applied migration %04d_%s", m.Version, m.Name)
 	}
 
-	return nil
+	return db
 }
 
-func InitializeDB(driver, dsn string) services.DBConnection {
+func OpenDB(driver, dsn string) services.DBConnection {
 	db := sqlx.MustConnect(driver, dsn)
+	if driver == repositories.SQLiteDriver {
+		// SQLite allows a single writer, and every connection to ":memory:" opens a new database.
+		db.SetMaxOpenConns(1)
+	}
 	if err := db.Ping(); err != nil {
 		log.Fatal(err.Error())
 	}
 
-	err := createTables(db)
-	if err != nil {
-		log.Fatal(err.Error())
+	return db
+}
+
+// setRetryPolicies sets the default retry policy and overrides it for jobs which should not be retried soon
+func setRetryPolicies(j *services.JobService, retries services.RetryPolicy) {
+	j.DefaultRetryPolicy = retries
+
+	// Deletes and restores are left to a client once they fail, a retry may act on a changed container
+	noRetries := retries
+	noRetries.MaxAttempts = 1
+	for _, jobType := range []string{services.DeleteContainerType, services.DeleteSnapshotType, services.DeleteBackupType,
+		services.RevertSnapshotType, services.RestoreBackupType} {
+		j.SetRetryPolicy(jobType, noRetries)
 	}
 
-	return db
+	// Backups load a host heavily, so they are retried later than other jobs
+	backupRetries := retries
+	if backupRetries.Backoff < services.BackupRetryBackoff {
+		backupRetries.Backoff = services.BackupRetryBackoff
+	}
+	if backupRetries.MaxBackoff < services.BackupRetryMaxBackoff {
+		backupRetries.MaxBackoff = services.BackupRetryMaxBackoff
+	}
+	j.SetRetryPolicy(services.CreateBackupType, backupRetries)
 }
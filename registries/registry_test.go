@@ -0,0 +1,54 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package registries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/romiras/go-openvz-api/services"
+)
+
+// Retry policies of job types follow -maxattempts and -retrybackoff
+func TestRetryPoliciesFollowFlags(t *testing.T) {
+	for _, tc := range []struct {
+		backoff time.Duration
+		want    time.Duration
+	}{
+		{time.Second, services.BackupRetryBackoff},
+		{5 * time.Minute, 5 * time.Minute},
+	} {
+		retries := services.NewDefaultRetryPolicy()
+		retries.MaxAttempts = 7
+		retries.Backoff = tc.backoff
+
+		driver, dsn, backend, commandsPath := "sqlite3", ":memory:", "fake", ""
+		registry := NewRegistry(&driver, &dsn, &backend, &commandsPath, retries)
+		registry.DB.Close()
+
+		if policy := registry.JobService.RetryPolicy(services.StartContainerType); policy != retries {
+			t.Errorf("Start policy = %+v, want %+v", policy, retries)
+		}
+		policy := registry.JobService.RetryPolicy(services.CreateBackupType)
+		if policy.MaxAttempts != 7 || policy.Backoff != tc.want || policy.MaxBackoff != services.BackupRetryMaxBackoff {
+			t.Errorf("Backup policy with backoff %v = %+v, want 7 attempts and backoff %v", tc.backoff, policy, tc.want)
+		}
+		if policy := registry.JobService.RetryPolicy(services.DeleteBackupType); policy.MaxAttempts != 1 || policy.Backoff != tc.backoff {
+			t.Errorf("Delete policy = %+v, want 1 attempt", policy)
+		}
+	}
+}
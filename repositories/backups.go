@@ -0,0 +1,113 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+const backupColumns = "id, container_id, container_name, ostemplate, description, host_id, archive, size, checksum, state, parameters, expires_at, policy_id, created_at"
+
+type SQLBackupRepository struct {
+	sqlRepository
+}
+
+func NewBackupRepository(db *sqlx.DB) *SQLBackupRepository {
+	return &SQLBackupRepository{sqlRepository{db: db}}
+}
+
+func (r *SQLBackupRepository) Create(backup *models.Backup) error {
+	_, err := r.db.Exec(r.q("INSERT INTO backups ("+backupColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		backup.ID, backup.ContainerID, backup.ContainerName, backup.OSTemplate, backup.Description, backup.HostID, backup.Archive,
+		backup.Size, backup.Checksum, backup.State, backup.ParametersJSON, backup.ExpiresAt, backup.PolicyID, backup.CreatedAt)
+
+	return err
+}
+
+func (r *SQLBackupRepository) FindByID(id string) (*models.Backup, error) {
+	var backup models.Backup
+
+	err := r.db.Get(&backup, r.q("SELECT "+backupColumns+" FROM backups WHERE id=? LIMIT 1"), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backup, nil
+}
+
+func (r *SQLBackupRepository) List(containerID string) ([]*models.Backup, error) {
+	backups := make([]*models.Backup, 0)
+	if containerID == "" {
+		err := r.db.Select(&backups, r.q("SELECT "+backupColumns+" FROM backups ORDER BY created_at"))
+		return backups, err
+	}
+
+	err := r.db.Select(&backups, r.q("SELECT "+backupColumns+" FROM backups WHERE container_id=? ORDER BY created_at"), containerID)
+
+	return backups, err
+}
+
+func (r *SQLBackupRepository) ListByPolicy(policyID string) ([]*models.Backup, error) {
+	backups := make([]*models.Backup, 0)
+	err := r.db.Select(&backups, r.q("SELECT "+backupColumns+" FROM backups WHERE policy_id=? ORDER BY created_at"), policyID)
+
+	return backups, err
+}
+
+func (r *SQLBackupRepository) ListExpired(now time.Time) ([]*models.Backup, error) {
+	backups := make([]*models.Backup, 0)
+	err := r.db.Select(&backups, r.q("SELECT "+backupColumns+" FROM backups WHERE expires_at<=? AND state IN (?, ?) ORDER BY expires_at"),
+		now, models.BackupReady, models.BackupFailed)
+
+	return backups, err
+}
+
+func (r *SQLBackupRepository) Transition(id string, to string, from ...string) (bool, error) {
+	args := []interface{}{to, id}
+	for _, state := range from {
+		args = append(args, state)
+	}
+
+	res, err := r.db.Exec(r.q("UPDATE backups SET state=? WHERE id=? AND state IN (?"+strings.Repeat(", ?", len(from)-1)+")"), args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+func (r *SQLBackupRepository) SetState(id, state string) error {
+	_, err := r.db.Exec(r.q("UPDATE backups SET state=? WHERE id=?"), state, id)
+	return err
+}
+
+func (r *SQLBackupRepository) MarkReady(backup *models.Backup) error {
+	_, err := r.db.Exec(r.q("UPDATE backups SET state=?, host_id=?, archive=?, size=?, checksum=? WHERE id=?"),
+		models.BackupReady, backup.HostID, backup.Archive, backup.Size, backup.Checksum, backup.ID)
+
+	return err
+}
+
+func (r *SQLBackupRepository) Delete(id string) error {
+	_, err := r.db.Exec(r.q("DELETE FROM backups WHERE id=?"), id)
+	return err
+}
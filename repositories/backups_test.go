@@ -0,0 +1,152 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func createBackup(t *testing.T, repo *SQLBackupRepository, id, containerID, state string, createdAt time.Time) *models.Backup {
+	t.Helper()
+
+	backup := &models.Backup{
+		ID:             id,
+		ContainerID:    containerID,
+		ContainerName:  "c1",
+		OSTemplate:     "centos-7-x86_64",
+		State:          state,
+		ParametersJSON: sql.NullString{String: `{"hostname":"c1.example"}`, Valid: true},
+		CreatedAt:      createdAt,
+	}
+	if err := repo.Create(backup); err != nil {
+		t.Fatal(err)
+	}
+
+	return backup
+}
+
+func TestBackupRepository(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewBackupRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		createBackup(t, repo, "backup-1", "ct-1", models.BackupCreating, now)
+		createBackup(t, repo, "backup-2", "ct-2", models.BackupReady, now.Add(time.Second))
+
+		backup, err := repo.FindByID("backup-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		parameters, err := backup.Parameters()
+		if err != nil || parameters["hostname"] != "c1.example" || !backup.CreatedAt.Equal(now) {
+			t.Errorf("got backup %+v: %v", backup, err)
+		}
+		if _, err := repo.FindByID("backup-3"); err != sql.ErrNoRows {
+			t.Errorf("got %v for an unknown backup, want sql.ErrNoRows", err)
+		}
+
+		backups, err := repo.List("ct-2")
+		if err != nil || len(backups) != 1 || backups[0].ID != "backup-2" {
+			t.Errorf("got backups %v of a container: %v", backups, err)
+		}
+		if backups, err = repo.List(""); err != nil || len(backups) != 2 {
+			t.Errorf("got backups %v: %v", backups, err)
+		}
+
+		backup.Archive = sql.NullString{String: "/vz/dump/backup-1.tar", Valid: true}
+		backup.Size = sql.NullInt64{Int64: 1024, Valid: true}
+		if err := repo.MarkReady(backup); err != nil {
+			t.Fatal(err)
+		}
+		if backup, err = repo.FindByID("backup-1"); err != nil || backup.State != models.BackupReady || backup.Size.Int64 != 1024 {
+			t.Errorf("got backup %+v: %v", backup, err)
+		}
+
+		if ok, err := repo.Transition("backup-1", models.BackupDeleting, models.BackupCreating, models.BackupFailed); err != nil || ok {
+			t.Errorf("a backup is moved from another state: %v", err)
+		}
+		if ok, err := repo.Transition("backup-1", models.BackupDeleting, models.BackupReady, models.BackupFailed); err != nil || !ok {
+			t.Errorf("a backup is not moved: %v", err)
+		}
+
+		if err := repo.Delete("backup-1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.FindByID("backup-1"); err != sql.ErrNoRows {
+			t.Errorf("got %v for a deleted backup, want sql.ErrNoRows", err)
+		}
+	})
+}
+
+func TestBackupListExpired(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewBackupRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		expiresAt := sql.NullTime{Time: now, Valid: true}
+
+		for _, backup := range []*models.Backup{
+			{ID: "backup-1", State: models.BackupReady, ExpiresAt: expiresAt},
+			{ID: "backup-2", State: models.BackupFailed, ExpiresAt: expiresAt},
+			{ID: "backup-3", State: models.BackupRestoring, ExpiresAt: expiresAt},
+			{ID: "backup-4", State: models.BackupReady, ExpiresAt: sql.NullTime{Time: now.Add(time.Second), Valid: true}},
+			{ID: "backup-5", State: models.BackupReady},
+		} {
+			backup.ContainerID = "ct-1"
+			backup.CreatedAt = now.Add(-time.Hour)
+			if err := repo.Create(backup); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		backups, err := repo.ListExpired(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(backups) != 2 || backups[0].ID == "backup-3" || backups[1].ID == "backup-3" {
+			t.Errorf("got expired backups %v", backups)
+		}
+	})
+}
+
+func TestBackupListByPolicy(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewBackupRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		policyID := sql.NullString{String: "policy-1", Valid: true}
+
+		for i, backup := range []*models.Backup{
+			{ID: "backup-1", ContainerID: "ct-1", PolicyID: policyID},
+			{ID: "backup-2", ContainerID: "ct-2"},
+			{ID: "backup-3", ContainerID: "ct-2", PolicyID: policyID},
+		} {
+			backup.State = models.BackupReady
+			backup.CreatedAt = now.Add(time.Duration(i) * time.Second)
+			if err := repo.Create(backup); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		backups, err := repo.ListByPolicy("policy-1")
+		if err != nil || len(backups) != 2 || backups[0].ID != "backup-1" || backups[1].ID != "backup-3" {
+			t.Errorf("got backups %v of a policy: %v", backups, err)
+		}
+	})
+}
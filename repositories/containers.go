@@ -0,0 +1,127 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+const containerColumns = "id, name, os_template, state, parameters, created_at"
+
+type SQLContainerRepository struct {
+	sqlRepository
+}
+
+func NewContainerRepository(db *sqlx.DB) *SQLContainerRepository {
+	return &SQLContainerRepository{sqlRepository{db: db}}
+}
+
+func (r *SQLContainerRepository) FindByID(id string) (*models.Container, error) {
+	var container models.Container
+
+	err := r.db.Get(&container, r.q("SELECT "+containerColumns+" FROM containers WHERE id=? LIMIT 1"), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &container, container.UnmarshalParametersDB()
+}
+
+func (r *SQLContainerRepository) FindIDByName(name string, state models.ContainerState) (string, error) {
+	var id string
+	err := r.db.Get(&id, r.q("SELECT id FROM containers WHERE name=? AND state=? LIMIT 1"), name, state)
+
+	return id, err
+}
+
+func (r *SQLContainerRepository) HasName(name string) (bool, error) {
+	var i int
+	err := r.db.Get(&i, r.q("SELECT 1 FROM containers WHERE name=? LIMIT 1"), name)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *SQLContainerRepository) List(limit int) ([]*models.Container, error) {
+	return r.list(r.q("SELECT "+containerColumns+" FROM containers ORDER BY created_at, id LIMIT ?"), limit)
+}
+
+func (r *SQLContainerRepository) ListAll() ([]*models.Container, error) {
+	return r.list("SELECT " + containerColumns + " FROM containers ORDER BY created_at")
+}
+
+func (r *SQLContainerRepository) list(query string, args ...interface{}) ([]*models.Container, error) {
+	containers := make([]*models.Container, 0)
+
+	err := r.db.Select(&containers, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, container := range containers {
+		err = container.UnmarshalParametersDB()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return containers, nil
+}
+
+func (r *SQLContainerRepository) Create(container *models.Container) error {
+	_, err := r.db.Exec(r.q("INSERT INTO containers (id, name, os_template, state) VALUES (?, ?, ?, ?)"),
+		container.ID, container.Name, container.OSTemplate, container.State)
+
+	return err
+}
+
+func (r *SQLContainerRepository) GetState(id string) (models.ContainerState, error) {
+	var state models.ContainerState
+	err := r.db.Get(&state, r.q("SELECT state FROM containers WHERE id=?"), id)
+
+	return state, err
+}
+
+func (r *SQLContainerRepository) SetState(id string, state models.ContainerState) error {
+	_, err := r.db.Exec(r.q("UPDATE containers SET state=? WHERE id=?"), state, id)
+	return err
+}
+
+func (r *SQLContainerRepository) SetParameters(id string, parameters map[string]string) error {
+	jsonData, err := json.Marshal(parameters)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(r.q("UPDATE containers SET parameters=? WHERE id=?"), string(jsonData), id)
+
+	return err
+}
+
+func (r *SQLContainerRepository) Delete(id string) error {
+	_, err := r.db.Exec(r.q("DELETE FROM containers WHERE id=?"), id)
+	return err
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func TestContainerRepository(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewContainerRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+
+		for _, container := range []*models.Container{
+			{ID: "ct-2", Name: "web", OSTemplate: "centos-7-x86_64", State: models.CREATING},
+			{ID: "ct-1", Name: "db", OSTemplate: "debian-10-x86_64", State: models.STOPPED},
+			{ID: "ct-3", Name: "mail", OSTemplate: "centos-7-x86_64", State: models.STOPPED},
+		} {
+			if err := repo.Create(container); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// ct-1 and ct-2 are created at the same time, ct-3 later
+		db.MustExec(db.Rebind("UPDATE containers SET created_at=? WHERE id<>?"), now, "ct-3")
+		db.MustExec(db.Rebind("UPDATE containers SET created_at=? WHERE id=?"), now.Add(time.Second), "ct-3")
+
+		if err := repo.SetParameters("ct-2", map[string]string{"cpus": "2", "hostname": "web.example"}); err != nil {
+			t.Fatal(err)
+		}
+		container, err := repo.FindByID("ct-2")
+		if err != nil || container.Name != "web" || container.OSTemplate != "centos-7-x86_64" || container.Parameters["hostname"] != "web.example" {
+			t.Errorf("got container %+v: %v", container, err)
+		}
+		if container, err = repo.FindByID("ct-1"); err != nil || container.Parameters != nil {
+			t.Errorf("got container %+v without parameters: %v", container, err)
+		}
+
+		for _, tc := range []struct {
+			name  string
+			state models.ContainerState
+			want  string
+			err   error
+		}{
+			{"web", models.CREATING, "ct-2", nil},
+			{"web", models.STOPPED, "", sql.ErrNoRows},
+			{"db", models.STOPPED, "ct-1", nil},
+			{"cache", models.STOPPED, "", sql.ErrNoRows},
+		} {
+			if id, err := repo.FindIDByName(tc.name, tc.state); id != tc.want || err != tc.err {
+				t.Errorf("FindIDByName(%s, %s) = %q, %v, want %q, %v", tc.name, tc.state, id, err, tc.want, tc.err)
+			}
+		}
+		for name, want := range map[string]bool{"web": true, "db": true, "cache": false} {
+			if found, err := repo.HasName(name); err != nil || found != want {
+				t.Errorf("HasName(%s) = %v, %v, want %v", name, found, err, want)
+			}
+		}
+
+		if err := repo.SetState("ct-2", models.RUNNING); err != nil {
+			t.Fatal(err)
+		}
+		if state, err := repo.GetState("ct-2"); err != nil || state != models.RUNNING {
+			t.Errorf("got state %s: %v", state, err)
+		}
+
+		// Containers created at the same time are ordered by ID
+		for _, tc := range []struct {
+			limit int
+			want  string
+		}{
+			{1, "ct-1"},
+			{2, "ct-1,ct-2"},
+			{10, "ct-1,ct-2,ct-3"},
+		} {
+			containers, err := repo.List(tc.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := strings.Join(containerIDs(containers), ","); ids != tc.want {
+				t.Errorf("List(%d) = %v, want %v", tc.limit, ids, tc.want)
+			}
+		}
+		if containers, err := repo.ListAll(); err != nil || len(containers) != 3 || containers[2].ID != "ct-3" {
+			t.Errorf("got containers %v: %v", containerIDs(containers), err)
+		}
+
+		if err := repo.Delete("ct-2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.FindByID("ct-2"); err != sql.ErrNoRows {
+			t.Errorf("got a deleted container: %v", err)
+		}
+		if found, err := repo.HasName("web"); err != nil || found {
+			t.Errorf("a name of a deleted container is taken: %v", err)
+		}
+	})
+}
+
+// A name of a container being created is reserved by its pending job, so that no other job reserves it
+func TestContainerNameReservation(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+
+		createJob(t, repo, "job-1", JobOptions{ReservedName: "web"})
+		for _, tc := range []struct {
+			id   string
+			name string
+			ok   bool
+		}{
+			{"job-2", "web", false},
+			{"job-3", "db", true},
+		} {
+			job := &models.Job{ID: tc.id, Type: "add-container", Status: models.PENDING, Payload: []byte(`{}`), MaxAttempts: 1}
+			if err := repo.Create(job, JobOptions{ReservedName: tc.name}); (err == nil) != tc.ok {
+				t.Errorf("Create(%s) reserving %s = %v, want ok %v", tc.id, tc.name, err, tc.ok)
+			}
+		}
+
+		job := claimJob(t, repo, "claim-1", now)
+		if job == nil || job.ID != "job-1" {
+			t.Fatalf("got %+v, want job-1", job)
+		}
+		if ok, err := repo.MarkDone(job, "container", "ct-1"); err != nil || !ok {
+			t.Fatalf("job-1 is not done: %v", err)
+		}
+
+		// A name is free again once its job is done
+		if reserved, err := repo.IsNameReserved("web"); err != nil || reserved {
+			t.Errorf("a name of a done job is reserved: %v", err)
+		}
+		createJob(t, repo, "job-4", JobOptions{ReservedName: "web"})
+		if reserved, err := repo.IsNameReserved("web"); err != nil || !reserved {
+			t.Errorf("a name of a pending job is not reserved: %v", err)
+		}
+	})
+}
+
+func containerIDs(containers []*models.Container) []string {
+	ids := make([]string, 0, len(containers))
+	for _, container := range containers {
+		ids = append(ids, container.ID)
+	}
+
+	return ids
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+const jobColumns = "id, type, status, payload, entity_type, entity_id, attempts, max_attempts, last_error, next_run_at, locked_by, dry_run, created_at"
+
+type SQLJobRepository struct {
+	sqlRepository
+}
+
+func NewJobRepository(db *sqlx.DB) *SQLJobRepository {
+	return &SQLJobRepository{sqlRepository{db: db}}
+}
+
+func (r *SQLJobRepository) Create(job *models.Job, opts JobOptions) error {
+	_, err := r.db.Exec(r.q("INSERT INTO jobs (id, status, payload, type, entity_type, entity_id, attempts, max_attempts, last_error, dry_run, idempotency_key, request_hash, reserved_name, host) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		job.ID, job.Status, r.json(job.Payload), job.Type, job.EntityType, job.EntityID, job.Attempts, job.MaxAttempts, job.LastError, job.DryRun,
+		nullString(opts.IdempotencyKey), nullString(opts.RequestHash), nullString(opts.ReservedName), opts.Host)
+
+	return err
+}
+
+func (r *SQLJobRepository) FindByID(id string) (*models.Job, error) {
+	var job models.Job
+
+	err := r.db.Get(&job, r.q("SELECT "+jobColumns+" FROM jobs WHERE id=? LIMIT 1"), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (r *SQLJobRepository) FindByIdempotencyKey(key string) (string, string, error) {
+	var job struct {
+		ID          string `db:"id"`
+		RequestHash string `db:"request_hash"`
+	}
+	err := r.db.Get(&job, r.q("SELECT id, request_hash FROM jobs WHERE idempotency_key=?"), key)
+
+	return job.ID, job.RequestHash, err
+}
+
+func (r *SQLJobRepository) IsNameReserved(name string) (bool, error) {
+	var n int
+	err := r.db.Get(&n, r.q("SELECT COUNT(*) FROM jobs WHERE reserved_name=? AND status=?"), name, models.PENDING)
+
+	return n > 0, err
+}
+
+func (r *SQLJobRepository) List(filter JobFilter) ([]*models.Job, error) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.Status != nil {
+		conditions = append(conditions, "status=?")
+		args = append(args, *filter.Status)
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "type=?")
+		args = append(args, filter.Type)
+	}
+	if filter.EntityID != "" {
+		conditions = append(conditions, "entity_id=?")
+		args = append(args, filter.EntityID)
+	}
+	if filter.CreatedAfter != nil {
+		conditions = append(conditions, "created_at>?")
+		args = append(args, filter.CreatedAfter.UTC())
+	}
+
+	query := "SELECT " + jobColumns + " FROM jobs"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at, id LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	jobs := make([]*models.Job, 0)
+	err := r.db.Select(&jobs, r.q(query), args...)
+
+	return jobs, err
+}
+
+// Claim atomically picks the oldest due pending job of a host and leases it, so that concurrent
+// workers, including ones of other processes sharing a database, never pick the same job.
+// Every claim gets a new lockedBy token, so a worker whose lease was reaped cannot
+// update the job anymore.
+func (r *SQLJobRepository) Claim(host, lockedBy string, now time.Time, maxRunning int) (*models.Job, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE jobs SET locked_at=?, heartbeat_at=?, locked_by=?, attempts=attempts+1
+		WHERE id=(SELECT id FROM jobs WHERE host=? AND status=? AND locked_at IS NULL AND (next_run_at IS NULL OR next_run_at<=?) ORDER BY created_at, id LIMIT 1` + r.skipLocked() + `)
+		AND locked_at IS NULL`
+	args := []interface{}{now, now, lockedBy, host, models.PENDING, now}
+	if maxRunning > 0 {
+		// Claims of a host wait for each other, so that each one counts jobs leased by the others.
+		// SQLite locks a whole database on writes, so it needs no lock.
+		if r.db.DriverName() == PostgresDriver {
+			if _, err := tx.Exec(r.q("SELECT pg_advisory_xact_lock(hashtext(?))"), "jobs:"+host); err != nil {
+				return nil, err
+			}
+		}
+		query += " AND (SELECT COUNT(*) FROM jobs WHERE host=? AND locked_at IS NOT NULL) < ?"
+		args = append(args, host, maxRunning)
+	}
+
+	var job models.Job
+	err = tx.Get(&job, r.q(query+" RETURNING "+jobColumns), args...)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	return &job, tx.Commit()
+}
+
+func (r *SQLJobRepository) Adopt(host string) (int64, error) {
+	res, err := r.db.Exec(r.q("UPDATE jobs SET host=? WHERE host=? AND status=?"), host, "", models.PENDING)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+func (r *SQLJobRepository) Heartbeat(job *models.Job, at time.Time) (bool, error) {
+	return r.exec("UPDATE jobs SET heartbeat_at=? WHERE id=? AND locked_by=?", at, job.ID, job.LockedBy)
+}
+
+func (r *SQLJobRepository) IsCancelRequested(id string) (bool, error) {
+	var cancelRequested bool
+	err := r.db.Get(&cancelRequested, r.q("SELECT cancel_requested FROM jobs WHERE id=?"), id)
+
+	return cancelRequested, err
+}
+
+func (r *SQLJobRepository) CancelPending(id string) (bool, error) {
+	return r.exec("UPDATE jobs SET status=? WHERE id=? AND status=? AND locked_at IS NULL", models.CANCELLED, id, models.PENDING)
+}
+
+func (r *SQLJobRepository) RequestCancel(id string) (bool, error) {
+	return r.exec("UPDATE jobs SET cancel_requested=? WHERE id=? AND status=? AND locked_at IS NOT NULL", true, id, models.PENDING)
+}
+
+func (r *SQLJobRepository) MarkCancelled(job *models.Job) (bool, error) {
+	return r.exec("UPDATE jobs SET status=?, locked_at=NULL, locked_by=NULL WHERE id=? AND locked_by=?", models.CANCELLED, job.ID, job.LockedBy)
+}
+
+func (r *SQLJobRepository) MarkFailed(job *models.Job, lastError string) (bool, error) {
+	return r.exec("UPDATE jobs SET status=?, last_error=?, locked_at=NULL, locked_by=NULL WHERE id=? AND locked_by=?", models.FAILED, lastError, job.ID, job.LockedBy)
+}
+
+func (r *SQLJobRepository) MarkDone(job *models.Job, entityType, entityID string) (bool, error) {
+	return r.exec("UPDATE jobs SET status=?, locked_at=NULL, locked_by=NULL, entity_type=?, entity_id=? WHERE id=? AND locked_by=?",
+		models.DONE, entityType, nullString(entityID), job.ID, job.LockedBy)
+}
+
+func (r *SQLJobRepository) ScheduleRetry(job *models.Job, lastError string, nextRunAt time.Time) (bool, error) {
+	return r.exec("UPDATE jobs SET last_error=?, next_run_at=?, locked_at=NULL, locked_by=NULL WHERE id=? AND locked_by=?", lastError, nextRunAt, job.ID, job.LockedBy)
+}
+
+func (r *SQLJobRepository) ReapExpired(expiredAt time.Time, lastError string) ([]*models.Job, int64, error) {
+	failed := make([]*models.Job, 0)
+	err := r.db.Select(&failed, r.q("UPDATE jobs SET status=?, last_error=?, locked_at=NULL, locked_by=NULL WHERE locked_at IS NOT NULL AND heartbeat_at<? AND attempts>=max_attempts RETURNING "+jobColumns),
+		models.FAILED, lastError, expiredAt)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	res, err := r.db.Exec(r.q("UPDATE jobs SET locked_at=NULL, locked_by=NULL WHERE locked_at IS NOT NULL AND heartbeat_at<? AND attempts<max_attempts"), expiredAt)
+	if err != nil {
+		return failed, 0, err
+	}
+	requeued, _ := res.RowsAffected()
+
+	return failed, requeued, nil
+}
+
+func (r *SQLJobRepository) AddLog(jobID, command string, startedAt time.Time) (int64, error) {
+	var id int64
+	err := r.db.Get(&id, r.q("INSERT INTO job_logs (job_id, command, stdout, stderr, started_at) VALUES (?, ?, '', '', ?) RETURNING id"), jobID, command, startedAt)
+
+	return id, err
+}
+
+func (r *SQLJobRepository) SetLogOutput(id int64, stream, output string) error {
+	if stream != "stdout" && stream != "stderr" {
+		return fmt.Errorf("unknown stream %s", stream)
+	}
+
+	_, err := r.db.Exec(r.q("UPDATE job_logs SET "+stream+"=? WHERE id=?"), output, id)
+
+	return err
+}
+
+func (r *SQLJobRepository) FinishLog(id int64, exitCode int, stdout, stderr string, finishedAt time.Time) error {
+	_, err := r.db.Exec(r.q("UPDATE job_logs SET exit_code=?, stdout=?, stderr=?, finished_at=? WHERE id=?"), exitCode, stdout, stderr, finishedAt, id)
+	return err
+}
+
+func (r *SQLJobRepository) ListLogs(jobID string) ([]*models.JobLog, error) {
+	logs := make([]*models.JobLog, 0)
+	err := r.db.Select(&logs, r.q("SELECT id, job_id, command, exit_code, stdout, stderr, started_at, finished_at FROM job_logs WHERE job_id=? ORDER BY id"), jobID)
+
+	return logs, err
+}
+
+// exec runs a query and reports whether it affected any rows
+func (r *SQLJobRepository) exec(query string, args ...interface{}) (bool, error) {
+	res, err := r.db.Exec(r.q(query), args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
@@ -0,0 +1,427 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func createJob(t *testing.T, repo *SQLJobRepository, id string, opts JobOptions) *models.Job {
+	t.Helper()
+
+	job := &models.Job{
+		ID:          id,
+		Type:        "start-container",
+		Status:      models.PENDING,
+		Payload:     []byte(`{"id":"ct-1"}`),
+		EntityType:  sql.NullString{String: "container", Valid: true},
+		EntityID:    sql.NullString{String: "ct-1", Valid: true},
+		MaxAttempts: 2,
+	}
+	if err := repo.Create(job, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	return job
+}
+
+func claimJob(t *testing.T, repo *SQLJobRepository, lockedBy string, now time.Time) *models.Job {
+	t.Helper()
+
+	job, err := repo.Claim("", lockedBy, now, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return job
+}
+
+func TestJobCreate(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		createJob(t, repo, "job-1", JobOptions{IdempotencyKey: "key-1", RequestHash: "hash-1", ReservedName: "ct1"})
+
+		job, err := repo.FindByID("job-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Type != "start-container" || job.Status != models.PENDING || string(job.Payload) != `{"id":"ct-1"}` ||
+			job.Attempts != 0 || job.MaxAttempts != 2 {
+			t.Errorf("got job %+v", job)
+		}
+
+		if _, err := repo.FindByID("job-2"); err != sql.ErrNoRows {
+			t.Errorf("got %v for an unknown job, want sql.ErrNoRows", err)
+		}
+
+		id, hash, err := repo.FindByIdempotencyKey("key-1")
+		if err != nil || id != "job-1" || hash != "hash-1" {
+			t.Errorf("got job %q with hash %q by idempotency key: %v", id, hash, err)
+		}
+		if err := repo.Create(&models.Job{ID: "job-2", Payload: []byte("{}")}, JobOptions{IdempotencyKey: "key-1"}); err == nil {
+			t.Error("an idempotency key is used twice")
+		}
+
+		reserved, err := repo.IsNameReserved("ct1")
+		if err != nil || !reserved {
+			t.Errorf("a name of a pending job is not reserved: %v", err)
+		}
+	})
+}
+
+func TestJobClaim(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		createJob(t, repo, "job-1", JobOptions{})
+		createJob(t, repo, "job-2", JobOptions{})
+		createJob(t, repo, "job-3", JobOptions{})
+
+		job, err := repo.Claim("", "claim-1", now, 1)
+		if err != nil || job == nil || job.ID != "job-1" {
+			t.Fatalf("got %+v, want the oldest job: %v", job, err)
+		}
+		if job.Attempts != 1 || job.LockedBy.String != "claim-1" {
+			t.Errorf("got %d attempts by %q", job.Attempts, job.LockedBy.String)
+		}
+
+		job, err = repo.Claim("", "claim-2", now, 1)
+		if err != nil || job != nil {
+			t.Fatalf("got %+v beyond the limit of running jobs: %v", job, err)
+		}
+
+		job = claimJob(t, repo, "claim-2", now)
+		if job == nil || job.ID != "job-2" {
+			t.Fatalf("got %+v, want the next job", job)
+		}
+
+		// A job scheduled for a retry is not claimed till it is due
+		if _, err := repo.ScheduleRetry(job, "failed", now.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if job = claimJob(t, repo, "claim-3", now); job == nil || job.ID != "job-3" {
+			t.Fatalf("got %+v, want a due job", job)
+		}
+		if job = claimJob(t, repo, "claim-4", now); job != nil {
+			t.Fatalf("got %+v before it is due", job)
+		}
+		if job = claimJob(t, repo, "claim-4", now.Add(time.Minute)); job == nil || job.ID != "job-2" || job.Attempts != 2 {
+			t.Fatalf("got %+v, want a retried job", job)
+		}
+		if job.LastError.String != "failed" {
+			t.Errorf("got last error %q", job.LastError.String)
+		}
+	})
+}
+
+func TestJobClaimOfHost(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		createJob(t, repo, "job-1", JobOptions{Host: "host-1"})
+		createJob(t, repo, "job-2", JobOptions{Host: "host-2"})
+		createJob(t, repo, "job-3", JobOptions{Host: "host-2"})
+
+		job, err := repo.Claim("host-2", "claim-1", now, 1)
+		if err != nil || job == nil || job.ID != "job-2" {
+			t.Fatalf("got %+v, want the oldest job of the host: %v", job, err)
+		}
+		if job, err = repo.Claim("host-2", "claim-2", now, 1); err != nil || job != nil {
+			t.Fatalf("got %+v beyond the limit of jobs running on the host: %v", job, err)
+		}
+
+		// Jobs running on another host do not count
+		if job, err = repo.Claim("host-1", "claim-3", now, 1); err != nil || job == nil || job.ID != "job-1" {
+			t.Fatalf("got %+v, want the job of another host: %v", job, err)
+		}
+		if job, err = repo.Claim("host-3", "claim-4", now, 0); err != nil || job != nil {
+			t.Fatalf("got %+v of another host: %v", job, err)
+		}
+	})
+}
+
+func TestJobAdopt(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		createJob(t, repo, "job-1", JobOptions{})
+		createJob(t, repo, "job-2", JobOptions{Host: "host-2"})
+
+		if job, err := repo.Claim("host-1", "claim-1", now, 0); err != nil || job != nil {
+			t.Fatalf("got %+v without a host before it is adopted: %v", job, err)
+		}
+
+		n, err := repo.Adopt("host-1")
+		if err != nil || n != 1 {
+			t.Fatalf("Adopt() = %d, %v, want 1 job", n, err)
+		}
+		job, err := repo.Claim("host-1", "claim-1", now, 0)
+		if err != nil || job == nil || job.ID != "job-1" {
+			t.Fatalf("got %+v, want the adopted job: %v", job, err)
+		}
+		if job, err = repo.Claim("host-1", "claim-2", now, 0); err != nil || job != nil {
+			t.Fatalf("got %+v of another host: %v", job, err)
+		}
+	})
+}
+
+func TestConcurrentClaimsRespectLimit(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+
+		const jobs, workers, limit = 10, 8, 3
+		for i := 0; i < jobs; i++ {
+			createJob(t, repo, fmt.Sprintf("job-%d", i), JobOptions{})
+		}
+
+		claims := make(chan *models.Job, workers)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				job, err := repo.Claim("", fmt.Sprintf("claim-%d", i), now, limit)
+				if err != nil {
+					t.Error(err)
+				}
+				claims <- job
+			}(i)
+		}
+		wg.Wait()
+		close(claims)
+
+		claimed := make(map[string]bool)
+		for job := range claims {
+			if job != nil {
+				claimed[job.ID] = true
+			}
+		}
+		if len(claimed) != limit {
+			t.Errorf("got %d distinct claimed jobs, want %d", len(claimed), limit)
+		}
+	})
+}
+
+func TestJobScheduleRetry(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		createJob(t, repo, "job-1", JobOptions{})
+		job := claimJob(t, repo, "claim-1", now)
+
+		// Only the holder of a claim schedules its retry
+		lost := *job
+		lost.LockedBy = sql.NullString{String: "claim-2", Valid: true}
+		if updated, err := repo.ScheduleRetry(&lost, "lost", now.Add(time.Minute)); err != nil || updated {
+			t.Fatalf("a retry is scheduled by a lost claim: %v", err)
+		}
+		if updated, err := repo.ScheduleRetry(job, "failed", now.Add(time.Minute)); err != nil || !updated {
+			t.Fatalf("a retry is not scheduled: %v", err)
+		}
+
+		job, err := repo.FindByID("job-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != models.PENDING || job.Attempts != 1 || job.LastError.String != "failed" || job.LockedBy.Valid ||
+			!job.NextRunAt.Valid || !job.NextRunAt.Time.Equal(now.Add(time.Minute)) {
+			t.Errorf("got job %+v scheduled for a retry", job)
+		}
+	})
+}
+
+func TestJobLeaseOwnership(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		createJob(t, repo, "job-1", JobOptions{})
+		job := claimJob(t, repo, "claim-1", now)
+
+		owned, err := repo.Heartbeat(job, now.Add(time.Second))
+		if err != nil || !owned {
+			t.Fatalf("a lease is not extended: %v", err)
+		}
+
+		stale := *job
+		stale.LockedBy = sql.NullString{String: "claim-0", Valid: true}
+		if owned, err = repo.Heartbeat(&stale, now); err != nil || owned {
+			t.Errorf("a lease of another claim is extended: %v", err)
+		}
+		if updated, err := repo.MarkDone(&stale, "container", "ct-2"); err != nil || updated {
+			t.Errorf("a job of another claim is completed: %v", err)
+		}
+
+		if updated, err := repo.MarkDone(job, "container", "ct-2"); err != nil || !updated {
+			t.Fatalf("a job is not completed: %v", err)
+		}
+		job, err = repo.FindByID("job-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != models.DONE || job.EntityID.String != "ct-2" || job.LockedBy.Valid {
+			t.Errorf("got job %+v", job)
+		}
+		if updated, err := repo.MarkFailed(job, "failed"); err != nil || updated {
+			t.Errorf("a released job is failed: %v", err)
+		}
+	})
+}
+
+func TestJobReapExpired(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		createJob(t, repo, "job-1", JobOptions{})
+		createJob(t, repo, "job-2", JobOptions{})
+		createJob(t, repo, "job-3", JobOptions{})
+
+		// job-1 is on its last attempt, job-2 may be retried, job-3 keeps sending heartbeats
+		first := claimJob(t, repo, "claim-1", now)
+		if _, err := repo.ScheduleRetry(first, "failed", now); err != nil {
+			t.Fatal(err)
+		}
+		claimJob(t, repo, "claim-2", now)
+		claimJob(t, repo, "claim-3", now)
+		last := claimJob(t, repo, "claim-4", now)
+		if _, err := repo.Heartbeat(last, now.Add(2*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+
+		failed, requeued, err := repo.ReapExpired(now.Add(time.Minute), "lease expired")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(failed) != 1 || failed[0].ID != "job-1" || failed[0].Status != models.FAILED || failed[0].LastError.String != "lease expired" {
+			t.Errorf("got failed jobs %+v", failed)
+		}
+		if requeued != 1 {
+			t.Errorf("got %d requeued jobs, want 1", requeued)
+		}
+
+		job := claimJob(t, repo, "claim-5", now.Add(time.Minute))
+		if job == nil || job.ID != "job-2" {
+			t.Errorf("got %+v, want a requeued job", job)
+		}
+	})
+}
+
+func TestJobList(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		createdAt := testTime("2021-03-01T10:00:00Z")
+		for _, id := range []string{"job-1", "job-2", "job-3", "job-4"} {
+			createJob(t, repo, id, JobOptions{})
+			createdAt = createdAt.Add(time.Second)
+			db.MustExec(db.Rebind("UPDATE jobs SET entity_id=?, created_at=? WHERE id=?"), "ct-1", createdAt, id)
+		}
+		createJob(t, repo, "job-5", JobOptions{})
+		db.MustExec(db.Rebind("UPDATE jobs SET status=?, entity_id=? WHERE id=?"), models.DONE, "ct-2", "job-5")
+
+		pending := models.JobStatus(models.PENDING)
+		var ids []string
+		for offset := 0; offset < 4; offset += 2 {
+			jobs, err := repo.List(JobFilter{Status: &pending, EntityID: "ct-1", Limit: 2, Offset: offset})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, job := range jobs {
+				ids = append(ids, job.ID)
+			}
+		}
+		if len(ids) != 4 || ids[0] != "job-1" || ids[1] != "job-2" || ids[2] != "job-3" || ids[3] != "job-4" {
+			t.Errorf("got pages of jobs %v", ids)
+		}
+
+		jobs, err := repo.List(JobFilter{EntityID: "ct-2", Type: "start-container", Limit: 10})
+		if err != nil || len(jobs) != 1 || jobs[0].ID != "job-5" {
+			t.Errorf("got jobs %v of an entity: %v", jobs, err)
+		}
+	})
+}
+
+func TestJobCancel(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		createJob(t, repo, "job-1", JobOptions{})
+		createJob(t, repo, "job-2", JobOptions{})
+		job := claimJob(t, repo, "claim-1", now)
+
+		if cancelled, err := repo.CancelPending(job.ID); err != nil || cancelled {
+			t.Errorf("a running job is cancelled as a pending one: %v", err)
+		}
+		if requested, err := repo.RequestCancel(job.ID); err != nil || !requested {
+			t.Errorf("cancellation of a running job is not requested: %v", err)
+		}
+		if requested, err := repo.IsCancelRequested(job.ID); err != nil || !requested {
+			t.Errorf("cancellation of a running job is not found: %v", err)
+		}
+		if updated, err := repo.MarkCancelled(job); err != nil || !updated {
+			t.Errorf("a running job is not cancelled: %v", err)
+		}
+
+		if requested, err := repo.RequestCancel("job-2"); err != nil || requested {
+			t.Errorf("cancellation of a pending job is requested: %v", err)
+		}
+		if cancelled, err := repo.CancelPending("job-2"); err != nil || !cancelled {
+			t.Errorf("a pending job is not cancelled: %v", err)
+		}
+		if job := claimJob(t, repo, "claim-2", now); job != nil {
+			t.Errorf("got a cancelled job %+v", job)
+		}
+	})
+}
+
+func TestJobLogs(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewJobRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		createJob(t, repo, "job-1", JobOptions{})
+
+		id, err := repo.AddLog("job-1", "vzctl start 101", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.SetLogOutput(id, "stdout", "Starting"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.SetLogOutput(id, "stdin", "x"); err == nil {
+			t.Error("an unknown stream is set")
+		}
+
+		logs, err := repo.ListLogs("job-1")
+		if err != nil || len(logs) != 1 || logs[0].Stdout != "Starting" || logs[0].ExitCode.Valid {
+			t.Fatalf("got logs %+v of a running command: %v", logs, err)
+		}
+
+		if err := repo.FinishLog(id, 0, "Starting\nStarted\n", "", now.Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		logs, err = repo.ListLogs("job-1")
+		if err != nil || logs[0].Stdout != "Starting\nStarted\n" || !logs[0].ExitCode.Valid || !logs[0].FinishedAt.Valid {
+			t.Errorf("got logs %+v of a finished command: %v", logs, err)
+		}
+	})
+}
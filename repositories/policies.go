@@ -0,0 +1,135 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+const policyColumns = "id, name, kind, schedule, keep_last, keep_daily, keep_weekly, keep_monthly, next_run_at, created_at"
+
+type SQLPolicyRepository struct {
+	sqlRepository
+}
+
+func NewPolicyRepository(db *sqlx.DB) *SQLPolicyRepository {
+	return &SQLPolicyRepository{sqlRepository{db: db}}
+}
+
+func (r *SQLPolicyRepository) Create(policy *models.Policy) error {
+	_, err := r.db.Exec(r.q("INSERT INTO policies ("+policyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		policy.ID, policy.Name, policy.Kind, policy.Schedule, policy.KeepLast, policy.KeepDaily, policy.KeepWeekly, policy.KeepMonthly,
+		policy.NextRunAt, policy.CreatedAt)
+
+	return err
+}
+
+func (r *SQLPolicyRepository) FindByID(id string) (*models.Policy, error) {
+	var policy models.Policy
+
+	err := r.db.Get(&policy, r.q("SELECT "+policyColumns+" FROM policies WHERE id=? LIMIT 1"), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+func (r *SQLPolicyRepository) List() ([]*models.Policy, error) {
+	policies := make([]*models.Policy, 0)
+	err := r.db.Select(&policies, r.q("SELECT "+policyColumns+" FROM policies ORDER BY created_at"))
+
+	return policies, err
+}
+
+func (r *SQLPolicyRepository) ListDue(now time.Time) ([]*models.Policy, error) {
+	policies := make([]*models.Policy, 0)
+	err := r.db.Select(&policies, r.q("SELECT "+policyColumns+" FROM policies WHERE next_run_at<=? ORDER BY next_run_at"), now)
+
+	return policies, err
+}
+
+func (r *SQLPolicyRepository) Reschedule(id string, now, next time.Time) (bool, error) {
+	res, err := r.db.Exec(r.q("UPDATE policies SET next_run_at=? WHERE id=? AND next_run_at<=?"), next, id, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+func (r *SQLPolicyRepository) Delete(id string) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(r.q("DELETE FROM policy_containers WHERE policy_id=?"), id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(r.q("DELETE FROM policies WHERE id=?"), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLPolicyRepository) Attach(policyID, containerID string) error {
+	var n int
+	err := r.db.Get(&n, r.q("SELECT COUNT(*) FROM policy_containers WHERE policy_id=? AND container_id=?"), policyID, containerID)
+	if err != nil || n > 0 {
+		return err
+	}
+
+	_, err = r.db.Exec(r.q("INSERT INTO policy_containers (policy_id, container_id, created_at) VALUES (?, ?, ?)"),
+		policyID, containerID, time.Now().UTC())
+
+	return err
+}
+
+func (r *SQLPolicyRepository) Detach(policyID, containerID string) error {
+	res, err := r.db.Exec(r.q("DELETE FROM policy_containers WHERE policy_id=? AND container_id=?"), policyID, containerID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return err
+}
+
+func (r *SQLPolicyRepository) DetachContainer(containerID string) error {
+	_, err := r.db.Exec(r.q("DELETE FROM policy_containers WHERE container_id=?"), containerID)
+	return err
+}
+
+func (r *SQLPolicyRepository) ContainerIDs(policyID string) ([]string, error) {
+	ids := make([]string, 0)
+	err := r.db.Select(&ids, r.q("SELECT container_id FROM policy_containers WHERE policy_id=? ORDER BY created_at"), policyID)
+
+	return ids, err
+}
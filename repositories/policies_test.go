@@ -0,0 +1,117 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func TestPolicyRepository(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewPolicyRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+
+		for _, policy := range []*models.Policy{
+			{ID: "policy-1", Name: "nightly", Kind: models.PolicyBackup, Schedule: "0 3 * * *", KeepDaily: 7, NextRunAt: sql.NullTime{Time: now, Valid: true}},
+			{ID: "policy-2", Name: "hourly", Kind: models.PolicySnapshot, Schedule: "@hourly", KeepLast: 3, NextRunAt: sql.NullTime{Time: now.Add(time.Hour), Valid: true}},
+		} {
+			policy.CreatedAt = now.Add(-time.Hour)
+			if err := repo.Create(policy); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		policy, err := repo.FindByID("policy-1")
+		if err != nil || policy.Name != "nightly" || policy.KeepDaily != 7 || !policy.NextRunAt.Time.Equal(now) {
+			t.Errorf("got policy %+v: %v", policy, err)
+		}
+		if policies, err := repo.List(); err != nil || len(policies) != 2 {
+			t.Errorf("got policies %v: %v", policies, err)
+		}
+
+		due, err := repo.ListDue(now)
+		if err != nil || len(due) != 1 || due[0].ID != "policy-1" {
+			t.Fatalf("got due policies %v: %v", due, err)
+		}
+		if ok, err := repo.Reschedule("policy-1", now, now.Add(24*time.Hour)); err != nil || !ok {
+			t.Errorf("a due policy is not rescheduled: %v", err)
+		}
+		if ok, err := repo.Reschedule("policy-1", now, now.Add(24*time.Hour)); err != nil || ok {
+			t.Errorf("a run of a policy is claimed twice: %v", err)
+		}
+		if due, err = repo.ListDue(now); err != nil || len(due) != 0 {
+			t.Errorf("got due policies %v: %v", due, err)
+		}
+	})
+}
+
+func TestPolicyAttachments(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewPolicyRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		for _, id := range []string{"policy-1", "policy-2"} {
+			err := repo.Create(&models.Policy{ID: id, Name: id, Kind: models.PolicyBackup, Schedule: "@daily", CreatedAt: now})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, attachment := range [][2]string{{"policy-1", "ct-1"}, {"policy-1", "ct-1"}, {"policy-1", "ct-2"}, {"policy-2", "ct-1"}} {
+			if err := repo.Attach(attachment[0], attachment[1]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids, err := repo.ContainerIDs("policy-1")
+		if err != nil || len(ids) != 2 {
+			t.Errorf("got containers %v of a policy: %v", ids, err)
+		}
+
+		if err := repo.Detach("policy-1", "ct-2"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Detach("policy-1", "ct-2"); err != sql.ErrNoRows {
+			t.Errorf("got %v detaching a detached policy, want sql.ErrNoRows", err)
+		}
+
+		if err := repo.DetachContainer("ct-1"); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{"policy-1", "policy-2"} {
+			if ids, err := repo.ContainerIDs(id); err != nil || len(ids) != 0 {
+				t.Errorf("got containers %v of %s: %v", ids, id, err)
+			}
+		}
+
+		if err := repo.Attach("policy-2", "ct-3"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Delete("policy-2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.FindByID("policy-2"); err != sql.ErrNoRows {
+			t.Errorf("got %v for a deleted policy, want sql.ErrNoRows", err)
+		}
+		if ids, err := repo.ContainerIDs("policy-2"); err != nil || len(ids) != 0 {
+			t.Errorf("got containers %v of a deleted policy: %v", ids, err)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+//go:build postgres
+// +build postgres
+
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/romiras/go-openvz-api/internal/pgtest"
+)
+
+// TestMain starts a throwaway PostgreSQL database for tests unless one is given
+func TestMain(m *testing.M) {
+	stop := func() {}
+	if testPostgresDSN == "" {
+		dsn, stopPostgres, err := pgtest.Start()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		testPostgresDSN, stop = dsn, stopPostgres
+	}
+
+	code := m.Run()
+	stop()
+	os.Exit(code)
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+const (
+	SQLiteDriver   = "sqlite3"
+	PostgresDriver = "postgres"
+)
+
+// Drivers lists supported database drivers
+var Drivers = []string{SQLiteDriver, PostgresDriver}
+
+type (
+	// ContainerRepository stores containers. Finders return sql.ErrNoRows when nothing is found.
+	ContainerRepository interface {
+		FindByID(id string) (*models.Container, error)
+		FindIDByName(name string, state models.ContainerState) (string, error)
+		HasName(name string) (bool, error)
+		List(limit int) ([]*models.Container, error)
+		ListAll() ([]*models.Container, error)
+		Create(container *models.Container) error
+		GetState(id string) (models.ContainerState, error)
+		SetState(id string, state models.ContainerState) error
+		SetParameters(id string, parameters map[string]string) error
+		Delete(id string) error
+	}
+
+	// JobRepository stores jobs and logs of their commands.
+	// Updates of a claimed job apply only while it is leased by the same claim.
+	JobRepository interface {
+		Create(job *models.Job, opts JobOptions) error
+		FindByID(id string) (*models.Job, error)
+		FindByIdempotencyKey(key string) (jobID, requestHash string, err error)
+		IsNameReserved(name string) (bool, error)
+		List(filter JobFilter) ([]*models.Job, error)
+		// Claim leases the oldest due pending job of a host unless maxRunning jobs of the host
+		// are leased already, a maxRunning of 0 means no limit
+		Claim(host, lockedBy string, now time.Time, maxRunning int) (*models.Job, error)
+		// Adopt moves pending jobs having no host, e.g. ones enqueued before jobs had hosts, to a host
+		Adopt(host string) (int64, error)
+		// Heartbeat extends a lease of a job and reports whether it is still held by the job's claim
+		Heartbeat(job *models.Job, at time.Time) (bool, error)
+		IsCancelRequested(id string) (bool, error)
+		// CancelPending cancels a job which is not leased and reports whether it did
+		CancelPending(id string) (bool, error)
+		// RequestCancel flags a leased job for cancellation and reports whether it did
+		RequestCancel(id string) (bool, error)
+		// MarkCancelled, MarkFailed, MarkDone and ScheduleRetry complete a job held by its claim,
+		// and report whether it was still held
+		MarkCancelled(job *models.Job) (bool, error)
+		MarkFailed(job *models.Job, lastError string) (bool, error)
+		MarkDone(job *models.Job, entityType, entityID string) (bool, error)
+		ScheduleRetry(job *models.Job, lastError string, nextRunAt time.Time) (bool, error)
+		// ReapExpired releases jobs without heartbeats since expiredAt, failing and returning ones out of attempts
+		ReapExpired(expiredAt time.Time, lastError string) (failed []*models.Job, requeued int64, err error)
+
+		AddLog(jobID, command string, startedAt time.Time) (int64, error)
+		SetLogOutput(id int64, stream, output string) error
+		FinishLog(id int64, exitCode int, stdout, stderr string, finishedAt time.Time) error
+		ListLogs(jobID string) ([]*models.JobLog, error)
+	}
+
+	// WebhookRepository stores webhooks and their deliveries
+	WebhookRepository interface {
+		Create(webhook *models.Webhook) error
+		FindByID(id string) (*models.Webhook, error)
+		List() ([]*models.Webhook, error)
+		Delete(id string) error
+
+		// AddDeliveries adds all deliveries of an event or none of them
+		AddDeliveries(deliveries []*models.WebhookDelivery) error
+		ListDeliveries(webhookID string, limit int) ([]*models.WebhookDelivery, error)
+		// ClaimDelivery picks the earliest due pending delivery and postpones it till claimedUntil
+		ClaimDelivery(now, claimedUntil time.Time) (*models.WebhookDelivery, error)
+		MarkDelivered(id string, responseCode sql.NullInt64, deliveredAt time.Time) error
+		MarkDeliveryFailed(id string, responseCode sql.NullInt64, lastError string) error
+		ScheduleDelivery(id string, responseCode sql.NullInt64, lastError string, nextRunAt time.Time) error
+	}
+
+	// SnapshotRepository stores metadata of snapshots of containers
+	SnapshotRepository interface {
+		Create(snapshot *models.Snapshot) error
+		FindByID(containerID, id string) (*models.Snapshot, error)
+		List(containerID string) ([]*models.Snapshot, error)
+		// ListByPolicy returns snapshots taken by a policy, of any containers
+		ListByPolicy(policyID string) ([]*models.Snapshot, error)
+		// Transition changes a state of a snapshot if it is one of from states, and reports whether it did
+		Transition(id string, to string, from ...string) (bool, error)
+		SetState(id, state string) error
+		MarkReady(id, hostID string) error
+		Delete(id string) error
+		DeleteByContainer(containerID string) error
+	}
+
+	// BackupRepository stores metadata of backups of containers
+	BackupRepository interface {
+		Create(backup *models.Backup) error
+		FindByID(id string) (*models.Backup, error)
+		// List returns backups of a container, or all of them if containerID is empty
+		List(containerID string) ([]*models.Backup, error)
+		// ListByPolicy returns backups made by a policy, of any containers
+		ListByPolicy(policyID string) ([]*models.Backup, error)
+		// ListExpired returns backups which are ready or failed and are retained no longer than now
+		ListExpired(now time.Time) ([]*models.Backup, error)
+		// Transition changes a state of a backup if it is one of from states, and reports whether it did
+		Transition(id string, to string, from ...string) (bool, error)
+		SetState(id, state string) error
+		// MarkReady stores where a made backup is kept
+		MarkReady(backup *models.Backup) error
+		Delete(id string) error
+	}
+
+	// PolicyRepository stores policies and containers they are attached to
+	PolicyRepository interface {
+		Create(policy *models.Policy) error
+		FindByID(id string) (*models.Policy, error)
+		List() ([]*models.Policy, error)
+		// ListDue returns policies whose next run is not later than now
+		ListDue(now time.Time) ([]*models.Policy, error)
+		// Reschedule sets a next run of a policy if it is still due at now, and reports whether it did,
+		// so that a run is claimed once
+		Reschedule(id string, now, next time.Time) (bool, error)
+		// Delete deletes a policy and detaches it from containers
+		Delete(id string) error
+		Attach(policyID, containerID string) error
+		// Detach returns sql.ErrNoRows if a policy is not attached to a container
+		Detach(policyID, containerID string) error
+		DetachContainer(containerID string) error
+		ContainerIDs(policyID string) ([]string, error)
+	}
+
+	// TemplateRepository stores OS templates found on a host
+	TemplateRepository interface {
+		List() ([]*models.Template, error)
+		Exists(name string) (bool, error)
+		Count() (int, error)
+		// Sync replaces stored templates with ones found on a host at now
+		Sync(names []string, now time.Time) error
+	}
+
+	// JobOptions are optional attributes of a job
+	JobOptions struct {
+		// IdempotencyKey identifies a request, so that its repetitions return the same job
+		IdempotencyKey string
+		// RequestHash tells requests with the same idempotency key apart
+		RequestHash string
+		// ReservedName is a container name reserved while the job is pending
+		ReservedName string
+		// Host is a host the job runs on, empty when a database serves a single host
+		Host string
+	}
+
+	JobFilter struct {
+		Status       *models.JobStatus
+		Type         string
+		EntityID     string
+		CreatedAfter *time.Time
+		Limit        int
+		Offset       int
+	}
+
+	// sqlRepository runs queries written with ? placeholders in a dialect of a database driver
+	sqlRepository struct {
+		db *sqlx.DB
+	}
+)
+
+func (r sqlRepository) q(query string) string {
+	return r.db.Rebind(query)
+}
+
+// skipLocked makes concurrent claims skip rows locked by each other instead of waiting for them.
+// SQLite locks a whole database on writes, so it needs no row locks.
+func (r sqlRepository) skipLocked() string {
+	if r.db.DriverName() == PostgresDriver {
+		return " FOR UPDATE SKIP LOCKED"
+	}
+
+	return ""
+}
+
+// json returns a parameter of a JSON document stored in a TEXT column. go-sqlite3 stores []byte
+// as a BLOB, which is kept as is, while pq would encode it as bytea, so Postgres gets a string.
+func (r sqlRepository) json(data []byte) interface{} {
+	if r.db.DriverName() == PostgresDriver {
+		return string(data)
+	}
+
+	return data
+}
+
+func nullString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+
+	return s
+}
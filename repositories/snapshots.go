@@ -0,0 +1,101 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+const snapshotColumns = "id, container_id, name, description, host_id, state, parameters, policy_id, created_at"
+
+type SQLSnapshotRepository struct {
+	sqlRepository
+}
+
+func NewSnapshotRepository(db *sqlx.DB) *SQLSnapshotRepository {
+	return &SQLSnapshotRepository{sqlRepository{db: db}}
+}
+
+func (r *SQLSnapshotRepository) Create(snapshot *models.Snapshot) error {
+	_, err := r.db.Exec(r.q("INSERT INTO snapshots ("+snapshotColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		snapshot.ID, snapshot.ContainerID, snapshot.Name, snapshot.Description, snapshot.HostID, snapshot.State, snapshot.ParametersJSON, snapshot.PolicyID, snapshot.CreatedAt)
+
+	return err
+}
+
+func (r *SQLSnapshotRepository) FindByID(containerID, id string) (*models.Snapshot, error) {
+	var snapshot models.Snapshot
+
+	err := r.db.Get(&snapshot, r.q("SELECT "+snapshotColumns+" FROM snapshots WHERE id=? AND container_id=? LIMIT 1"), id, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
+func (r *SQLSnapshotRepository) List(containerID string) ([]*models.Snapshot, error) {
+	snapshots := make([]*models.Snapshot, 0)
+	err := r.db.Select(&snapshots, r.q("SELECT "+snapshotColumns+" FROM snapshots WHERE container_id=? ORDER BY created_at"), containerID)
+
+	return snapshots, err
+}
+
+func (r *SQLSnapshotRepository) ListByPolicy(policyID string) ([]*models.Snapshot, error) {
+	snapshots := make([]*models.Snapshot, 0)
+	err := r.db.Select(&snapshots, r.q("SELECT "+snapshotColumns+" FROM snapshots WHERE policy_id=? ORDER BY created_at"), policyID)
+
+	return snapshots, err
+}
+
+func (r *SQLSnapshotRepository) Transition(id string, to string, from ...string) (bool, error) {
+	args := []interface{}{to, id}
+	for _, state := range from {
+		args = append(args, state)
+	}
+
+	res, err := r.db.Exec(r.q("UPDATE snapshots SET state=? WHERE id=? AND state IN (?"+strings.Repeat(", ?", len(from)-1)+")"), args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n > 0, err
+}
+
+func (r *SQLSnapshotRepository) SetState(id, state string) error {
+	_, err := r.db.Exec(r.q("UPDATE snapshots SET state=? WHERE id=?"), state, id)
+	return err
+}
+
+func (r *SQLSnapshotRepository) MarkReady(id, hostID string) error {
+	_, err := r.db.Exec(r.q("UPDATE snapshots SET state=?, host_id=? WHERE id=?"), models.SnapshotReady, hostID, id)
+	return err
+}
+
+func (r *SQLSnapshotRepository) Delete(id string) error {
+	_, err := r.db.Exec(r.q("DELETE FROM snapshots WHERE id=?"), id)
+	return err
+}
+
+func (r *SQLSnapshotRepository) DeleteByContainer(containerID string) error {
+	_, err := r.db.Exec(r.q("DELETE FROM snapshots WHERE container_id=?"), containerID)
+	return err
+}
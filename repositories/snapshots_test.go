@@ -0,0 +1,86 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func TestSnapshotRepository(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewSnapshotRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+
+		for i, snapshot := range []*models.Snapshot{
+			{ID: "snapshot-1", ContainerID: "ct-1", Name: "before upgrade", State: models.SnapshotCreating},
+			{ID: "snapshot-2", ContainerID: "ct-1", State: models.SnapshotReady, PolicyID: sql.NullString{String: "policy-1", Valid: true}},
+			{ID: "snapshot-3", ContainerID: "ct-2", State: models.SnapshotReady},
+		} {
+			snapshot.CreatedAt = now.Add(time.Duration(i) * time.Second)
+			if err := repo.Create(snapshot); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		snapshot, err := repo.FindByID("ct-1", "snapshot-1")
+		if err != nil || snapshot.Name != "before upgrade" || snapshot.PolicyID.Valid {
+			t.Errorf("got snapshot %+v: %v", snapshot, err)
+		}
+		if _, err := repo.FindByID("ct-2", "snapshot-1"); err != sql.ErrNoRows {
+			t.Errorf("got %v for a snapshot of another container, want sql.ErrNoRows", err)
+		}
+
+		snapshots, err := repo.List("ct-1")
+		if err != nil || len(snapshots) != 2 || snapshots[0].ID != "snapshot-1" || snapshots[1].PolicyID.String != "policy-1" {
+			t.Errorf("got snapshots %v: %v", snapshots, err)
+		}
+		if snapshots, err = repo.ListByPolicy("policy-1"); err != nil || len(snapshots) != 1 || snapshots[0].ID != "snapshot-2" {
+			t.Errorf("got snapshots %v of a policy: %v", snapshots, err)
+		}
+
+		if err := repo.MarkReady("snapshot-1", "{5c1a}"); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := repo.Transition("snapshot-1", models.SnapshotReverting, models.SnapshotReady); err != nil || !ok {
+			t.Errorf("a snapshot is not moved: %v", err)
+		}
+		if ok, err := repo.Transition("snapshot-1", models.SnapshotDeleting, models.SnapshotReady, models.SnapshotFailed); err != nil || ok {
+			t.Errorf("a snapshot is moved from another state: %v", err)
+		}
+		if snapshot, err = repo.FindByID("ct-1", "snapshot-1"); err != nil || snapshot.State != models.SnapshotReverting || snapshot.HostID.String != "{5c1a}" {
+			t.Errorf("got snapshot %+v: %v", snapshot, err)
+		}
+
+		if err := repo.Delete("snapshot-1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.DeleteByContainer("ct-1"); err != nil {
+			t.Fatal(err)
+		}
+		if snapshots, err = repo.List("ct-1"); err != nil || len(snapshots) != 0 {
+			t.Errorf("got snapshots %v of a deleted container: %v", snapshots, err)
+		}
+		if snapshots, err = repo.List("ct-2"); err != nil || len(snapshots) != 1 {
+			t.Errorf("got snapshots %v of another container: %v", snapshots, err)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+type SQLTemplateRepository struct {
+	sqlRepository
+}
+
+func NewTemplateRepository(db *sqlx.DB) *SQLTemplateRepository {
+	return &SQLTemplateRepository{sqlRepository{db: db}}
+}
+
+func (r *SQLTemplateRepository) List() ([]*models.Template, error) {
+	templates := make([]*models.Template, 0)
+	err := r.db.Select(&templates, r.q("SELECT name, created_at, synced_at FROM templates ORDER BY name"))
+
+	return templates, err
+}
+
+func (r *SQLTemplateRepository) Exists(name string) (bool, error) {
+	var n int
+	err := r.db.Get(&n, r.q("SELECT COUNT(*) FROM templates WHERE name=?"), name)
+
+	return n > 0, err
+}
+
+func (r *SQLTemplateRepository) Count() (int, error) {
+	var n int
+	err := r.db.Get(&n, "SELECT COUNT(*) FROM templates")
+
+	return n, err
+}
+
+func (r *SQLTemplateRepository) Sync(names []string, now time.Time) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, name := range names {
+		res, err := tx.Exec(r.q("UPDATE templates SET synced_at=? WHERE name=?"), now, name)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+
+		_, err = tx.Exec(r.q("INSERT INTO templates (name, created_at, synced_at) VALUES (?, ?, ?)"), name, now, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(r.q("DELETE FROM templates WHERE synced_at<?"), now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestTemplateRepository(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewTemplateRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+
+		if n, err := repo.Count(); err != nil || n != 0 {
+			t.Errorf("got %d templates in an empty catalog: %v", n, err)
+		}
+
+		if err := repo.Sync([]string{"debian-10-x86_64", "centos-7-x86_64"}, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.Sync([]string{"centos-7-x86_64", "centos-8-x86_64"}, now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		templates, err := repo.List()
+		if err != nil || len(templates) != 2 {
+			t.Fatalf("got templates %v: %v", templates, err)
+		}
+		if templates[0].Name != "centos-7-x86_64" || !templates[0].CreatedAt.Equal(now) || !templates[0].SyncedAt.Equal(now.Add(time.Hour)) {
+			t.Errorf("got a kept template %+v", templates[0])
+		}
+		if templates[1].Name != "centos-8-x86_64" || !templates[1].CreatedAt.Equal(now.Add(time.Hour)) {
+			t.Errorf("got an added template %+v", templates[1])
+		}
+
+		if found, err := repo.Exists("debian-10-x86_64"); err != nil || found {
+			t.Errorf("a template no longer found on a host exists: %v", err)
+		}
+		if found, err := repo.Exists("centos-8-x86_64"); err != nil || !found {
+			t.Errorf("a template found on a host does not exist: %v", err)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+const (
+	webhookColumns         = "id, url, secret, events, created_at"
+	webhookDeliveryColumns = "id, webhook_id, event, payload, status, attempts, response_code, last_error, next_run_at, created_at, delivered_at"
+)
+
+type SQLWebhookRepository struct {
+	sqlRepository
+}
+
+func NewWebhookRepository(db *sqlx.DB) *SQLWebhookRepository {
+	return &SQLWebhookRepository{sqlRepository{db: db}}
+}
+
+func (r *SQLWebhookRepository) Create(webhook *models.Webhook) error {
+	_, err := r.db.Exec(r.q("INSERT INTO webhooks (id, url, secret, events, created_at) VALUES (?, ?, ?, ?, ?)"),
+		webhook.ID, webhook.URL, webhook.Secret, webhook.Events, webhook.CreatedAt)
+
+	return err
+}
+
+func (r *SQLWebhookRepository) FindByID(id string) (*models.Webhook, error) {
+	var webhook models.Webhook
+
+	err := r.db.Get(&webhook, r.q("SELECT "+webhookColumns+" FROM webhooks WHERE id=? LIMIT 1"), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func (r *SQLWebhookRepository) List() ([]*models.Webhook, error) {
+	webhooks := make([]*models.Webhook, 0)
+	err := r.db.Select(&webhooks, "SELECT "+webhookColumns+" FROM webhooks ORDER BY created_at")
+
+	return webhooks, err
+}
+
+func (r *SQLWebhookRepository) Delete(id string) error {
+	_, err := r.db.Exec(r.q("DELETE FROM webhooks WHERE id=?"), id)
+	return err
+}
+
+func (r *SQLWebhookRepository) AddDeliveries(deliveries []*models.WebhookDelivery) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, delivery := range deliveries {
+		_, err := tx.Exec(r.q("INSERT INTO webhook_deliveries (id, webhook_id, event, payload, status, next_run_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"),
+			delivery.ID, delivery.WebhookID, delivery.Event, r.json(delivery.Payload), delivery.Status, delivery.NextRunAt, delivery.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (r *SQLWebhookRepository) ListDeliveries(webhookID string, limit int) ([]*models.WebhookDelivery, error) {
+	deliveries := make([]*models.WebhookDelivery, 0)
+	err := r.db.Select(&deliveries, r.q("SELECT "+webhookDeliveryColumns+" FROM webhook_deliveries WHERE webhook_id=? ORDER BY created_at DESC LIMIT ?"), webhookID, limit)
+
+	return deliveries, err
+}
+
+func (r *SQLWebhookRepository) ClaimDelivery(now, claimedUntil time.Time) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+
+	err := r.db.Get(&delivery, r.q(`UPDATE webhook_deliveries SET next_run_at=?, attempts=attempts+1
+		WHERE id=(SELECT id FROM webhook_deliveries WHERE status=? AND next_run_at<=? ORDER BY next_run_at LIMIT 1`+r.skipLocked()+`)
+		RETURNING `+webhookDeliveryColumns), claimedUntil, models.DeliveryPending, now)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+func (r *SQLWebhookRepository) MarkDelivered(id string, responseCode sql.NullInt64, deliveredAt time.Time) error {
+	_, err := r.db.Exec(r.q("UPDATE webhook_deliveries SET status=?, response_code=?, last_error=NULL, delivered_at=? WHERE id=?"),
+		models.DeliveryDelivered, responseCode, deliveredAt, id)
+
+	return err
+}
+
+func (r *SQLWebhookRepository) MarkDeliveryFailed(id string, responseCode sql.NullInt64, lastError string) error {
+	_, err := r.db.Exec(r.q("UPDATE webhook_deliveries SET status=?, response_code=?, last_error=? WHERE id=?"),
+		models.DeliveryFailed, responseCode, lastError, id)
+
+	return err
+}
+
+func (r *SQLWebhookRepository) ScheduleDelivery(id string, responseCode sql.NullInt64, lastError string, nextRunAt time.Time) error {
+	_, err := r.db.Exec(r.q("UPDATE webhook_deliveries SET response_code=?, last_error=?, next_run_at=? WHERE id=?"),
+		responseCode, lastError, nextRunAt, id)
+
+	return err
+}
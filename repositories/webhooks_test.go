@@ -0,0 +1,203 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repositories
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func addDelivery(t *testing.T, repo *SQLWebhookRepository, id, webhookID string, nextRunAt, createdAt time.Time) {
+	t.Helper()
+
+	err := repo.AddDeliveries([]*models.WebhookDelivery{{
+		ID:        id,
+		WebhookID: webhookID,
+		Event:     "job.done",
+		Payload:   []byte(`{"job_id":"job-1"}`),
+		Status:    models.DeliveryPending,
+		NextRunAt: nextRunAt,
+		CreatedAt: createdAt,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookRepository(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewWebhookRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+
+		for i, webhook := range []*models.Webhook{
+			{ID: "webhook-1", URL: "https://example.com/hook", Secret: "s3cret", Events: "job.done,job.failed"},
+			{ID: "webhook-2", URL: "https://example.org/hook", Events: "container.state"},
+		} {
+			webhook.CreatedAt = now.Add(time.Duration(i) * time.Second)
+			if err := repo.Create(webhook); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		webhook, err := repo.FindByID("webhook-1")
+		if err != nil || webhook.Secret != "s3cret" || strings.Join(webhook.EventList(), " ") != "job.done job.failed" {
+			t.Errorf("got webhook %+v: %v", webhook, err)
+		}
+		webhooks, err := repo.List()
+		if err != nil || len(webhooks) != 2 || webhooks[0].ID != "webhook-1" {
+			t.Errorf("got webhooks %v: %v", webhooks, err)
+		}
+
+		if err := repo.Delete("webhook-1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.FindByID("webhook-1"); err != sql.ErrNoRows {
+			t.Errorf("got a deleted webhook: %v", err)
+		}
+	})
+}
+
+func TestWebhookDeliveryClaim(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewWebhookRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		addDelivery(t, repo, "delivery-2", "webhook-1", now.Add(-time.Second), now)
+		addDelivery(t, repo, "delivery-1", "webhook-1", now.Add(-2*time.Second), now)
+		addDelivery(t, repo, "delivery-3", "webhook-1", now.Add(time.Second), now)
+
+		for _, step := range []struct {
+			name     string
+			update   func() error
+			at       time.Time
+			want     string
+			attempts int
+		}{
+			{"oldest due first", nil, now, "delivery-1", 1},
+			{"claimed ones are skipped", nil, now, "delivery-2", 1},
+			{"none due", nil, now, "", 0},
+			{"retry", func() error {
+				return repo.ScheduleDelivery("delivery-1", sql.NullInt64{Int64: 500, Valid: true}, "server error", now.Add(2*time.Second))
+			}, now.Add(2 * time.Second), "delivery-3", 1},
+			{"retried", nil, now.Add(2 * time.Second), "delivery-1", 2},
+			{"failed and delivered ones are done", func() error {
+				if err := repo.MarkDeliveryFailed("delivery-2", sql.NullInt64{}, "connection refused"); err != nil {
+					return err
+				}
+				return repo.MarkDelivered("delivery-1", sql.NullInt64{Int64: 200, Valid: true}, now)
+			}, now.Add(time.Hour), "delivery-3", 2},
+			{"nothing pending", nil, now.Add(time.Hour), "", 0},
+		} {
+			if step.update != nil {
+				if err := step.update(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			delivery, err := repo.ClaimDelivery(step.at, step.at.Add(time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case step.want == "" && delivery != nil:
+				t.Errorf("%s: claimed %+v, want none", step.name, delivery)
+			case step.want == "":
+			case delivery == nil || delivery.ID != step.want || delivery.Attempts != step.attempts:
+				t.Errorf("%s: claimed %+v, want %s after %d attempt(s)", step.name, delivery, step.want, step.attempts)
+			case !delivery.NextRunAt.Equal(step.at.Add(time.Minute)):
+				t.Errorf("%s: claimed until %v, want %v", step.name, delivery.NextRunAt, step.at.Add(time.Minute))
+			}
+		}
+
+		deliveries, err := repo.ListDeliveries("webhook-1", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		statuses := make(map[string]*models.WebhookDelivery)
+		for _, delivery := range deliveries {
+			statuses[delivery.ID] = delivery
+		}
+		if d := statuses["delivery-1"]; d.Status != models.DeliveryDelivered || d.ResponseCode.Int64 != 200 || d.LastError.Valid || !d.DeliveredAt.Valid {
+			t.Errorf("got delivered %+v", d)
+		}
+		if d := statuses["delivery-2"]; d.Status != models.DeliveryFailed || d.LastError.String != "connection refused" {
+			t.Errorf("got failed %+v", d)
+		}
+	})
+}
+
+func TestWebhookListDeliveries(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewWebhookRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		for i, id := range []string{"delivery-1", "delivery-2", "delivery-3"} {
+			addDelivery(t, repo, id, "webhook-1", now, now.Add(time.Duration(i)*time.Second))
+		}
+		addDelivery(t, repo, "delivery-4", "webhook-2", now, now.Add(time.Hour))
+
+		for _, tc := range []struct {
+			webhookID string
+			limit     int
+			want      string
+		}{
+			{"webhook-1", 10, "delivery-3,delivery-2,delivery-1"},
+			{"webhook-1", 2, "delivery-3,delivery-2"},
+			{"webhook-2", 10, "delivery-4"},
+			{"webhook-3", 10, ""},
+		} {
+			deliveries, err := repo.ListDeliveries(tc.webhookID, tc.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids := make([]string, 0, len(deliveries))
+			for _, delivery := range deliveries {
+				ids = append(ids, delivery.ID)
+			}
+			if got := strings.Join(ids, ","); got != tc.want {
+				t.Errorf("ListDeliveries(%s, %d) = %s, want %s", tc.webhookID, tc.limit, got, tc.want)
+			}
+		}
+
+		deliveries, err := repo.ListDeliveries("webhook-2", 1)
+		if err != nil || len(deliveries) != 1 || string(deliveries[0].Payload) != `{"job_id":"job-1"}` {
+			t.Errorf("got deliveries %+v: %v", deliveries, err)
+		}
+	})
+}
+
+func TestWebhookAddDeliveriesAtOnce(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, db *sqlx.DB) {
+		repo := NewWebhookRepository(db)
+		now := testTime("2021-03-01T10:00:00Z")
+		addDelivery(t, repo, "delivery-1", "webhook-1", now, now)
+
+		err := repo.AddDeliveries([]*models.WebhookDelivery{
+			{ID: "delivery-2", WebhookID: "webhook-2", Event: "job.done", Payload: []byte(`{}`), Status: models.DeliveryPending, NextRunAt: now, CreatedAt: now},
+			{ID: "delivery-1", WebhookID: "webhook-2", Event: "job.done", Payload: []byte(`{}`), Status: models.DeliveryPending, NextRunAt: now, CreatedAt: now},
+		})
+		if err == nil {
+			t.Fatal("Deliveries with a taken ID are added")
+		}
+		if deliveries, err := repo.ListDeliveries("webhook-2", 10); err != nil || len(deliveries) != 0 {
+			t.Errorf("Deliveries %+v are added partially: %v", deliveries, err)
+		}
+	})
+}
@@ -0,0 +1,32 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/romiras/go-openvz-api/handlers"
+	"github.com/romiras/go-openvz-api/registries"
+)
+
+func addBackupRoutes(reg *registries.Registry, grp *gin.RouterGroup) {
+	backups := grp.Group("/backups")
+
+	backups.GET("/", withRegistry(handlers.ListBackups, reg))
+	backups.GET("/:bid", withRegistry(handlers.GetBackupById, reg))
+	backups.DELETE("/:bid", withRegistry(handlers.DeleteBackup, reg))
+	backups.POST("/:bid/restore", withRegistry(handlers.RestoreBackup, reg))
+}
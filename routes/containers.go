@@ -29,5 +29,17 @@ func addContainerRoutes(reg *registries.Registry, grp *gin.RouterGroup) {
 	containers.POST("/", withRegistry(handlers.CreateContainer, reg))
 	containers.GET("/:id", withRegistry(handlers.GetContainerById, reg))
 	containers.PATCH("/:id", withRegistry(handlers.UpdateContainer, reg))
+	containers.PUT("/:id", withRegistry(handlers.ReplaceContainer, reg))
 	containers.DELETE("/:id", withRegistry(handlers.DeleteContainer, reg))
+	containers.POST("/:id/actions/:action", withRegistry(handlers.ContainerAction, reg))
+	containers.POST("/:id/clone", withRegistry(handlers.CloneContainer, reg))
+
+	containers.GET("/:id/snapshots", withRegistry(handlers.ListSnapshots, reg))
+	containers.POST("/:id/snapshots", withRegistry(handlers.CreateSnapshot, reg))
+	containers.GET("/:id/snapshots/:sid", withRegistry(handlers.GetSnapshotById, reg))
+	containers.DELETE("/:id/snapshots/:sid", withRegistry(handlers.DeleteSnapshot, reg))
+	containers.POST("/:id/snapshots/:sid/revert", withRegistry(handlers.RevertSnapshot, reg))
+
+	containers.GET("/:id/backups", withRegistry(handlers.ListContainerBackups, reg))
+	containers.POST("/:id/backups", withRegistry(handlers.CreateBackup, reg))
 }
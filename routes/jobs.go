@@ -25,5 +25,10 @@ import (
 func addJobRoutes(reg *registries.Registry, grp *gin.RouterGroup) {
 	containers := grp.Group("/jobs")
 
+	containers.GET("/", withRegistry(handlers.ListJobs, reg))
 	containers.GET("/:id", withRegistry(handlers.GetJobById, reg))
+	containers.GET("/:id/logs", withRegistry(handlers.GetJobLogs, reg))
+	containers.GET("/:id/events", withRegistry(handlers.JobEvents, reg))
+	containers.GET("/:id/ws", withRegistry(handlers.JobEventsWebSocket, reg))
+	containers.POST("/:id/cancel", withRegistry(handlers.CancelJob, reg))
 }
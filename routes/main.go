@@ -40,6 +40,12 @@ func getRoutes(reg *registries.Registry) {
 	v1 := router.Group("/v0.1")
 	addContainerRoutes(reg, v1)
 	addJobRoutes(reg, v1)
+	addWebhookRoutes(reg, v1)
+	addCommandRoutes(reg, v1)
+	addDriftRoutes(reg, v1)
+	addBackupRoutes(reg, v1)
+	addPolicyRoutes(reg, v1)
+	addTemplateRoutes(reg, v1)
 }
 
 func withRegistry(handler func(*gin.Context, *registries.Registry), registry *registries.Registry) func(*gin.Context) {
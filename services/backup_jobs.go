@@ -0,0 +1,371 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+// ErrDamagedArchive is returned when an archive of a backup is not restored, as it is missing or changed
+var ErrDamagedArchive = errors.New("damaged-archive")
+
+func (j *JobService) createBackup(ctx context.Context, job *models.Job) (string, error) {
+	payload, backup, err := j.parseBackupJob(job)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	hostBackup, err := j.Commander.BackupContainer(ctx, payload.Name, backup.ID, j.BackupDir)
+	if err == nil && (j.BackupDir != "" || hostBackup.Archive != "") {
+		err = setArchive(backup, hostBackup.Archive, j.BackupDir)
+	}
+	if err != nil {
+		return payload.ID, j.failBackupJob(ctx, job, backup, models.BackupFailed, err)
+	}
+	backup.HostID = sql.NullString{String: hostBackup.ID, Valid: true}
+
+	return payload.ID, j.BackupRepo.MarkReady(backup)
+}
+
+// setArchive sets an archive of a backup along with its size and checksum. The archive must be
+// in a backup directory, as it is deleted along with the backup.
+func setArchive(backup *models.Backup, archive, dir string) error {
+	if archive == "" {
+		return commanders.Permanent(fmt.Errorf("no archive is printed by %s", commanders.CtBackup))
+	}
+
+	resolved, err := filepath.EvalSymlinks(archive)
+	if err != nil {
+		return commanders.Permanent(fmt.Errorf("archive %s is not readable: %w", archive, err))
+	}
+	if !filepath.IsAbs(archive) || !isInDir(resolved, dir) {
+		return commanders.Permanent(fmt.Errorf("archive %s is not in backup directory %s", archive, dir))
+	}
+
+	size, checksum, err := archiveInfo(resolved)
+	if err != nil {
+		return commanders.Permanent(fmt.Errorf("archive %s is not readable: %w", archive, err))
+	}
+
+	backup.Archive = sql.NullString{String: resolved, Valid: true}
+	backup.Size = sql.NullInt64{Int64: size, Valid: true}
+	backup.Checksum = sql.NullString{String: checksum, Valid: true}
+
+	return nil
+}
+
+// isInDir reports whether a path without symlinks is inside a directory
+func isInDir(path, dir string) bool {
+	if dir == "" {
+		return false
+	}
+
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(dir, path)
+
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// archiveInfo returns a size of an archive, which is a file or a directory, and a SHA-256 checksum of its files
+func archiveInfo(path string) (int64, string, error) {
+	var size int64
+	hash := sha256.New()
+
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		n, err := io.Copy(hash, f)
+		size += n
+
+		return err
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func (j *JobService) deleteBackup(ctx context.Context, job *models.Job) (string, error) {
+	payload, backup, err := j.parseBackupJob(job)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	// A backup which failed to be made exists in the database only. So does a backup whose archive
+	// is not in the backup directory, e.g. one made before -backupdir is changed or by another
+	// commander, as no command is run on an outer archive.
+	if backup.HostID.Valid {
+		if archiveErr := j.checkArchive(backup); archiveErr != nil {
+			log.Printf("Backup %s is deleted from the database only: %s", backup.ID, archiveErr.Error())
+			return payload.ID, j.BackupRepo.Delete(backup.ID)
+		}
+
+		err = j.Commander.DeleteBackup(ctx, hostBackup(backup))
+		if err != nil {
+			return payload.ID, j.failBackupJob(ctx, job, backup, models.BackupDeleteFailed, err)
+		}
+	}
+
+	return payload.ID, j.BackupRepo.Delete(backup.ID)
+}
+
+// restoreBackup restores a backup into a new container, or replaces an existing container with it:
+// the container is deleted from a host and created anew. Parameters of the container are restored
+// as they were when the backup was made.
+func (j *JobService) restoreBackup(ctx context.Context, job *models.Job) (string, error) {
+	var payload RestoreBackupJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	backup, err := j.BackupRepo.FindByID(payload.BackupID)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	err = j.checkArchive(backup)
+	if err != nil {
+		return payload.ID, j.failBackupJob(ctx, job, backup, models.BackupFailed, err)
+	}
+
+	id := payload.ID
+	if payload.NewContainer {
+		id, err = j.createRestoredContainer(payload.Name, backup)
+	} else {
+		err = j.clearRestoredContainer(ctx, job, id, payload.Name, backup)
+	}
+	if errors.Is(err, ErrDamagedArchive) {
+		return id, j.failBackupJob(ctx, job, backup, models.BackupFailed, err)
+	}
+	if err != nil {
+		return id, j.failBackupJob(ctx, job, backup, models.BackupReady, err)
+	}
+	j.publishProgress(job.ID, 30)
+
+	err = j.Commander.RestoreBackup(ctx, payload.Name, hostBackup(backup))
+	if err == nil {
+		var parameters map[string]string
+		parameters, err = backup.Parameters()
+		if err == nil {
+			err = j.ContainerRepo.SetParameters(id, parameters)
+		}
+	}
+
+	err = j.finishCreation(job, id, payload.Name, backup.OSTemplate, err)
+	if err != nil {
+		return id, j.failBackupJob(ctx, job, backup, models.BackupReady, err)
+	}
+
+	return id, j.BackupRepo.SetState(backup.ID, models.BackupReady)
+}
+
+// createRestoredContainer inserts a container a backup is restored into, unless it is inserted
+// by a previous attempt of the job
+func (j *JobService) createRestoredContainer(name string, backup *models.Backup) (string, error) {
+	id, err := j.ContainerRepo.FindIDByName(name, models.CREATING)
+	if err != sql.ErrNoRows {
+		return id, err
+	}
+
+	id = uuid.New().String()
+	err = j.ContainerRepo.Create(&models.Container{
+		ID:         id,
+		Name:       name,
+		OSTemplate: backup.OSTemplate,
+		State:      models.CREATING,
+	})
+
+	return id, err
+}
+
+// clearRestoredContainer deletes an existing container a backup is restored into from a host,
+// along with its snapshots, and marks it as being created. It is done once, so that a retry
+// of the job only restores the backup. The container is kept if an archive of the backup is damaged.
+func (j *JobService) clearRestoredContainer(ctx context.Context, job *models.Job, id, name string, backup *models.Backup) error {
+	state, err := j.ContainerRepo.GetState(id)
+	if err != nil || state == models.CREATING {
+		return err
+	}
+	if !canTransition(job.Type, state) {
+		return ErrInvalidContainerState
+	}
+
+	err = verifyArchive(backup)
+	if err != nil {
+		return err
+	}
+
+	err = j.Commander.DeleteContainer(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	err = j.SnapshotRepo.DeleteByContainer(id)
+	if err != nil {
+		return err
+	}
+
+	return j.setContainerState(id, models.CREATING)
+}
+
+// checkArchive refuses a backup kept in a backup directory if its archive is not there, so that
+// commands restoring or deleting it never run on a missing or outer archive
+func (j *JobService) checkArchive(backup *models.Backup) error {
+	if j.BackupDir == "" {
+		return nil
+	}
+	if !backup.Archive.Valid || !isInDir(backup.Archive.String, j.BackupDir) {
+		return fmt.Errorf("%w: backup %s has no archive in backup directory %s", ErrDamagedArchive, backup.ID, j.BackupDir)
+	}
+
+	return nil
+}
+
+// verifyArchive checks that an archive of a backup, if it has one, is readable and unchanged
+// since the backup was made
+func verifyArchive(backup *models.Backup) error {
+	if !backup.Archive.Valid {
+		return nil
+	}
+
+	_, checksum, err := archiveInfo(backup.Archive.String)
+	if err != nil {
+		return fmt.Errorf("%w: %s is not readable: %s", ErrDamagedArchive, backup.Archive.String, err.Error())
+	}
+	if backup.Checksum.Valid && checksum != backup.Checksum.String {
+		return fmt.Errorf("%w: %s is changed since the backup was made", ErrDamagedArchive, backup.Archive.String)
+	}
+
+	return nil
+}
+
+// failBackupJob sets a state of a backup when its job fails for good, and returns err
+func (j *JobService) failBackupJob(ctx context.Context, job *models.Job, backup *models.Backup, state string, err error) error {
+	if ctx.Err() == nil && j.willRetry(job, err) {
+		return err
+	}
+
+	if stateErr := j.BackupRepo.SetState(backup.ID, state); stateErr != nil {
+		return stateErr
+	}
+
+	return err
+}
+
+func (j *JobService) parseBackupJob(job *models.Job) (*BackupJob, *models.Backup, error) {
+	var payload BackupJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return &payload, nil, err
+	}
+
+	backup, err := j.BackupRepo.FindByID(payload.BackupID)
+
+	return &payload, backup, err
+}
+
+func hostBackup(backup *models.Backup) commanders.HostBackup {
+	return commanders.HostBackup{
+		ID:      backup.HostID.String,
+		Archive: backup.Archive.String,
+	}
+}
+
+func (j *JobService) dryRunCreateBackup(ctx context.Context, job *models.Job) (string, error) {
+	var payload BackupJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = j.Commander.BackupContainer(ctx, payload.Name, payload.BackupID, j.BackupDir)
+
+	return payload.ID, err
+}
+
+func (j *JobService) dryRunDeleteBackup(ctx context.Context, job *models.Job) (string, error) {
+	payload, backup, err := j.parseBackupJob(job)
+	if err != nil || !backup.HostID.Valid {
+		return payload.ID, err
+	}
+
+	if j.checkArchive(backup) != nil {
+		return payload.ID, nil
+	}
+
+	return payload.ID, j.Commander.DeleteBackup(ctx, hostBackup(backup))
+}
+
+func (j *JobService) dryRunRestoreBackup(ctx context.Context, job *models.Job) (string, error) {
+	var payload RestoreBackupJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	backup, err := j.BackupRepo.FindByID(payload.BackupID)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	err = j.checkArchive(backup)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	if !payload.NewContainer {
+		err = j.Commander.DeleteContainer(ctx, payload.Name)
+		if err != nil {
+			return payload.ID, err
+		}
+	}
+
+	return payload.ID, j.Commander.RestoreBackup(ctx, payload.Name, hostBackup(backup))
+}
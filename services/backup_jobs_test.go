@@ -0,0 +1,350 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+// noArchiveCommander is a fake host printing no archive of a backup
+type noArchiveCommander struct {
+	*commanders.FakeCommander
+}
+
+func (cmd noArchiveCommander) BackupContainer(ctx context.Context, name, backupID, dir string) (commanders.HostBackup, error) {
+	return cmd.FakeCommander.BackupContainer(ctx, name, backupID, "")
+}
+
+func TestSetArchiveRejectsArchivesOutsideBackupDir(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+
+	archive := filepath.Join(dir, "vzdump-101.tar")
+	stranger := filepath.Join(outside, "passwd")
+	for _, path := range []string{archive, stranger} {
+		if err := ioutil.WriteFile(path, []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(dir, "vzdump-102.tar")
+	if err := os.Symlink(stranger, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		archive string
+		ok      bool
+	}{
+		{archive, true},
+		{stranger, false},
+		{link, false},
+		{filepath.Join(dir, "..", filepath.Base(outside), "passwd"), false},
+		{dir, false},
+		{"vzdump-101.tar", false},
+		{filepath.Join(dir, "vzdump-103.tar"), false},
+	}
+
+	for _, tt := range tests {
+		backup := &models.Backup{}
+		err := setArchive(backup, tt.archive, dir)
+		if (err == nil) != tt.ok {
+			t.Errorf("setArchive(%s) = %v, want ok %v", tt.archive, err, tt.ok)
+		}
+		if tt.ok && (backup.Size.Int64 != 4 || !backup.Checksum.Valid) {
+			t.Errorf("setArchive(%s) set size %d, checksum %q", tt.archive, backup.Size.Int64, backup.Checksum.String)
+		}
+	}
+
+	if err := setArchive(&models.Backup{}, archive, ""); err == nil {
+		t.Error("setArchive() without a backup directory = nil, want an error")
+	}
+}
+
+// restoreTestArchive restores a backup with an archive into an existing container,
+// after damage is done to the archive
+func restoreTestArchive(t *testing.T, damage func(archive string)) (*JobService, *commanders.FakeCommander, *models.Job) {
+	t.Helper()
+
+	db := newTestDB(t)
+	cmd := commanders.NewFakeCommander()
+	j := NewJobService(db, cmd, events.NewBus())
+	j.BackupDir = t.TempDir()
+	srv := NewBackupAPIService(db, j)
+
+	addTestContainer(t, srv.ContainerRepo, "c1", "ct1", map[string]string{})
+	if err := cmd.CreateContainer(context.Background(), "ct1", "centos-7", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmd.BackupContainer(context.Background(), "ct1", "b1", j.BackupDir); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(j.BackupDir, "vzdump-101.tar")
+	if err := ioutil.WriteFile(archive, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	backup := &models.Backup{
+		ID:            "b1",
+		ContainerID:   "c1",
+		ContainerName: "ct1",
+		OSTemplate:    "centos-7",
+		State:         models.BackupCreating,
+		CreatedAt:     time.Now().UTC(),
+	}
+	if err := j.BackupRepo.Create(backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := setArchive(backup, archive, j.BackupDir); err != nil {
+		t.Fatal(err)
+	}
+	backup.HostID.String, backup.HostID.Valid = "b1", true
+	if err := j.BackupRepo.MarkReady(backup); err != nil {
+		t.Fatal(err)
+	}
+	damage(archive)
+
+	resp, err := srv.Restore(&api.RestoreBackupRequest{ID: "b1", ContainerID: "c1"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestJobs(t, j)
+
+	job, err := j.JobRepo.FindByID(resp.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return j, cmd, job
+}
+
+func TestRestoreKeepsContainerIfArchiveIsDamaged(t *testing.T) {
+	damages := map[string]func(archive string){
+		"changed": func(archive string) {
+			if err := ioutil.WriteFile(archive, []byte("other data"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"missing": func(archive string) {
+			if err := os.Remove(archive); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+
+	for name, damage := range damages {
+		t.Run(name, func(t *testing.T) {
+			j, cmd, job := restoreTestArchive(t, damage)
+
+			if job.Status != models.FAILED || job.Attempts != 1 {
+				t.Errorf("Job status = %v after %d attempt(s), want failed after 1", job.Status, job.Attempts)
+			}
+			assertBackupState(t, j, "b1", models.BackupFailed)
+
+			state, err := j.ContainerRepo.GetState("c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state != models.STOPPED {
+				t.Errorf("Container state = %s, want %s", state, models.STOPPED)
+			}
+			containers, err := cmd.ListContainers(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(containers) != 1 {
+				t.Errorf("Host containers = %+v, want ct1 kept", containers)
+			}
+		})
+	}
+}
+
+func TestRestoreReplacesContainerIfArchiveIsIntact(t *testing.T) {
+	j, _, job := restoreTestArchive(t, func(string) {})
+
+	if job.Status != models.DONE {
+		t.Fatalf("Job status = %v, last error %q, want done", job.Status, job.LastError.String)
+	}
+	assertBackupState(t, j, "b1", models.BackupReady)
+}
+
+func TestBackupWithoutArchiveFails(t *testing.T) {
+	db := newTestDB(t)
+	cmd := noArchiveCommander{commanders.NewFakeCommander()}
+	j := NewJobService(db, cmd, events.NewBus())
+	j.BackupDir = t.TempDir()
+	srv := NewBackupAPIService(db, j)
+
+	addTestContainer(t, srv.ContainerRepo, "c1", "ct1", map[string]string{})
+	if err := cmd.CreateContainer(context.Background(), "ct1", "centos-7", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := srv.Create(&api.AddBackupRequest{ContainerID: "c1"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestJobs(t, j)
+
+	job, err := j.JobRepo.FindByID(resp.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.FAILED || job.Attempts != 1 {
+		t.Errorf("Job status = %v after %d attempt(s), want failed after 1", job.Status, job.Attempts)
+	}
+	assertBackupState(t, j, resp.Backup.ID, models.BackupFailed)
+}
+
+func TestBackupWithoutArchiveIsNotRestoredAndDeletedFromDatabaseOnly(t *testing.T) {
+	db := newTestDB(t)
+	cmd := commanders.NewFakeCommander()
+	j := NewJobService(db, cmd, events.NewBus())
+	j.BackupDir = t.TempDir()
+	srv := NewBackupAPIService(db, j)
+
+	addTestContainer(t, srv.ContainerRepo, "c1", "ct1", map[string]string{})
+	if err := cmd.CreateContainer(context.Background(), "ct1", "centos-7", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cmd.BackupContainer(context.Background(), "ct1", "b1", j.BackupDir); err != nil {
+		t.Fatal(err)
+	}
+	backup := &models.Backup{
+		ID:            "b1",
+		ContainerID:   "c1",
+		ContainerName: "ct1",
+		OSTemplate:    "centos-7",
+		State:         models.BackupCreating,
+		CreatedAt:     time.Now().UTC(),
+	}
+	if err := j.BackupRepo.Create(backup); err != nil {
+		t.Fatal(err)
+	}
+	backup.HostID.String, backup.HostID.Valid = "b1", true
+	if err := j.BackupRepo.MarkReady(backup); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := srv.Restore(&api.RestoreBackupRequest{ID: "b1", ContainerID: "c1"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestJobs(t, j)
+	if status, descr := findTestJob(t, j, resp.JobID); status != models.FAILED || !strings.HasPrefix(descr, ErrDamagedArchive.Error()) {
+		t.Errorf("Restore status = %v %q, want failed", status, descr)
+	}
+	if state, err := j.ContainerRepo.GetState("c1"); err != nil || state != models.STOPPED {
+		t.Errorf("Container state = %s, want %s: %v", state, models.STOPPED, err)
+	}
+
+	resp, err = srv.Delete("b1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestJobs(t, j)
+	if status, descr := findTestJob(t, j, resp.JobID); status != models.DONE {
+		t.Errorf("Delete status = %v %q, want done", status, descr)
+	}
+	if _, err := j.BackupRepo.FindByID("b1"); err != sql.ErrNoRows {
+		t.Errorf("Backup is found after it is deleted: %v", err)
+	}
+	if err := cmd.RestoreBackup(context.Background(), "ct2", commanders.HostBackup{ID: "b1"}); err != nil {
+		t.Errorf("Backup is deleted from a host: %v", err)
+	}
+}
+
+func TestBackupIsRestoredAndDeleted(t *testing.T) {
+	db := newTestDB(t)
+	cmd := commanders.NewFakeCommander()
+	j := NewJobService(db, cmd, events.NewBus())
+	j.BackupDir = t.TempDir()
+	srv := NewBackupAPIService(db, j)
+
+	addTestContainer(t, srv.ContainerRepo, "c1", "ct1", map[string]string{"cpus": "2"})
+	if err := cmd.CreateContainer(context.Background(), "ct1", "centos-7", map[string]string{"cpus": "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	created, err := srv.Create(&api.AddBackupRequest{ContainerID: "c1"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestJobs(t, j)
+	if status, descr := findTestJob(t, j, created.JobID); status != models.DONE {
+		t.Fatalf("Backup status = %v %q, want done", status, descr)
+	}
+
+	backup, err := j.BackupRepo.FindByID(created.Backup.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.State != models.BackupReady || !isInDir(backup.Archive.String, j.BackupDir) {
+		t.Fatalf("Backup is %s with archive %q, want ready in %s", backup.State, backup.Archive.String, j.BackupDir)
+	}
+	if backup.Size.Int64 == 0 || backup.Checksum.String == "" {
+		t.Errorf("Backup size = %d, checksum = %q, want both set", backup.Size.Int64, backup.Checksum.String)
+	}
+
+	restored, err := srv.Restore(&api.RestoreBackupRequest{ID: backup.ID, Name: "ct2"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestJobs(t, j)
+	if status, descr := findTestJob(t, j, restored.JobID); status != models.DONE {
+		t.Fatalf("Restore status = %v %q, want done", status, descr)
+	}
+	assertBackupState(t, j, backup.ID, models.BackupReady)
+
+	id, err := j.ContainerRepo.FindIDByName("ct2", models.STOPPED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container, err := j.ContainerRepo.FindByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.Parameters["cpus"] != "2" {
+		t.Errorf("Restored parameters = %v, want cpus 2", container.Parameters)
+	}
+
+	deleted, err := srv.Delete(backup.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestJobs(t, j)
+	if status, descr := findTestJob(t, j, deleted.JobID); status != models.DONE {
+		t.Fatalf("Delete status = %v %q, want done", status, descr)
+	}
+	if _, err := j.BackupRepo.FindByID(backup.ID); err != sql.ErrNoRows {
+		t.Errorf("Backup is found after it is deleted: %v", err)
+	}
+	if _, err := os.Stat(backup.Archive.String); !os.IsNotExist(err) {
+		t.Errorf("Archive %s is kept after its backup is deleted: %v", backup.Archive.String, err)
+	}
+}
@@ -0,0 +1,291 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/models"
+	"github.com/romiras/go-openvz-api/repositories"
+)
+
+// ErrInvalidBackupState is returned when an operation is not allowed in the current backup state
+var ErrInvalidBackupState = errors.New("invalid-backup-state")
+
+type BackupAPIService struct {
+	BackupRepo    repositories.BackupRepository
+	ContainerRepo repositories.ContainerRepository
+	Jobs          *JobService
+}
+
+func NewBackupAPIService(db DBConnection, jobs *JobService) *BackupAPIService {
+	return &BackupAPIService{
+		BackupRepo:    repositories.NewBackupRepository(db),
+		ContainerRepo: repositories.NewContainerRepository(db),
+		Jobs:          jobs,
+	}
+}
+
+// Create records a backup and enqueues a job making it. A dry run only renders commands of the job.
+func (srv *BackupAPIService) Create(req *api.AddBackupRequest, dryRun bool) (*api.AddBackupResponse, error) {
+	// Containers are backed up in the same states as they are snapshotted
+	container, err := srv.ContainerRepo.FindByID(req.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+	if !isOneOfStates(container.State, snapshotContainerStates) {
+		return nil, ErrInvalidContainerState
+	}
+
+	payload := BackupJob{
+		ContainerJob: ContainerJob{
+			ID:   container.ID,
+			Name: container.Name,
+		},
+		BackupID: uuid.New().String(),
+	}
+
+	if dryRun || srv.Jobs.DryRun {
+		resp, err := srv.Jobs.submit(CreateBackupType, payload, EnqueueOptions{}, true)
+		if err != nil {
+			return nil, err
+		}
+
+		return &api.AddBackupResponse{
+			ApiResponse: resp.ApiResponse,
+			JobID:       resp.JobID,
+			DryRun:      true,
+			Commands:    resp.Commands,
+		}, nil
+	}
+
+	parameters, err := json.Marshal(container.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := &models.Backup{
+		ID:             payload.BackupID,
+		ContainerID:    container.ID,
+		ContainerName:  container.Name,
+		OSTemplate:     container.OSTemplate,
+		Description:    req.Description,
+		State:          models.BackupCreating,
+		ParametersJSON: sql.NullString{String: string(parameters), Valid: true},
+		PolicyID:       sql.NullString{String: req.PolicyID, Valid: req.PolicyID != ""},
+		CreatedAt:      time.Now().UTC(),
+	}
+	if req.RetentionDays > 0 {
+		backup.ExpiresAt = sql.NullTime{Time: backup.CreatedAt.AddDate(0, 0, req.RetentionDays), Valid: true}
+	}
+	err = srv.BackupRepo.Create(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	jobID, err := srv.Jobs.Enqueue(CreateBackupType, payload, EnqueueOptions{})
+	if err != nil {
+		if stateErr := srv.BackupRepo.SetState(backup.ID, models.BackupFailed); stateErr != nil {
+			return nil, stateErr
+		}
+		return nil, err
+	}
+
+	return &api.AddBackupResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		JobID:  jobID,
+		Backup: backupInfo(backup),
+	}, nil
+}
+
+// ListByContainer lists backups of an existing container
+func (srv *BackupAPIService) ListByContainer(containerID string) (*api.ListBackupsResponse, error) {
+	_, err := srv.ContainerRepo.FindByID(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return srv.List(containerID)
+}
+
+// List lists backups of a container, which may be deleted already, or all backups if containerID is empty
+func (srv *BackupAPIService) List(containerID string) (*api.ListBackupsResponse, error) {
+	backups, err := srv.BackupRepo.List(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*api.BackupInfo, 0, len(backups))
+	for _, backup := range backups {
+		infos = append(infos, backupInfo(backup))
+	}
+
+	return &api.ListBackupsResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Backups: infos,
+	}, nil
+}
+
+func (srv *BackupAPIService) GetById(id string) (*api.GetBackupByIdResponse, error) {
+	backup, err := srv.BackupRepo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.GetBackupByIdResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Backup: backupInfo(backup),
+	}, nil
+}
+
+// Delete enqueues a job deleting a backup which is ready, failed to be made or failed to be deleted
+func (srv *BackupAPIService) Delete(id string, dryRun bool) (*api.JobResponse, error) {
+	backup, err := srv.BackupRepo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun = dryRun || srv.Jobs.DryRun
+	err = srv.transition(backup, dryRun, models.BackupDeleting, models.BackupReady, models.BackupFailed, models.BackupDeleteFailed)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := srv.Jobs.submit(DeleteBackupType, BackupJob{
+		ContainerJob: ContainerJob{
+			ID:   backup.ContainerID,
+			Name: backup.ContainerName,
+		},
+		BackupID: backup.ID,
+	}, EnqueueOptions{}, dryRun)
+	if err != nil && !dryRun {
+		if stateErr := srv.BackupRepo.SetState(backup.ID, backup.State); stateErr != nil {
+			return nil, stateErr
+		}
+	}
+
+	return resp, err
+}
+
+// Restore enqueues a job restoring a backup which is ready into an existing stopped container,
+// or into a new one
+func (srv *BackupAPIService) Restore(req *api.RestoreBackupRequest, dryRun bool) (*api.JobResponse, error) {
+	backup, err := srv.BackupRepo.FindByID(req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := RestoreBackupJob{
+		BackupJob: BackupJob{
+			BackupID: backup.ID,
+		},
+	}
+	opts := EnqueueOptions{}
+
+	if req.ContainerID != "" {
+		container, err := srv.ContainerRepo.FindByID(req.ContainerID)
+		if err != nil {
+			return nil, err
+		}
+		if !canTransition(RestoreBackupType, container.State) {
+			return nil, ErrInvalidContainerState
+		}
+
+		payload.ID = container.ID
+		payload.Name = container.Name
+	} else {
+		if err := srv.Jobs.checkNameIsFree(req.Name); err != nil {
+			return nil, err
+		}
+
+		payload.Name = req.Name
+		payload.NewContainer = true
+		opts.ReservedName = req.Name
+	}
+
+	dryRun = dryRun || srv.Jobs.DryRun
+	err = srv.transition(backup, dryRun, models.BackupRestoring, models.BackupReady)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := srv.Jobs.submit(RestoreBackupType, payload, opts, dryRun)
+	if err != nil && !dryRun {
+		if stateErr := srv.BackupRepo.SetState(backup.ID, models.BackupReady); stateErr != nil {
+			return nil, stateErr
+		}
+	}
+
+	return resp, err
+}
+
+// transition moves a backup to state to, if it is in one of from states. A dry run leaves the backup as it is.
+func (srv *BackupAPIService) transition(backup *models.Backup, dryRun bool, to string, from ...string) error {
+	if dryRun {
+		if !isOneOf(backup.State, from) {
+			return ErrInvalidBackupState
+		}
+		return nil
+	}
+
+	ok, err := srv.BackupRepo.Transition(backup.ID, to, from...)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidBackupState
+	}
+
+	return nil
+}
+
+func backupInfo(backup *models.Backup) *api.BackupInfo {
+	info := &api.BackupInfo{
+		ID:            backup.ID,
+		ContainerID:   backup.ContainerID,
+		ContainerName: backup.ContainerName,
+		OSTemplate:    backup.OSTemplate,
+		Description:   backup.Description,
+		State:         backup.State,
+		Archive:       backup.Archive.String,
+		Checksum:      backup.Checksum.String,
+		PolicyID:      backup.PolicyID.String,
+		CreatedAt:     backup.CreatedAt,
+	}
+	if backup.Size.Valid {
+		info.Size = &backup.Size.Int64
+	}
+	if backup.ExpiresAt.Valid {
+		info.ExpiresAt = &backup.ExpiresAt.Time
+	}
+
+	return info
+}
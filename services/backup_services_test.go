@@ -0,0 +1,114 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func TestBackupLifecycle(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+	backups := &BackupAPIService{BackupRepo: jobs.BackupRepo, ContainerRepo: jobs.ContainerRepo, Jobs: jobs}
+	id := createTestContainer(t, srv, jobs, "web", map[string]json.RawMessage{"hostname": json.RawMessage(`"web.example.com"`)})
+
+	req := &api.AddBackupRequest{ContainerID: id, Description: "nightly", RetentionDays: 7}
+	if err := api.ValidateAddBackupRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	created, err := backups.Create(req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Backup.State != models.BackupCreating || created.Backup.ExpiresAt == nil {
+		t.Errorf("Backup = %+v, want one being made and expiring", created.Backup)
+	}
+	assertJobDone(t, jobs, created.JobID)
+	backupID := created.Backup.ID
+
+	restore := &api.RestoreBackupRequest{ID: backupID, Name: "web"}
+	if err := api.ValidateRestoreBackupRequest(restore); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backups.Restore(restore, false); err != ErrDuplicateName {
+		t.Errorf("Restore() into a taken name = %v, want %v", err, ErrDuplicateName)
+	}
+
+	restore.Name = "restored"
+	restored, err := backups.Restore(restore, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backups.Delete(backupID, false); err != ErrInvalidBackupState {
+		t.Errorf("Delete() of a restoring backup = %v, want %v", err, ErrInvalidBackupState)
+	}
+	assertJobDone(t, jobs, restored.JobID)
+	job, err := jobs.JobRepo.FindByID(restored.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := assertContainer(t, srv, job.EntityID.String, models.STOPPED)
+	if container.Name != "restored" || container.Parameters["hostname"] != "web.example.com" {
+		t.Errorf("Container = %+v, want one with parameters of the backup", container)
+	}
+
+	got, err := backups.GetById(backupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Backup.State != models.BackupReady || got.Backup.ContainerName != "web" {
+		t.Errorf("Backup = %+v, want a ready one", got.Backup)
+	}
+
+	deleted, err := backups.Delete(backupID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, deleted.JobID)
+	list, err := backups.ListByContainer(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Backups) != 0 {
+		t.Errorf("Backups = %+v, want none", list.Backups)
+	}
+}
+
+func TestBackupDryRun(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+	backups := &BackupAPIService{BackupRepo: jobs.BackupRepo, ContainerRepo: jobs.ContainerRepo, Jobs: jobs}
+	id := createTestContainer(t, srv, jobs, "web", nil)
+
+	created, err := backups.Create(&api.AddBackupRequest{ContainerID: id}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.DryRun || len(created.Commands) != 1 || created.Backup != nil {
+		t.Errorf("Create() = %+v, want a rendered command", created)
+	}
+
+	list, err := backups.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Backups) != 0 {
+		t.Errorf("Backups = %+v, want none", list.Backups)
+	}
+}
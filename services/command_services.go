@@ -0,0 +1,88 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"os/exec"
+	"sort"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+)
+
+// commandJobTypes maps commands to types of jobs running them
+var commandJobTypes = map[string][]string{
+	commanders.CtCreate:  {AddContainerType},
+	commanders.CtSet:     {AddContainerType, UpdateContainerType, CloneContainerType, RestoreBackupType},
+	commanders.CtDelete:  {AddContainerType, DeleteContainerType, CloneContainerType, RestoreBackupType},
+	commanders.CtStart:   {StartContainerType},
+	commanders.CtStop:    {StopContainerType},
+	commanders.CtRestart: {RestartContainerType},
+	commanders.CtSuspend: {SuspendContainerType},
+	commanders.CtResume:  {ResumeContainerType},
+	commanders.CtList:    {RevertSnapshotType},
+	commanders.CtClone:   {CloneContainerType},
+
+	commanders.CtSnapshot:       {CreateSnapshotType},
+	commanders.CtSnapshotDelete: {DeleteSnapshotType},
+	commanders.CtSnapshotSwitch: {RevertSnapshotType},
+
+	commanders.CtBackup:       {CreateBackupType},
+	commanders.CtBackupDelete: {DeleteBackupType},
+	commanders.CtRestore:      {RestoreBackupType},
+}
+
+type CommandAPIService struct {
+	Commander commanders.Commander
+}
+
+func NewCommandAPIService(cmd commanders.Commander) *CommandAPIService {
+	return &CommandAPIService{
+		Commander: cmd,
+	}
+}
+
+// List returns commands a commander runs. It is empty for commanders not running commands,
+// e.g. the fake one.
+func (srv *CommandAPIService) List() (*api.ListCommandsResponse, error) {
+	infos := make([]*api.CommandInfo, 0)
+
+	if catalog, ok := srv.Commander.(commanders.CommandCatalog); ok {
+		for name, execInfo := range catalog.Commands() {
+			path, _ := exec.LookPath(execInfo.Program)
+			infos = append(infos, &api.CommandInfo{
+				Name:      name,
+				JobTypes:  commandJobTypes[name],
+				Program:   execInfo.Program,
+				Path:      path,
+				Arguments: execInfo.Arguments,
+				Vars:      execInfo.Vars,
+			})
+		}
+		sort.Slice(infos, func(i, j int) bool {
+			return infos[i].Name < infos[j].Name
+		})
+	}
+
+	return &api.ListCommandsResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Commands: infos,
+	}, nil
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"github.com/romiras/go-openvz-api/api"
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+// mergeParameters applies a patch to parameters of a container, or replaces them with it.
+// It returns the resulting parameters and ones to be set on a host: the changed ones along
+// with their sizes or units. Removed parameters are only forgotten, since ct-set cannot unset them.
+func mergeParameters(current map[string]string, patch api.ContainerParametersPatch, replace bool) (openvzcmd.Options, openvzcmd.Options) {
+	merged := make(openvzcmd.Options)
+	if !replace {
+		for k, v := range current {
+			merged[k] = v
+		}
+	}
+
+	for k, v := range patch {
+		if v == nil {
+			delete(merged, k)
+			if unitsParam, ok := api.UnitsParameters[k]; ok {
+				delete(merged, unitsParam)
+			}
+			continue
+		}
+		merged[k] = *v
+	}
+
+	for param, unitsParam := range api.UnitsParameters {
+		if _, ok := merged[param]; !ok {
+			delete(merged, unitsParam)
+		} else if _, ok := merged[unitsParam]; !ok {
+			merged[unitsParam] = api.DefaultSizeUnits
+		}
+	}
+
+	changed := make(openvzcmd.Options)
+	for k, v := range merged {
+		if cv, ok := current[k]; !ok || cv != v {
+			changed[k] = v
+		}
+	}
+	for param, unitsParam := range api.UnitsParameters {
+		_, paramChanged := changed[param]
+		_, unitsChanged := changed[unitsParam]
+		if paramChanged || unitsChanged {
+			changed[param] = merged[param]
+			changed[unitsParam] = merged[unitsParam]
+		}
+	}
+
+	return merged, changed
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/romiras/go-openvz-api/api"
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestMergeParameters(t *testing.T) {
+	current := map[string]string{"hostname": "web", "cpus": "2", "memsize": "512", "memsize_units": "M"}
+
+	tests := []struct {
+		name        string
+		patch       api.ContainerParametersPatch
+		replace     bool
+		wantMerged  openvzcmd.Options
+		wantChanged openvzcmd.Options
+	}{
+		{
+			name:        "merge",
+			patch:       api.ContainerParametersPatch{"cpus": strPtr("4"), "hostname": nil},
+			wantMerged:  openvzcmd.Options{"cpus": "4", "memsize": "512", "memsize_units": "M"},
+			wantChanged: openvzcmd.Options{"cpus": "4"},
+		},
+		{
+			name:        "unchanged",
+			patch:       api.ContainerParametersPatch{"cpus": strPtr("2")},
+			wantMerged:  openvzcmd.Options{"hostname": "web", "cpus": "2", "memsize": "512", "memsize_units": "M"},
+			wantChanged: openvzcmd.Options{},
+		},
+		{
+			name:        "units are set along with a size",
+			patch:       api.ContainerParametersPatch{"memsize_units": strPtr("G"), "memsize": strPtr("512")},
+			wantMerged:  openvzcmd.Options{"hostname": "web", "cpus": "2", "memsize": "512", "memsize_units": "G"},
+			wantChanged: openvzcmd.Options{"memsize": "512", "memsize_units": "G"},
+		},
+		{
+			name:        "removed size takes its units",
+			patch:       api.ContainerParametersPatch{"memsize": nil},
+			wantMerged:  openvzcmd.Options{"hostname": "web", "cpus": "2"},
+			wantChanged: openvzcmd.Options{},
+		},
+		{
+			name:        "replace",
+			patch:       api.ContainerParametersPatch{"size": strPtr("10")},
+			replace:     true,
+			wantMerged:  openvzcmd.Options{"size": "10", "size_units": "M"},
+			wantChanged: openvzcmd.Options{"size": "10", "size_units": "M"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, changed := mergeParameters(current, tt.patch, tt.replace)
+			if !reflect.DeepEqual(merged, tt.wantMerged) {
+				t.Errorf("merged = %v, want %v", merged, tt.wantMerged)
+			}
+			if !reflect.DeepEqual(changed, tt.wantChanged) {
+				t.Errorf("changed = %v, want %v", changed, tt.wantChanged)
+			}
+		})
+	}
+}
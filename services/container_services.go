@@ -17,56 +17,105 @@
 package services
 
 import (
-	"database/sql"
 	"encoding/json"
-	"errors"
-	"log"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 
 	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
 	"github.com/romiras/go-openvz-api/models"
-
-	openvzcmd "github.com/romiras/go-openvz-cmd"
+	"github.com/romiras/go-openvz-api/repositories"
 )
 
 type (
 	DBConnection = *sqlx.DB
 
 	ContainerAPIService struct {
-		DB        DBConnection
-		Commander *openvzcmd.POCCommanderStub
+		ContainerRepo repositories.ContainerRepository
+		TemplateRepo  repositories.TemplateRepository
+		Commander     commanders.Commander
+		Jobs          *JobService
 	}
 )
 
-func NewContainerAPIService(db DBConnection, cmd *openvzcmd.POCCommanderStub) *ContainerAPIService {
+func NewContainerAPIService(db DBConnection, cmd commanders.Commander, jobs *JobService) *ContainerAPIService {
 	return &ContainerAPIService{
-		DB:        db,
-		Commander: cmd,
+		ContainerRepo: repositories.NewContainerRepository(db),
+		TemplateRepo:  repositories.NewTemplateRepository(db),
+		Commander:     cmd,
+		Jobs:          jobs,
 	}
 }
 
-func (srv *ContainerAPIService) Create(req *api.AddContainerRequest) (*api.AddContainerResponse, error) {
-	if srv.hasContainerWithName(req.Name) {
-		return nil, errors.New("duplicate-name")
+// Create enqueues a job creating a container. A dry run only renders commands of the job.
+func (srv *ContainerAPIService) Create(req *api.AddContainerRequest, idempotencyKey string, dryRun bool) (*api.AddContainerResponse, error) {
+	hashParts := []string{AddContainerType, req.Name, req.OSTemplate}
+	parameters, _ := mergeParameters(nil, req.Patch, true)
+	if len(parameters) > 0 {
+		parametersJSON, err := json.Marshal(parameters)
+		if err != nil {
+			return nil, err
+		}
+		hashParts = append(hashParts, string(parametersJSON))
 	}
 
-	payload, err := json.Marshal(AddContainerJob{
+	payload := AddContainerJob{
+		ID:         uuid.New().String(),
 		Name:       req.Name,
 		OSTemplate: req.OSTemplate,
-	})
-	if err != nil {
-		log.Fatal(err.Error())
+		Parameters: parameters,
+	}
+	opts := EnqueueOptions{
+		IdempotencyKey: idempotencyKey,
+		RequestHash:    requestHash(hashParts...),
+		ReservedName:   req.Name,
 	}
 
-	jobID := uuid.New().String()
+	if dryRun || srv.Jobs.DryRun {
+		if err := srv.Jobs.checkNameIsFree(req.Name); err != nil {
+			return nil, err
+		}
+		if err := checkTemplate(srv.TemplateRepo, req.OSTemplate); err != nil {
+			return nil, err
+		}
+
+		jobID, commands, err := srv.Jobs.dryRun(AddContainerType, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		return &api.AddContainerResponse{
+			ApiResponse: api.ApiResponse{
+				Code:    0,
+				Message: "success",
+			},
+			JobID:    jobID,
+			DryRun:   true,
+			Commands: commands,
+		}, nil
+	}
 
-	_, err = srv.DB.Exec("INSERT INTO jobs (id, status, payload, type) VALUES (?, ?, ?, ?)", jobID, models.PENDING, payload, AddContainerType)
+	jobID, err := srv.Jobs.Replay(opts)
 	if err != nil {
 		return nil, err
 	}
 
+	if jobID == "" {
+		if err := srv.Jobs.checkNameIsFree(req.Name); err != nil {
+			return nil, err
+		}
+		if err := checkTemplate(srv.TemplateRepo, req.OSTemplate); err != nil {
+			return nil, err
+		}
+
+		jobID, err = srv.Jobs.Enqueue(AddContainerType, payload, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &api.AddContainerResponse{
 		ApiResponse: api.ApiResponse{
 			Code:    0,
@@ -76,11 +125,31 @@ func (srv *ContainerAPIService) Create(req *api.AddContainerRequest) (*api.AddCo
 	}, nil
 }
 
-func (srv *ContainerAPIService) Update(req *api.UpdateContainerRequest) (*api.ApiResponse, error) {
-	jsonData, err := json.Marshal(req.Parameters)
-	if err != nil {
-		log.Fatal(err.Error())
-		return nil, err
+// Clone enqueues a job copying a stopped container to a new one. A dry run only renders commands of the job.
+func (srv *ContainerAPIService) Clone(req *api.CloneContainerRequest, idempotencyKey string, dryRun bool) (*api.JobResponse, error) {
+	hashParts := []string{CloneContainerType, req.ID, req.Name}
+	if len(req.Patch) > 0 {
+		patchJSON, err := json.Marshal(req.Patch)
+		if err != nil {
+			return nil, err
+		}
+		hashParts = append(hashParts, string(patchJSON))
+	}
+
+	opts := EnqueueOptions{
+		IdempotencyKey: idempotencyKey,
+		RequestHash:    requestHash(hashParts...),
+		ReservedName:   req.Name,
+	}
+
+	if !dryRun && !srv.Jobs.DryRun {
+		jobID, err := srv.Jobs.Replay(opts)
+		if err != nil {
+			return nil, err
+		}
+		if jobID != "" {
+			return jobResponse(jobID), nil
+		}
 	}
 
 	container, err := srv.findContainerByID(req.ID)
@@ -88,57 +157,117 @@ func (srv *ContainerAPIService) Update(req *api.UpdateContainerRequest) (*api.Ap
 		return nil, err
 	}
 
-	err = srv.Commander.SetContainerParameters(container.Name, req.Parameters)
-	if err != nil {
+	if !canTransition(CloneContainerType, container.State) {
+		return nil, ErrInvalidContainerState
+	}
+
+	if err := srv.Jobs.checkNameIsFree(req.Name); err != nil {
 		return nil, err
 	}
 
-	statement, err := srv.DB.Prepare("UPDATE containers SET parameters=? WHERE id=?")
-	if err != nil {
-		log.Fatal(err.Error())
+	parameters, overrides := mergeParameters(container.Parameters, req.Patch, false)
+
+	return srv.Jobs.submit(CloneContainerType, CloneContainerJob{
+		ContainerJob: ContainerJob{
+			ID:   container.ID,
+			Name: container.Name,
+		},
+		CloneName:  req.Name,
+		OSTemplate: container.OSTemplate,
+		Parameters: parameters,
+		Overrides:  overrides,
+	}, opts, dryRun)
+}
+
+func (srv *ContainerAPIService) Action(id, action, idempotencyKey string, dryRun bool) (*api.JobResponse, error) {
+	jobType := ContainerActionTypes[action]
+	opts := EnqueueOptions{
+		IdempotencyKey: idempotencyKey,
+		RequestHash:    requestHash(jobType, id),
 	}
 
-	_, err = statement.Exec(jsonData, req.ID)
+	// A repeated request gets its job even if the container has changed its state since
+	if !dryRun && !srv.Jobs.DryRun {
+		jobID, err := srv.Jobs.Replay(opts)
+		if err != nil {
+			return nil, err
+		}
+		if jobID != "" {
+			return jobResponse(jobID), nil
+		}
+	}
+
+	container, err := srv.findContainerByID(id)
 	if err != nil {
-		log.Fatal(err.Error())
+		return nil, err
 	}
 
-	return &api.ApiResponse{
-		Code:    0,
-		Message: "success",
-	}, nil
+	if !canTransition(jobType, container.State) {
+		return nil, ErrInvalidContainerState
+	}
+
+	return srv.Jobs.submit(jobType, ContainerJob{
+		ID:   container.ID,
+		Name: container.Name,
+	}, opts, dryRun)
 }
 
-func (srv *ContainerAPIService) findContainerByID(id string) (*models.Container, error) {
-	var container models.Container
+func jobResponse(jobID string) *api.JobResponse {
+	return &api.JobResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		JobID: jobID,
+	}
+}
 
-	err := srv.DB.Get(&container, "SELECT * FROM containers WHERE id=? LIMIT 1", id)
-	switch {
-	case err == sql.ErrNoRows:
-		log.Printf("No rows.")
+func (srv *ContainerAPIService) Update(req *api.UpdateContainerRequest, idempotencyKey string, dryRun bool) (*api.JobResponse, error) {
+	patchJSON, err := json.Marshal(req.Patch)
+	if err != nil {
 		return nil, err
-	case err != nil:
-		log.Fatal(err)
 	}
-	err = container.UnmarshalParametersDB()
+	opts := EnqueueOptions{
+		IdempotencyKey: idempotencyKey,
+		RequestHash:    requestHash(UpdateContainerType, req.ID, strconv.FormatBool(req.Replace), string(patchJSON)),
+	}
+
+	if !dryRun && !srv.Jobs.DryRun {
+		jobID, err := srv.Jobs.Replay(opts)
+		if err != nil {
+			return nil, err
+		}
+		if jobID != "" {
+			return jobResponse(jobID), nil
+		}
+	}
+
+	container, err := srv.findContainerByID(req.ID)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	if !canTransition(UpdateContainerType, container.State) {
+		return nil, ErrInvalidContainerState
 	}
 
-	return &container, nil
+	return srv.Jobs.submit(UpdateContainerType, UpdateContainerJob{
+		ContainerJob: ContainerJob{
+			ID:   container.ID,
+			Name: container.Name,
+		},
+		Parameters: req.Patch,
+		Replace:    req.Replace,
+	}, opts, dryRun)
 }
 
-func (srv *ContainerAPIService) hasContainerWithName(name string) bool {
-	var i int
-	err := srv.DB.DB.QueryRow("SELECT 1 FROM containers WHERE name=? LIMIT 1", name).Scan(&i)
-	switch {
-	case err == sql.ErrNoRows:
-		return false
-	case err != nil:
-		log.Fatal(err)
+func (srv *ContainerAPIService) findContainerByID(id string) (*models.Container, error) {
+	container, err := srv.ContainerRepo.FindByID(id)
+	if err != nil {
+		return nil, err
 	}
 
-	return true
+	return container, nil
 }
 
 func (srv *ContainerAPIService) GetById(id string) (*api.GetContainerByIdResponse, error) {
@@ -157,21 +286,9 @@ func (srv *ContainerAPIService) GetById(id string) (*api.GetContainerByIdRespons
 }
 
 func (srv *ContainerAPIService) List() (*api.ListContainersResponse, error) {
-	containers := make([]*models.Container, 0)
-
-	err := srv.DB.Select(&containers, "SELECT * FROM containers LIMIT 1000")
-	switch {
-	case err == sql.ErrNoRows:
-		log.Printf("No rows.")
-	case err != nil:
-		log.Fatal(err)
-	}
-
-	for _, container := range containers {
-		err = container.UnmarshalParametersDB()
-		if err != nil {
-			log.Fatal(err)
-		}
+	containers, err := srv.ContainerRepo.List(api.MaxListLimit)
+	if err != nil {
+		return nil, err
 	}
 
 	return &api.ListContainersResponse{
@@ -183,24 +300,34 @@ func (srv *ContainerAPIService) List() (*api.ListContainersResponse, error) {
 	}, nil
 }
 
-func (srv *ContainerAPIService) Delete(id string) (*api.ApiResponse, error) {
-	container, err := srv.findContainerByID(id)
-	if err != nil {
-		return nil, err
+func (srv *ContainerAPIService) Delete(id, idempotencyKey string, dryRun bool) (*api.JobResponse, error) {
+	opts := EnqueueOptions{
+		IdempotencyKey: idempotencyKey,
+		RequestHash:    requestHash(DeleteContainerType, id),
 	}
 
-	err = srv.Commander.DeleteContainer(container.Name)
+	// A repeated request gets its job even if the container is deleted since
+	if !dryRun && !srv.Jobs.DryRun {
+		jobID, err := srv.Jobs.Replay(opts)
+		if err != nil {
+			return nil, err
+		}
+		if jobID != "" {
+			return jobResponse(jobID), nil
+		}
+	}
+
+	container, err := srv.findContainerByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = srv.DB.Exec("DELETE FROM containers WHERE id=?", id)
-	if err != nil {
-		log.Fatal(err.Error())
+	if !canTransition(DeleteContainerType, container.State) {
+		return nil, ErrInvalidContainerState
 	}
 
-	return &api.ApiResponse{
-		Code:    0,
-		Message: "success",
-	}, nil
+	return srv.Jobs.submit(DeleteContainerType, ContainerJob{
+		ID:   container.ID,
+		Name: container.Name,
+	}, opts, dryRun)
 }
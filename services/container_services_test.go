@@ -0,0 +1,403 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+// failingSetCommander is a fake host failing to set parameters of containers
+type failingSetCommander struct {
+	*commanders.FakeCommander
+}
+
+func (cmd failingSetCommander) SetContainerParameters(ctx context.Context, name string, params openvzcmd.Options) error {
+	return commanders.Permanent(errors.New("invalid parameters"))
+}
+
+func newTestContainerService(t *testing.T) (*ContainerAPIService, *JobService, *commanders.FakeCommander) {
+	t.Helper()
+
+	db := newTestDB(t)
+	cmd := commanders.NewFakeCommander()
+
+	jobs := NewJobService(db, cmd, events.NewBus())
+
+	return NewContainerAPIService(db, cmd, jobs), jobs, cmd
+}
+
+// runTestJobs runs jobs until there are none left
+func runTestJobs(t *testing.T, j *JobService) {
+	t.Helper()
+
+	for {
+		picked, err := j.consumeJob()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !picked {
+			return
+		}
+	}
+}
+
+// assertJobDone runs pending jobs and checks that a job is done
+func assertJobDone(t *testing.T, j *JobService, jobID string) {
+	t.Helper()
+
+	runTestJobs(t, j)
+
+	job, err := j.JobRepo.FindByID(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.DONE {
+		t.Fatalf("Job %s status = %v, last error %q, want done", job.Type, job.Status, job.LastError.String)
+	}
+}
+
+// assertContainer checks a state of a container in the database
+func assertContainer(t *testing.T, srv *ContainerAPIService, id string, state models.ContainerState) *models.Container {
+	t.Helper()
+
+	resp, err := srv.GetById(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Container.State != state {
+		t.Errorf("State = %s, want %s", resp.Container.State, state)
+	}
+
+	return resp.Container
+}
+
+// createTestContainer creates a container with given parameters and returns its ID
+func createTestContainer(t *testing.T, srv *ContainerAPIService, jobs *JobService, name string, parameters map[string]json.RawMessage) string {
+	t.Helper()
+
+	req := &api.AddContainerRequest{Name: name, OSTemplate: "centos-7", Parameters: parameters}
+	if err := api.ValidateAddContainerRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	created, err := srv.Create(req, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, created.JobID)
+
+	job, err := jobs.JobRepo.FindByID(created.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return job.EntityID.String
+}
+
+func TestContainerLifecycle(t *testing.T) {
+	srv, jobs, cmd := newTestContainerService(t)
+
+	created, err := srv.Create(&api.AddContainerRequest{Name: "web", OSTemplate: "centos-7"}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, created.JobID)
+
+	list, err := srv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Containers) != 1 {
+		t.Fatalf("Containers = %v, want web", list.Containers)
+	}
+	id := list.Containers[0].ID
+	assertContainer(t, srv, id, models.STOPPED)
+
+	for _, step := range []struct {
+		action string
+		state  models.ContainerState
+	}{
+		{api.StartAction, models.RUNNING},
+		{api.SuspendAction, models.SUSPENDED},
+		{api.ResumeAction, models.RUNNING},
+		{api.RestartAction, models.RUNNING},
+		{api.StopAction, models.STOPPED},
+	} {
+		resp, err := srv.Action(id, step.action, "", false)
+		if err != nil {
+			t.Fatalf("Action(%s) = %v", step.action, err)
+		}
+		assertJobDone(t, jobs, resp.JobID)
+		assertContainer(t, srv, id, step.state)
+	}
+
+	req := &api.UpdateContainerRequest{ID: id, Parameters: map[string]json.RawMessage{"cpus": json.RawMessage(`2`)}}
+	if err := api.ValidateUpdateContainerRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Update(req, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, resp.JobID)
+	container := assertContainer(t, srv, id, models.STOPPED)
+	if container.Parameters["cpus"] != "2" {
+		t.Errorf("Parameters = %v", container.Parameters)
+	}
+
+	if resp, err = srv.Delete(id, "", false); err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, resp.JobID)
+	if _, err := srv.GetById(id); err == nil {
+		t.Error("Deleted container is found in the database")
+	}
+	if err := cmd.StartContainer(context.Background(), "web"); err == nil {
+		t.Error("Deleted container is found on a host")
+	}
+}
+
+func TestContainerActionInInvalidState(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+
+	created, err := srv.Create(&api.AddContainerRequest{Name: "web", OSTemplate: "centos-7"}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, created.JobID)
+
+	list, err := srv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := list.Containers[0].ID
+
+	for _, action := range []string{api.StopAction, api.RestartAction, api.SuspendAction, api.ResumeAction} {
+		if _, err := srv.Action(id, action, "", false); err != ErrInvalidContainerState {
+			t.Errorf("Action(%s) of a stopped container = %v, want %v", action, err, ErrInvalidContainerState)
+		}
+	}
+}
+
+func TestFailedContainerActionKeepsState(t *testing.T) {
+	srv, jobs, cmd := newTestContainerService(t)
+	id := createTestContainer(t, srv, jobs, "web", nil)
+
+	// A container started on a host behind the API fails to start again, but is not broken
+	if err := cmd.StartContainer(context.Background(), "web"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Action(id, api.StartAction, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestJobs(t, jobs)
+	if status, _ := findTestJob(t, jobs, resp.JobID); status != models.FAILED {
+		t.Errorf("Job status = %v, want failed", status)
+	}
+	assertContainer(t, srv, id, models.RUNNING)
+
+	resp, err = srv.Action(id, api.StopAction, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := jobs.JobRepo.FindByID(resp.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := jobs.runContainerAction(ctx, job); err == nil {
+		t.Fatal("Cancelled action succeeded")
+	}
+	assertContainer(t, srv, id, models.RUNNING)
+}
+
+func TestContainerDatabaseErrorsAreReturned(t *testing.T) {
+	db := newTestDB(t)
+	cmd := commanders.NewFakeCommander()
+	srv := NewContainerAPIService(db, cmd, NewJobService(db, cmd, events.NewBus()))
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := srv.GetById("c1"); err == nil || err == sql.ErrNoRows {
+		t.Errorf("GetById() = %v, want an error of the database", err)
+	}
+	if _, err := srv.Delete("c1", "", false); err == nil || err == sql.ErrNoRows {
+		t.Errorf("Delete() = %v, want an error of the database", err)
+	}
+	if _, err := srv.List(); err == nil {
+		t.Error("List() succeeded")
+	}
+}
+
+func TestCreateContainerWithTakenName(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+
+	created, err := srv.Create(&api.AddContainerRequest{Name: "web", OSTemplate: "centos-7"}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, created.JobID)
+
+	if _, err := srv.Create(&api.AddContainerRequest{Name: "web", OSTemplate: "centos-7"}, "", false); err == nil {
+		t.Error("Create() of an existing container succeeded")
+	}
+}
+
+func TestCreateContainerWithParameters(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+
+	req := &api.AddContainerRequest{
+		Name:       "web",
+		OSTemplate: "centos-7",
+		Parameters: map[string]json.RawMessage{"cpus": json.RawMessage(`2`), "memsize": json.RawMessage(`512`)},
+	}
+	if err := api.ValidateAddContainerRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	created, err := srv.Create(req, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, created.JobID)
+
+	list, err := srv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := assertContainer(t, srv, list.Containers[0].ID, models.STOPPED)
+	want := map[string]string{"cpus": "2", "memsize": "512", "memsize_units": "M"}
+	for k, v := range want {
+		if container.Parameters[k] != v {
+			t.Errorf("Parameters = %v, want %v", container.Parameters, want)
+		}
+	}
+
+	logs, err := jobs.JobRepo.ListLogs(created.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || logs[1].Command != "fake set web cpus=2 memsize=512 memsize_units=M" {
+		t.Errorf("Logs = %+v, want parameters set on a host", logs)
+	}
+}
+
+func TestCreateContainerRolledBack(t *testing.T) {
+	srv, jobs, cmd := newTestContainerService(t)
+	jobs.Commander = failingSetCommander{cmd}
+
+	req := &api.AddContainerRequest{
+		Name:       "web",
+		OSTemplate: "centos-7",
+		Parameters: map[string]json.RawMessage{"cpus": json.RawMessage(`2`)},
+	}
+	if err := api.ValidateAddContainerRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	created, err := srv.Create(req, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestJobs(t, jobs)
+
+	if status, descr := findTestJob(t, jobs, created.JobID); status != models.FAILED || descr != "invalid parameters" {
+		t.Errorf("Job status = %v, error %q, want failed", status, descr)
+	}
+	list, err := srv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Containers) != 0 {
+		t.Errorf("Containers = %v, want none", list.Containers)
+	}
+	if err := cmd.StartContainer(context.Background(), "web"); err == nil {
+		t.Error("Rolled back container is found on a host")
+	}
+}
+
+func TestCloneContainerWithOverrides(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+	id := createTestContainer(t, srv, jobs, "web", map[string]json.RawMessage{
+		"hostname": json.RawMessage(`"web.example.com"`),
+		"cpus":     json.RawMessage(`2`),
+	})
+
+	req := &api.CloneContainerRequest{ID: id, Name: "web2", Parameters: map[string]json.RawMessage{"hostname": json.RawMessage(`"web2.example.com"`)}}
+	if err := api.ValidateCloneContainerRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Clone(req, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, resp.JobID)
+
+	logs, err := jobs.JobRepo.ListLogs(resp.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 2 || logs[0].Command != "fake clone web web2" || logs[1].Command != "fake set web2 hostname=web2.example.com" {
+		t.Errorf("Logs = %+v, want only overrides set on a clone", logs)
+	}
+
+	job, err := jobs.JobRepo.FindByID(resp.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone := assertContainer(t, srv, job.EntityID.String, models.STOPPED)
+	if clone.Name != "web2" || clone.OSTemplate != "centos-7" || clone.Parameters["hostname"] != "web2.example.com" || clone.Parameters["cpus"] != "2" {
+		t.Errorf("Clone = %+v, want parameters of web with overrides", clone)
+	}
+	original := assertContainer(t, srv, id, models.STOPPED)
+	if original.Parameters["hostname"] != "web.example.com" {
+		t.Errorf("Parameters of the original = %v", original.Parameters)
+	}
+
+	if _, err := srv.Clone(req, "", false); err != ErrDuplicateName {
+		t.Errorf("Clone() to a taken name = %v, want %v", err, ErrDuplicateName)
+	}
+}
+
+func TestCloneRunningContainer(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+	id := createTestContainer(t, srv, jobs, "web", nil)
+
+	start, err := srv.Action(id, api.StartAction, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, start.JobID)
+
+	req := &api.CloneContainerRequest{ID: id, Name: "web2"}
+	if err := api.ValidateCloneContainerRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Clone(req, "", false); err != ErrInvalidContainerState {
+		t.Errorf("Clone() of a running container = %v, want %v", err, ErrInvalidContainerState)
+	}
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/romiras/go-openvz-api/migrations"
+)
+
+// newTestDB returns an SQLite database with all migrations applied in a temporary directory
+func newTestDB(t *testing.T) DBConnection {
+	t.Helper()
+
+	db := sqlx.MustConnect("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrations.NewMigrator(db, "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migrator.Up(0); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
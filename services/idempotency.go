@@ -0,0 +1,66 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/romiras/go-openvz-api/repositories"
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when an idempotency key is reused for a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency-key-reused")
+
+	// ErrDuplicateName is returned when a container name is taken or reserved by a pending job
+	ErrDuplicateName = errors.New("duplicate-name")
+)
+
+// EnqueueOptions are optional attributes of an enqueued job
+type EnqueueOptions = repositories.JobOptions
+
+// requestHash returns a hash of parts of a request identifying it
+func requestHash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Replay returns ID of a job enqueued before by a request with the same idempotency key,
+// or an empty string if there is no such job.
+func (j *JobService) Replay(opts EnqueueOptions) (string, error) {
+	if opts.IdempotencyKey == "" {
+		return "", nil
+	}
+
+	jobID, hash, err := j.JobRepo.FindByIdempotencyKey(opts.IdempotencyKey)
+	switch {
+	case err == sql.ErrNoRows:
+		return "", nil
+	case err != nil:
+		return "", err
+	}
+
+	if hash != opts.RequestHash {
+		return "", ErrIdempotencyKeyReused
+	}
+
+	return jobID, nil
+}
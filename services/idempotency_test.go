@@ -0,0 +1,148 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func TestCreateWithIdempotencyKeyIsReplayed(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+
+	req := &api.AddContainerRequest{Name: "web", OSTemplate: "centos-7"}
+	first, err := srv.Create(req, "key-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A repetition gets the same job, even after it is done and the name is taken
+	again, err := srv.Create(req, "key-1", false)
+	if err != nil || again.JobID != first.JobID {
+		t.Fatalf("Create() again = %v, %v, want job %s", again, err, first.JobID)
+	}
+	assertJobDone(t, jobs, first.JobID)
+	if again, err = srv.Create(req, "key-1", false); err != nil || again.JobID != first.JobID {
+		t.Fatalf("Create() of a done job = %v, %v, want job %s", again, err, first.JobID)
+	}
+
+	// The key cannot be reused for another request
+	other := &api.AddContainerRequest{Name: "db", OSTemplate: "centos-7"}
+	if _, err := srv.Create(other, "key-1", false); err != ErrIdempotencyKeyReused {
+		t.Errorf("Create() of another container = %v, want %v", err, ErrIdempotencyKeyReused)
+	}
+	if _, err := srv.Create(req, "key-2", false); err != ErrDuplicateName {
+		t.Errorf("Create() with another key = %v, want %v", err, ErrDuplicateName)
+	}
+}
+
+func TestActionWithIdempotencyKeyIsReplayed(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+
+	created, err := srv.Create(&api.AddContainerRequest{Name: "web", OSTemplate: "centos-7"}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, created.JobID)
+	list, err := srv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := list.Containers[0].ID
+
+	start, err := srv.Action(id, api.StartAction, "start-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, start.JobID)
+
+	// Starting a running container is invalid, but a repeated request gets its job
+	again, err := srv.Action(id, api.StartAction, "start-1", false)
+	if err != nil || again.JobID != start.JobID {
+		t.Errorf("Action() again = %v, %v, want job %s", again, err, start.JobID)
+	}
+	if _, err := srv.Action(id, api.StopAction, "start-1", false); err != ErrIdempotencyKeyReused {
+		t.Errorf("Action() of another action = %v, want %v", err, ErrIdempotencyKeyReused)
+	}
+}
+
+func TestNameIsReservedWhilePending(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+
+	req := &api.AddContainerRequest{Name: "web", OSTemplate: "centos-7"}
+	created, err := srv.Create(req, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Create(req, "", false); err != ErrDuplicateName {
+		t.Errorf("Create() of a pending name = %v, want %v", err, ErrDuplicateName)
+	}
+
+	// The database rejects a second reservation of a name even if the check is raced
+	if _, err := jobs.Enqueue(AddContainerType, AddContainerJob{Name: "web"}, EnqueueOptions{ReservedName: "web"}); err != ErrDuplicateName {
+		t.Errorf("Enqueue() of a reserved name = %v, want %v", err, ErrDuplicateName)
+	}
+
+	// A name of a cancelled job is free again
+	if err := jobs.Cancel(created.JobID); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := findTestJob(t, jobs, created.JobID); status != models.CANCELLED {
+		t.Fatalf("Status = %v, want cancelled", status)
+	}
+	if _, err := srv.Create(req, "", false); err != nil {
+		t.Errorf("Create() after cancellation = %v", err)
+	}
+}
+
+func TestUpdateAndDeleteWithIdempotencyKeyAreReplayed(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+	id := createTestContainer(t, srv, jobs, "web", nil)
+
+	req := &api.UpdateContainerRequest{ID: id, Parameters: map[string]json.RawMessage{"cpus": json.RawMessage(`2`)}}
+	if err := api.ValidateUpdateContainerRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	update, err := srv.Update(req, "update-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, update.JobID)
+	if again, err := srv.Update(req, "update-1", false); err != nil || again.JobID != update.JobID {
+		t.Errorf("Update() again = %v, %v, want job %s", again, err, update.JobID)
+	}
+
+	other := &api.UpdateContainerRequest{ID: id, Parameters: map[string]json.RawMessage{"cpus": json.RawMessage(`4`)}}
+	if err := api.ValidateUpdateContainerRequest(other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Update(other, "update-1", false); err != ErrIdempotencyKeyReused {
+		t.Errorf("Update() of other parameters = %v, want %v", err, ErrIdempotencyKeyReused)
+	}
+
+	del, err := srv.Delete(id, "delete-1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, del.JobID)
+	// The container is gone, yet a repetition gets its job
+	if again, err := srv.Delete(id, "delete-1", false); err != nil || again.JobID != del.JobID {
+		t.Errorf("Delete() again = %v, %v, want job %s", again, err, del.JobID)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"github.com/romiras/go-openvz-api/models"
+)
+
+// abandonJob moves a snapshot or a backup a job operates on out of a transient state, when the job
+// ends without its handler doing so: it is cancelled while pending, its lease expires for good,
+// or it is not enqueued at all. Artifacts which are kept on a host become ready, others failed.
+func (j *JobService) abandonJob(jobType string, payload []byte) {
+	var err error
+
+	switch jobType {
+	case CreateSnapshotType, DeleteSnapshotType, RevertSnapshotType:
+		err = j.abandonSnapshotJob(payload)
+	case CreateBackupType, DeleteBackupType, RestoreBackupType:
+		err = j.abandonBackupJob(jobType, payload)
+	}
+	if err != nil {
+		log.Printf("Artifact of abandoned %s job is left as it is: %s", jobType, err.Error())
+	}
+}
+
+func (j *JobService) abandonSnapshotJob(payload []byte) error {
+	var job SnapshotJob
+
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := j.SnapshotRepo.FindByID(job.ID, job.SnapshotID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	state := models.SnapshotFailed
+	if snapshot.HostID.Valid {
+		state = models.SnapshotReady
+	}
+	_, err = j.SnapshotRepo.Transition(snapshot.ID, state, models.SnapshotCreating, models.SnapshotDeleting, models.SnapshotReverting)
+
+	return err
+}
+
+// abandonBackupJob also leaves a container a backup is being restored into in error,
+// as it may be deleted from a host already
+func (j *JobService) abandonBackupJob(jobType string, payload []byte) error {
+	var job RestoreBackupJob
+
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return err
+	}
+
+	backup, err := j.BackupRepo.FindByID(job.BackupID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	state := models.BackupFailed
+	if backup.HostID.Valid {
+		state = models.BackupReady
+	}
+	_, err = j.BackupRepo.Transition(backup.ID, state, models.BackupCreating, models.BackupDeleting, models.BackupRestoring)
+	if err != nil || jobType != RestoreBackupType {
+		return err
+	}
+
+	id := job.ID
+	if job.NewContainer {
+		id, err = j.ContainerRepo.FindIDByName(job.Name, models.CREATING)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	containerState, err := j.ContainerRepo.GetState(id)
+	if err != nil || containerState != models.CREATING {
+		return err
+	}
+
+	return j.setContainerState(id, models.ERROR)
+}
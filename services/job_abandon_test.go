@@ -0,0 +1,142 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+// addTestBackup adds a backup which is ready to the database
+func addTestBackup(t *testing.T, j *JobService, id, containerID string) {
+	t.Helper()
+
+	err := j.BackupRepo.Create(&models.Backup{
+		ID:            id,
+		ContainerID:   containerID,
+		ContainerName: "ct1",
+		OSTemplate:    "centos-7",
+		State:         models.BackupCreating,
+		CreatedAt:     time.Now().UTC(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = j.BackupRepo.MarkReady(&models.Backup{
+		ID:      id,
+		HostID:  sql.NullString{String: id, Valid: true},
+		Archive: sql.NullString{String: "/vz/dump/" + id, Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertBackupState(t *testing.T, j *JobService, id, want string) {
+	t.Helper()
+
+	backup, err := j.BackupRepo.FindByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.State != want {
+		t.Errorf("Backup state = %s, want %s", backup.State, want)
+	}
+}
+
+func TestCancelledSnapshotJobFailsSnapshot(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, commanders.NewFakeCommander(), events.NewBus())
+	srv := NewSnapshotAPIService(db, j)
+	addTestContainer(t, srv.ContainerRepo, "c1", "ct1", map[string]string{})
+
+	resp, err := srv.Create(&api.AddSnapshotRequest{ContainerID: "c1", Name: "before-upgrade"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Cancel(resp.JobID); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := j.SnapshotRepo.FindByID("c1", resp.Snapshot.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.State != models.SnapshotFailed {
+		t.Errorf("Snapshot state = %s, want %s", snapshot.State, models.SnapshotFailed)
+	}
+}
+
+func TestCancelledDeleteBackupJobKeepsBackup(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, commanders.NewFakeCommander(), events.NewBus())
+	srv := NewBackupAPIService(db, j)
+	addTestContainer(t, srv.ContainerRepo, "c1", "ct1", map[string]string{})
+	addTestBackup(t, j, "b1", "c1")
+
+	resp, err := srv.Delete("b1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBackupState(t, j, "b1", models.BackupDeleting)
+
+	if err := j.Cancel(resp.JobID); err != nil {
+		t.Fatal(err)
+	}
+	assertBackupState(t, j, "b1", models.BackupReady)
+}
+
+func TestReapedRestoreJobLeavesContainerInError(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, commanders.NewFakeCommander(), events.NewBus())
+	j.DefaultRetryPolicy.MaxAttempts = 1
+	srv := NewBackupAPIService(db, j)
+	addTestContainer(t, srv.ContainerRepo, "c1", "ct1", map[string]string{})
+	addTestBackup(t, j, "b1", "c1")
+
+	if _, err := srv.Restore(&api.RestoreBackupRequest{ID: "b1", ContainerID: "c1"}, false); err != nil {
+		t.Fatal(err)
+	}
+	job, err := j.claimJob()
+	if err != nil || job == nil {
+		t.Fatalf("claimJob() = %v, %v", job, err)
+	}
+	// The worker crashes after the container is deleted from a host
+	if err := srv.ContainerRepo.SetState("c1", models.CREATING); err != nil {
+		t.Fatal(err)
+	}
+
+	j.LeaseDuration = -time.Second
+	if err := j.reapExpiredJobs(); err != nil {
+		t.Fatal(err)
+	}
+
+	assertBackupState(t, j, "b1", models.BackupReady)
+	state, err := srv.ContainerRepo.GetState("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != models.ERROR {
+		t.Errorf("Container state = %s, want %s", state, models.ERROR)
+	}
+}
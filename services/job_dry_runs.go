@@ -0,0 +1,180 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+type (
+	// dryRunRecorder collects command lines rendered by a dry run
+	dryRunRecorder struct {
+		commands []string
+	}
+
+	dryRunCommandLog struct{}
+)
+
+func (r *dryRunRecorder) Record(commandLine string) commanders.CommandLog {
+	r.commands = append(r.commands, commandLine)
+	return dryRunCommandLog{}
+}
+
+func (dryRunCommandLog) Stdout() io.Writer { return ioutil.Discard }
+func (dryRunCommandLog) Stderr() io.Writer { return ioutil.Discard }
+func (dryRunCommandLog) Finish(int)        {}
+
+// dryRun renders commands a job of given type would run, without running them or changing
+// containers. The job is stored already finished, with rendered commands as its logs.
+// It returns ID of the job and rendered commands.
+func (j *JobService) dryRun(jobType string, payloadJob interface{}) (string, []string, error) {
+	payload, err := json.Marshal(payloadJob)
+	if err != nil {
+		return "", nil, err
+	}
+
+	job := &models.Job{
+		ID:          uuid.New().String(),
+		Type:        jobType,
+		Status:      models.DONE,
+		Payload:     payload,
+		Attempts:    1,
+		MaxAttempts: 1,
+		DryRun:      true,
+	}
+
+	handler, ok := j.dryRunHandlers[jobType]
+	if !ok {
+		return "", nil, ErrUnknownJobType
+	}
+
+	recorder := &dryRunRecorder{}
+	ctx := commanders.WithDryRun(commanders.WithRecorder(context.Background(), recorder))
+	startedAt := time.Now().UTC()
+
+	id, err := handler(ctx, job)
+	if id != "" {
+		job.EntityType = sql.NullString{String: ContainerType, Valid: true}
+		job.EntityID = sql.NullString{String: id, Valid: true}
+	}
+	if err != nil {
+		job.Status = models.FAILED
+		job.LastError = sql.NullString{String: err.Error(), Valid: true}
+	}
+
+	err = j.JobRepo.Create(job, EnqueueOptions{Host: j.Host})
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, command := range recorder.commands {
+		_, err = j.JobRepo.AddLog(job.ID, command, startedAt)
+		if err != nil {
+			return job.ID, nil, err
+		}
+	}
+
+	return job.ID, recorder.commands, nil
+}
+
+func (j *JobService) dryRunAddContainer(ctx context.Context, job *models.Job) (string, error) {
+	req, err := parseAddContainerJob(job)
+	if err != nil {
+		return "", err
+	}
+
+	err = j.Commander.CreateContainer(ctx, req.Name, req.OSTemplate, nil)
+	if err == nil && len(req.Parameters) > 0 {
+		err = j.Commander.SetContainerParameters(ctx, req.Name, req.Parameters)
+	}
+
+	return "", err
+}
+
+func (j *JobService) dryRunCloneContainer(ctx context.Context, job *models.Job) (string, error) {
+	var payload CloneContainerJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	err = j.Commander.CloneContainer(ctx, payload.Name, payload.CloneName)
+	if err == nil && len(payload.Overrides) > 0 {
+		err = j.Commander.SetContainerParameters(ctx, payload.CloneName, payload.Overrides)
+	}
+
+	return "", err
+}
+
+func (j *JobService) dryRunUpdateContainer(ctx context.Context, job *models.Job) (string, error) {
+	var payload UpdateContainerJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	container, err := j.ContainerRepo.FindByID(payload.ID)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	_, changed := mergeParameters(container.Parameters, payload.Parameters, payload.Replace)
+	if len(changed) > 0 {
+		err = j.Commander.SetContainerParameters(ctx, payload.Name, changed)
+	}
+
+	return payload.ID, err
+}
+
+func (j *JobService) dryRunDeleteContainer(ctx context.Context, job *models.Job) (string, error) {
+	var payload ContainerJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	// A missing container is only deleted from the database
+	state, err := j.ContainerRepo.GetState(payload.ID)
+	if err != nil || state == models.MISSING {
+		return payload.ID, err
+	}
+
+	return payload.ID, j.Commander.DeleteContainer(ctx, payload.Name)
+}
+
+func (j *JobService) dryRunContainerAction(ctx context.Context, job *models.Job) (string, error) {
+	var payload ContainerJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	return payload.ID, j.runAction(ctx, job.Type, payload.Name)
+}
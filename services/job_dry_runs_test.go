@@ -0,0 +1,141 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+// assertDryRunJob checks that a dry run is stored as a finished job with rendered commands as its logs
+func assertDryRunJob(t *testing.T, j *JobService, jobID string, commands []string) {
+	t.Helper()
+
+	job, err := j.JobRepo.FindByID(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !job.DryRun || job.Status != models.DONE {
+		t.Errorf("Job = %+v, want a done dry run", job)
+	}
+
+	logs, err := j.JobRepo.ListLogs(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged := make([]string, 0, len(logs))
+	for _, l := range logs {
+		logged = append(logged, l.Command)
+	}
+	if !reflect.DeepEqual(logged, commands) {
+		t.Errorf("Logs = %q, want %q", logged, commands)
+	}
+}
+
+func TestCreateContainerDryRun(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+
+	req := &api.AddContainerRequest{
+		Name:       "web",
+		OSTemplate: "centos-7",
+		Parameters: map[string]json.RawMessage{"cpus": json.RawMessage(`2`)},
+	}
+	if err := api.ValidateAddContainerRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Create(req, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"fake create web centos-7", "fake set web cpus=2"}
+	if !resp.DryRun || !reflect.DeepEqual(resp.Commands, want) {
+		t.Errorf("Create() = %+v, want commands %q", resp, want)
+	}
+	assertDryRunJob(t, jobs, resp.JobID, want)
+
+	// Nothing is created, and the name stays free
+	if picked, err := jobs.consumeJob(); err != nil || picked {
+		t.Errorf("consumeJob() = %v, %v, want no pending jobs", picked, err)
+	}
+	list, err := srv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Containers) != 0 {
+		t.Errorf("Containers = %v, want none", list.Containers)
+	}
+	created, err := srv.Create(req, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, created.JobID)
+
+	if _, err := srv.Create(req, "", true); err != ErrDuplicateName {
+		t.Errorf("Create() dry run of a taken name = %v, want %v", err, ErrDuplicateName)
+	}
+}
+
+func TestContainerJobsDryRun(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+
+	created, err := srv.Create(&api.AddContainerRequest{Name: "web", OSTemplate: "centos-7"}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, created.JobID)
+	list, err := srv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := list.Containers[0].ID
+
+	start, err := srv.Action(id, api.StartAction, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDryRunJob(t, jobs, start.JobID, []string{"fake start web"})
+
+	req := &api.UpdateContainerRequest{ID: id, Parameters: map[string]json.RawMessage{"memsize": json.RawMessage(`512`)}}
+	if err := api.ValidateUpdateContainerRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	update, err := srv.Update(req, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDryRunJob(t, jobs, update.JobID, []string{"fake set web memsize=512 memsize_units=M"})
+
+	// With the global flag every request is a dry run
+	jobs.DryRun = true
+	del, err := srv.Delete(id, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !del.DryRun {
+		t.Errorf("Delete() = %+v, want a dry run", del)
+	}
+	assertDryRunJob(t, jobs, del.JobID, []string{"fake delete web"})
+
+	container := assertContainer(t, srv, id, models.STOPPED)
+	if len(container.Parameters) != 0 {
+		t.Errorf("Parameters = %v, want none", container.Parameters)
+	}
+}
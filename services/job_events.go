@@ -0,0 +1,147 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"log"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+const (
+	JobStatusEventType   = "job.status"
+	JobProgressEventType = "job.progress"
+	JobLogEventType      = "job.log"
+
+	// StatusPollInterval is how often a status of a watched job is read from the database,
+	// as events of jobs run by other processes are not published to this one
+	StatusPollInterval = LogPollInterval
+
+	// WebhookEnqueueAttempts is how many times deliveries of an event are tried to be queued
+	WebhookEnqueueAttempts = 3
+)
+
+func (j *JobService) publishStatus(job *models.Job, status string, entityID string, err error) {
+	data := &api.JobStatusEvent{
+		Status:   status,
+		Attempts: job.Attempts,
+	}
+	if entityID != "" {
+		data.EntityID = &entityID
+	}
+	if err != nil {
+		lastError := err.Error()
+		data.LastError = &lastError
+	}
+
+	j.Events.Publish(JobStatusEventType, job.ID, data)
+
+	switch status {
+	case models.JobStatusNames[models.DONE]:
+		j.notify(api.JobDoneEvent, job.ID, data)
+	case models.JobStatusNames[models.FAILED]:
+		j.notify(api.JobFailedEvent, job.ID, data)
+	}
+}
+
+func (j *JobService) publishContainer(eventType string, id, name, osTemplate string) {
+	j.notify(eventType, id, &api.ContainerEvent{
+		ID:         id,
+		Name:       name,
+		OSTemplate: osTemplate,
+	})
+}
+
+// notify queues deliveries of an event to webhooks, then publishes it. It is called right
+// after a status the event reports is written, so that webhooks get the event even if
+// the process crashes before delivering it. Queueing is retried, as the status is
+// written already and the event would be lost otherwise.
+func (j *JobService) notify(eventType, subject string, data interface{}) {
+	if j.Webhooks != nil {
+		for attempts := 1; ; attempts++ {
+			err := j.Webhooks.Enqueue(eventType, subject, data)
+			if err == nil {
+				break
+			}
+			if attempts >= WebhookEnqueueAttempts {
+				log.Printf("Event %s of %s is not queued for webhooks: %s", eventType, subject, err.Error())
+				break
+			}
+			time.Sleep(j.Webhooks.RetryPolicy.Delay(attempts))
+		}
+	}
+	j.Events.Publish(eventType, subject, data)
+}
+
+func (j *JobService) publishProgress(jobID string, percent int) {
+	j.Events.Publish(JobProgressEventType, jobID, &api.JobProgressEvent{Percent: percent})
+}
+
+func publishLog(bus *events.Bus, jobID, stream, text string) {
+	bus.Publish(JobLogEventType, jobID, &api.JobLogEvent{Stream: stream, Text: text})
+}
+
+// IsFinalJobEvent reports whether an event finishes a job, so no more events follow
+func IsFinalJobEvent(e *events.Event) bool {
+	data, ok := e.Data.(*api.JobStatusEvent)
+	if !ok || e.Type != JobStatusEventType {
+		return false
+	}
+
+	return data.Status != models.JobStatusNames[models.PENDING]
+}
+
+// Watch subscribes to events of a job. It returns an event with the current status
+// of the job, which is the last one when the job is already finished.
+// A caller must unsubscribe from Events when done.
+func (srv *JobAPIService) Watch(id string) (*events.Subscription, *events.Event, error) {
+	// Subscribe before reading the job, so no events are missed
+	sub := srv.Events.Subscribe(events.BySubject(id))
+
+	current, err := srv.Status(id)
+	if err != nil {
+		srv.Events.Unsubscribe(sub)
+		return nil, nil, err
+	}
+
+	return sub, current, nil
+}
+
+// Status returns an event with the current status of a job read from the database.
+// Watchers poll it, so that they finish with jobs run by other processes too.
+func (srv *JobAPIService) Status(id string) (*events.Event, error) {
+	job, err := srv.findJobByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	info := srv.jobInfo(job)
+	return &events.Event{
+		Type:    JobStatusEventType,
+		Subject: id,
+		Time:    job.CreatedAt,
+		Data: &api.JobStatusEvent{
+			Status:    info.Status,
+			Attempts:  info.Attempts,
+			EntityID:  info.EntityID,
+			LastError: info.LastError,
+		},
+	}, nil
+}
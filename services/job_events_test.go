@@ -0,0 +1,92 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"testing"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/events"
+)
+
+// collectEvents returns events buffered for a subscription
+func collectEvents(sub *events.Subscription) []*events.Event {
+	var collected []*events.Event
+	for {
+		select {
+		case e := <-sub.C:
+			collected = append(collected, e)
+		default:
+			return collected
+		}
+	}
+}
+
+func TestJobEvents(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+	jobsAPI := &JobAPIService{JobRepo: jobs.JobRepo, Jobs: jobs, Events: jobs.Events}
+
+	created, err := srv.Create(&api.AddContainerRequest{Name: "web", OSTemplate: "centos-7"}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, current, err := jobsAPI.Watch(created.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jobs.Events.Unsubscribe(sub)
+	if IsFinalJobEvent(current) || current.Data.(*api.JobStatusEvent).Status != "pending" {
+		t.Errorf("Current event = %+v, want pending", current.Data)
+	}
+	runTestJobs(t, jobs)
+
+	var got []string
+	for _, e := range collectEvents(sub) {
+		if e.Subject != created.JobID {
+			t.Errorf("Event of %s, want %s", e.Subject, created.JobID)
+		}
+		switch data := e.Data.(type) {
+		case *api.JobStatusEvent:
+			if e.Type == JobStatusEventType {
+				got = append(got, data.Status)
+			}
+		case *api.JobProgressEvent:
+			got = append(got, e.Type)
+		case *api.JobLogEvent:
+			got = append(got, data.Stream+" "+data.Text)
+		}
+	}
+	want := []string{JobProgressEventType, JobProgressEventType, "command fake create web centos-7", "exit 0", JobProgressEventType, "done"}
+	if len(got) != len(want) {
+		t.Fatalf("Events = %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("Events = %q, want %q", got, want)
+		}
+	}
+
+	// A finished job has no more events to wait for
+	sub, current, err = jobsAPI.Watch(created.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs.Events.Unsubscribe(sub)
+	if data := current.Data.(*api.JobStatusEvent); !IsFinalJobEvent(current) || data.Status != "done" || data.EntityID == nil {
+		t.Errorf("Current event of a finished job = %+v", data)
+	}
+}
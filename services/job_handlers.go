@@ -0,0 +1,344 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/models"
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+func (j *JobService) addContainer(ctx context.Context, job *models.Job) (string, error) {
+	req, err := parseAddContainerJob(job)
+	if err != nil {
+		return "", err
+	}
+
+	// A container may already be inserted by a previous attempt of the job
+	id, err := j.ContainerRepo.FindIDByName(req.Name, models.CREATING)
+	switch {
+	case err == sql.ErrNoRows:
+		id = req.ID // UUID of container
+		if id == "" {
+			id = uuid.New().String()
+		}
+
+		err = j.ContainerRepo.Create(&models.Container{
+			ID:         id,
+			Name:       req.Name,
+			OSTemplate: req.OSTemplate,
+			State:      models.CREATING,
+		})
+		if err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	}
+	j.publishProgress(job.ID, 10)
+
+	err = j.Commander.CreateContainer(ctx, req.Name, req.OSTemplate, nil)
+	if err == nil && len(req.Parameters) > 0 {
+		j.publishProgress(job.ID, 50)
+		var rolledBack bool
+		rolledBack, err = j.configureContainer(ctx, job, id, req.Name, req.Parameters, req.Parameters)
+		if rolledBack {
+			return id, err
+		}
+	}
+
+	return id, j.finishCreation(job, id, req.Name, req.OSTemplate, err)
+}
+
+// cloneContainer copies a stopped container, then sets overridden parameters of its clone
+func (j *JobService) cloneContainer(ctx context.Context, job *models.Job) (string, error) {
+	var payload CloneContainerJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := j.ContainerRepo.GetState(payload.ID)
+	if err != nil {
+		return "", err
+	}
+	if !canTransition(job.Type, state) {
+		return "", ErrInvalidContainerState
+	}
+
+	// A clone may already be inserted by a previous attempt of the job
+	id, err := j.ContainerRepo.FindIDByName(payload.CloneName, models.CREATING)
+	switch {
+	case err == sql.ErrNoRows:
+		id = uuid.New().String()
+
+		err = j.ContainerRepo.Create(&models.Container{
+			ID:         id,
+			Name:       payload.CloneName,
+			OSTemplate: payload.OSTemplate,
+			State:      models.CREATING,
+		})
+		if err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", err
+	}
+	j.publishProgress(job.ID, 10)
+
+	err = j.Commander.CloneContainer(ctx, payload.Name, payload.CloneName)
+	if err == nil {
+		j.publishProgress(job.ID, 50)
+		if len(payload.Overrides) > 0 {
+			var rolledBack bool
+			rolledBack, err = j.configureContainer(ctx, job, id, payload.CloneName, payload.Overrides, payload.Parameters)
+			if rolledBack {
+				return id, err
+			}
+		} else {
+			err = j.ContainerRepo.SetParameters(id, payload.Parameters)
+		}
+	}
+
+	return id, j.finishCreation(job, id, payload.CloneName, payload.OSTemplate, err)
+}
+
+// finishCreation sets a state of a created container according to err of its creation,
+// unless the job is retried
+func (j *JobService) finishCreation(job *models.Job, id, name, osTemplate string, err error) error {
+	if err != nil && j.willRetry(job, err) {
+		return err
+	}
+
+	state := models.STOPPED
+	if err != nil {
+		state = models.ERROR
+	}
+	if stateErr := j.setContainerState(id, state); stateErr != nil {
+		return stateErr
+	}
+	if err == nil {
+		j.publishContainer(api.ContainerCreatedEvent, id, name, osTemplate)
+	}
+
+	return err
+}
+
+// configureContainer sets params of a just created container and stores its parameters. If it fails,
+// the container is rolled back: deleted from a host, so that a retry creates it anew, and from
+// the database unless the job is retried.
+func (j *JobService) configureContainer(ctx context.Context, job *models.Job, id, name string, params, parameters openvzcmd.Options) (bool, error) {
+	err := j.Commander.SetContainerParameters(ctx, name, params)
+	if err == nil {
+		return false, j.ContainerRepo.SetParameters(id, parameters)
+	}
+
+	// A rollback runs even when the job is cancelled
+	rollbackErr := j.Commander.DeleteContainer(commanders.WithRecorder(context.Background(), &jobRecorder{Repo: j.JobRepo, Events: j.Events, JobID: job.ID}), name)
+	if rollbackErr != nil {
+		log.Printf("Rollback of container %s failed: %s", name, rollbackErr.Error())
+		// A half-created container is left, so creating it again would fail
+		return false, commanders.Permanent(err)
+	}
+
+	if ctx.Err() != nil || !j.willRetry(job, err) {
+		if dbErr := j.ContainerRepo.Delete(id); dbErr != nil {
+			return true, dbErr
+		}
+	}
+
+	return true, err
+}
+
+// parseAddContainerJob returns a payload of a job creating a container. A malformed payload
+// fails only the job, as it will not be parsed on retry either.
+func parseAddContainerJob(job *models.Job) (*AddContainerJob, error) {
+	var req AddContainerJob
+
+	err := json.Unmarshal(job.Payload, &req)
+	if err != nil {
+		return nil, commanders.Permanent(fmt.Errorf("payload of %s job cannot be parsed: %w", job.Type, err))
+	}
+
+	return &req, nil
+}
+
+func (j *JobService) updateContainer(ctx context.Context, job *models.Job) (string, error) {
+	var payload UpdateContainerJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	container, err := j.ContainerRepo.FindByID(payload.ID)
+	if err != nil {
+		return payload.ID, err
+	}
+	if !canTransition(job.Type, container.State) {
+		return payload.ID, ErrInvalidContainerState
+	}
+
+	parameters, changed := mergeParameters(container.Parameters, payload.Parameters, payload.Replace)
+	if len(changed) > 0 {
+		err = j.Commander.SetContainerParameters(ctx, payload.Name, changed)
+		if err != nil {
+			return payload.ID, err
+		}
+	}
+
+	return payload.ID, j.ContainerRepo.SetParameters(payload.ID, parameters)
+}
+
+func (j *JobService) deleteContainer(ctx context.Context, job *models.Job) (string, error) {
+	var payload ContainerJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := j.ContainerRepo.GetState(payload.ID)
+	if err != nil {
+		return payload.ID, err
+	}
+	if !canTransition(job.Type, state) {
+		return payload.ID, ErrInvalidContainerState
+	}
+
+	onHost, err := j.isOnHost(ctx, payload.Name, state)
+	if err != nil {
+		return payload.ID, err
+	}
+	if onHost {
+		err = j.Commander.DeleteContainer(ctx, payload.Name)
+		if err != nil {
+			return payload.ID, err
+		}
+	}
+
+	// Snapshots are gone along with a container
+	err = j.SnapshotRepo.DeleteByContainer(payload.ID)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	err = j.PolicyRepo.DetachContainer(payload.ID)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	err = j.ContainerRepo.Delete(payload.ID)
+	if err == nil {
+		j.publishContainer(api.ContainerDeletedEvent, payload.ID, payload.Name, "")
+	}
+
+	return payload.ID, err
+}
+
+// isOnHost reports whether a container in state is found on a host. Missing containers are not,
+// while ones in error, e.g. after their creation failed, are looked up on a host.
+func (j *JobService) isOnHost(ctx context.Context, name string, state models.ContainerState) (bool, error) {
+	switch state {
+	case models.MISSING:
+		return false, nil
+	case models.ERROR:
+		containers, err := j.Commander.ListContainers(ctx)
+		if err != nil {
+			return false, err
+		}
+		for _, hc := range containers {
+			if hc.Name == name {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+func (j *JobService) runContainerAction(ctx context.Context, job *models.Job) (string, error) {
+	var payload ContainerJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := j.ContainerRepo.GetState(payload.ID)
+	if err != nil {
+		return payload.ID, err
+	}
+	if !canTransition(job.Type, state) {
+		return payload.ID, ErrInvalidContainerState
+	}
+
+	err = j.runAction(ctx, job.Type, payload.Name)
+	if err != nil {
+		switch {
+		case ctx.Err() != nil, errors.Is(err, context.Canceled):
+			// A cancelled action leaves a container in its previous state
+		case j.willRetry(job, err):
+		case !isRetryable(err):
+			// A permanent failure, e.g. starting a running container, tells nothing wrong
+			// with a container, so its state is taken from a host
+			j.refreshContainerState(ctx, payload.ID, payload.Name)
+		default:
+			if stateErr := j.setContainerState(payload.ID, models.ERROR); stateErr != nil {
+				return payload.ID, stateErr
+			}
+		}
+
+		return payload.ID, err
+	}
+
+	if err := j.setContainerState(payload.ID, containerTransitions[job.Type].To); err != nil {
+		return payload.ID, err
+	}
+
+	return payload.ID, nil
+}
+
+// runAction runs a command of a container action job
+func (j *JobService) runAction(ctx context.Context, jobType, name string) error {
+	switch jobType {
+	case StartContainerType:
+		return j.Commander.StartContainer(ctx, name)
+	case StopContainerType:
+		return j.Commander.StopContainer(ctx, name)
+	case RestartContainerType:
+		return j.Commander.RestartContainer(ctx, name)
+	case SuspendContainerType:
+		return j.Commander.SuspendContainer(ctx, name)
+	case ResumeContainerType:
+		return j.Commander.ResumeContainer(ctx, name)
+	}
+
+	return fmt.Errorf("%w %s", ErrUnknownJobType, jobType)
+}
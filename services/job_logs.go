@@ -0,0 +1,274 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+	"github.com/romiras/go-openvz-api/repositories"
+)
+
+const (
+	// LogPollInterval is how often logs of a running job are checked when following them
+	LogPollInterval = time.Second
+	// LogFlushInterval is how often output of a running command is stored at most,
+	// as a whole output of a stream is rewritten on every flush
+	LogFlushInterval = LogPollInterval
+)
+
+type (
+	// jobRecorder keeps commands run by a job as its logs
+	jobRecorder struct {
+		Repo   repositories.JobRepository
+		Events *events.Bus
+		JobID  string
+	}
+
+	// jobCommandLog stores output of a command while it runs, so that it can be tailed.
+	// Output is published as soon as it is written and stored within LogFlushInterval,
+	// even if the command writes nothing more for a while.
+	// ID is 0 if the log cannot be added, then nothing is stored.
+	jobCommandLog struct {
+		Repo   repositories.JobRepository
+		Events *events.Bus
+		JobID  string
+		ID     int64
+		mu     sync.Mutex
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+		// unflushed are streams written since they were stored, by column
+		unflushed map[string]*bytes.Buffer
+		flushedAt time.Time
+		// flushTimer stores unflushed output once LogFlushInterval passes since the last flush
+		flushTimer *time.Timer
+		finished   bool
+	}
+
+	jobCommandLogWriter struct {
+		log    *jobCommandLog
+		column string
+		buf    *bytes.Buffer
+	}
+
+	// JobLogFollower writes logs of a job as they appear
+	JobLogFollower struct {
+		srv   *JobAPIService
+		jobID string
+		sent  map[int64]*sentJobLog
+	}
+
+	sentJobLog struct {
+		stdout   int
+		stderr   int
+		finished bool
+	}
+)
+
+func (r *jobRecorder) Record(commandLine string) commanders.CommandLog {
+	l := &jobCommandLog{Repo: r.Repo, Events: r.Events, JobID: r.JobID}
+	publishLog(r.Events, r.JobID, "command", commandLine)
+
+	var err error
+	l.ID, err = r.Repo.AddLog(r.JobID, commandLine, time.Now().UTC())
+	if err != nil {
+		log.Println(err.Error())
+	}
+
+	return l
+}
+
+func (l *jobCommandLog) Stdout() io.Writer {
+	return &jobCommandLogWriter{log: l, column: "stdout", buf: &l.stdout}
+}
+
+func (l *jobCommandLog) Stderr() io.Writer {
+	return &jobCommandLogWriter{log: l, column: "stderr", buf: &l.stderr}
+}
+
+func (l *jobCommandLog) Finish(exitCode int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The rest of output is stored along with an exit code
+	l.finished = true
+	if l.flushTimer != nil {
+		l.flushTimer.Stop()
+	}
+
+	publishLog(l.Events, l.JobID, "exit", strconv.Itoa(exitCode))
+	if l.ID == 0 {
+		return
+	}
+
+	err := l.Repo.FinishLog(l.ID, exitCode, l.stdout.String(), l.stderr.String(), time.Now().UTC())
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+func (w *jobCommandLogWriter) Write(p []byte) (int, error) {
+	w.log.mu.Lock()
+	defer w.log.mu.Unlock()
+
+	w.buf.Write(p)
+	publishLog(w.log.Events, w.log.JobID, w.column, string(p))
+
+	l := w.log
+	if l.ID == 0 || l.finished {
+		return len(p), nil
+	}
+	if l.unflushed == nil {
+		l.unflushed = make(map[string]*bytes.Buffer)
+	}
+	l.unflushed[w.column] = w.buf
+
+	// A flush is scheduled already, it stores this output as well
+	if l.flushTimer != nil {
+		return len(p), nil
+	}
+	if wait := LogFlushInterval - time.Since(l.flushedAt); wait > 0 {
+		l.flushTimer = time.AfterFunc(wait, l.scheduledFlush)
+		return len(p), nil
+	}
+	l.flush()
+
+	return len(p), nil
+}
+
+func (l *jobCommandLog) scheduledFlush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.flushTimer = nil
+	if !l.finished {
+		l.flush()
+	}
+}
+
+// flush stores unflushed output, l.mu must be held
+func (l *jobCommandLog) flush() {
+	l.flushedAt = time.Now()
+
+	for column, buf := range l.unflushed {
+		err := l.Repo.SetLogOutput(l.ID, column, buf.String())
+		if err != nil {
+			log.Println(err.Error())
+		}
+		delete(l.unflushed, column)
+	}
+}
+
+func (srv *JobAPIService) GetLogs(id string) (*api.ListJobLogsResponse, error) {
+	_, err := srv.findJobByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := srv.JobRepo.ListLogs(id)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*api.JobLogInfo, 0, len(logs))
+	for _, l := range logs {
+		info := &api.JobLogInfo{
+			Command:   l.Command,
+			Stdout:    l.Stdout,
+			Stderr:    l.Stderr,
+			StartedAt: l.StartedAt,
+		}
+		if l.ExitCode.Valid {
+			exitCode := int(l.ExitCode.Int64)
+			info.ExitCode = &exitCode
+		}
+		if l.FinishedAt.Valid {
+			info.FinishedAt = &l.FinishedAt.Time
+		}
+		infos = append(infos, info)
+	}
+
+	return &api.ListJobLogsResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Logs: infos,
+	}, nil
+}
+
+// FollowLogs returns a follower of logs of a job
+func (srv *JobAPIService) FollowLogs(id string) (*JobLogFollower, error) {
+	_, err := srv.findJobByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &JobLogFollower{
+		srv:   srv,
+		jobID: id,
+		sent:  make(map[int64]*sentJobLog),
+	}, nil
+}
+
+// Next writes logs which appeared since the previous call and reports whether
+// the job is still pending, so more logs may appear.
+func (f *JobLogFollower) Next(w io.Writer) (bool, error) {
+	// Status is read before logs, so that no logs of a just finished job are missed
+	job, err := f.srv.findJobByID(f.jobID)
+	if err != nil {
+		return false, err
+	}
+
+	logs, err := f.srv.JobRepo.ListLogs(f.jobID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, l := range logs {
+		sent, ok := f.sent[l.ID]
+		if !ok {
+			sent = &sentJobLog{}
+			f.sent[l.ID] = sent
+			fmt.Fprintf(w, "$ %s\n", l.Command)
+		}
+		if sent.finished {
+			continue
+		}
+
+		io.WriteString(w, l.Stdout[sent.stdout:])
+		io.WriteString(w, l.Stderr[sent.stderr:])
+		sent.stdout = len(l.Stdout)
+		sent.stderr = len(l.Stderr)
+
+		if l.ExitCode.Valid {
+			fmt.Fprintf(w, "[exit code %d]\n", l.ExitCode.Int64)
+			sent.finished = true
+		}
+	}
+
+	return job.Status == models.PENDING, nil
+}
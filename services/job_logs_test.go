@@ -0,0 +1,184 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func TestJobLogsOfCommands(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+	jobsAPI := &JobAPIService{JobRepo: jobs.JobRepo, Jobs: jobs, Events: jobs.Events}
+
+	created, err := srv.Create(&api.AddContainerRequest{Name: "web", OSTemplate: "centos-7"}, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, created.JobID)
+	list, err := srv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := list.Containers[0].ID
+
+	req := &api.UpdateContainerRequest{ID: id, Parameters: map[string]json.RawMessage{"cpus": json.RawMessage(`2`)}}
+	if err := api.ValidateUpdateContainerRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	update, err := srv.Update(req, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, update.JobID)
+
+	for _, tc := range []struct {
+		jobID   string
+		command string
+	}{
+		{created.JobID, "fake create web centos-7"},
+		{update.JobID, "fake set web cpus=2"},
+	} {
+		resp, err := jobsAPI.GetLogs(tc.jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Logs) != 1 {
+			t.Fatalf("Logs = %+v, want one command", resp.Logs)
+		}
+		l := resp.Logs[0]
+		if l.Command != tc.command || l.ExitCode == nil || *l.ExitCode != 0 || l.FinishedAt == nil {
+			t.Errorf("Log = %+v, want %q finished", l, tc.command)
+		}
+	}
+}
+
+func TestFollowJobLogs(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+	srv := NewJobAPIService(db, nil, j, j.Events)
+
+	step, written := make(chan struct{}), make(chan struct{})
+	j.RegisterHandler("chatty", func(ctx context.Context, job *models.Job) (string, error) {
+		l := (&jobRecorder{Repo: j.JobRepo, Events: j.Events, JobID: job.ID}).Record("echo hello")
+		written <- struct{}{}
+		<-step
+		fmt.Fprint(l.Stdout(), "hel")
+		written <- struct{}{}
+		<-step
+		fmt.Fprint(l.Stdout(), "lo\n")
+		l.Finish(0)
+		return "", nil
+	})
+	jobID := enqueueTestJob(t, j, "chatty", struct{}{})
+	done := make(chan error)
+	go func() {
+		_, err := j.consumeJob()
+		done <- err
+	}()
+
+	follower, err := srv.FollowLogs(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	next := func(wantOut string, wantMore bool) {
+		t.Helper()
+
+		out.Reset()
+		more, err := follower.Next(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != wantOut || more != wantMore {
+			t.Errorf("Next() = %q, %v, want %q, %v", out.String(), more, wantOut, wantMore)
+		}
+	}
+
+	<-written
+	next("$ echo hello\n", true)
+	step <- struct{}{}
+	<-written
+	next("hel", true)
+	step <- struct{}{}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	next("lo\n[exit code 0]\n", false)
+}
+
+func TestRecordedCommandLine(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+
+	jobID := enqueueTestJob(t, j, "noop", struct{}{})
+	ctx := commanders.WithRecorder(context.Background(), &jobRecorder{Repo: j.JobRepo, Events: j.Events, JobID: jobID})
+	cmd := commanders.NewFakeCommander()
+	if err := cmd.StartContainer(ctx, "missing ct"); err == nil {
+		t.Fatal("StartContainer() of a missing container succeeded")
+	}
+
+	logs, err := NewJobAPIService(db, nil, j, j.Events).GetLogs(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := logs.Logs[0]
+	if l.Command != `fake start "missing ct"` || l.ExitCode == nil || *l.ExitCode != 1 || l.Stderr != "container missing ct does not exist\n" {
+		t.Errorf("Log = %+v", l)
+	}
+}
+
+func TestOutputIsStoredWithoutFurtherWrites(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+	srv := NewJobAPIService(db, nil, j, j.Events)
+
+	jobID := enqueueTestJob(t, j, "noop", struct{}{})
+	l := (&jobRecorder{Repo: j.JobRepo, Events: j.Events, JobID: jobID}).Record("sleep 600")
+	defer l.Finish(0)
+	fmt.Fprint(l.Stdout(), "hel")
+	fmt.Fprint(l.Stdout(), "lo\n")
+	fmt.Fprint(l.Stderr(), "warning\n")
+
+	deadline := time.Now().Add(3 * LogFlushInterval)
+	for {
+		logs, err := srv.GetLogs(jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := logs.Logs[0]
+		if stored.Stdout == "hello\n" && stored.Stderr == "warning\n" {
+			if stored.ExitCode != nil {
+				t.Errorf("Log = %+v, want it running", stored)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Log = %+v, want output stored within %v", stored, LogFlushInterval)
+		}
+		time.Sleep(LogFlushInterval / 10)
+	}
+}
@@ -17,23 +17,28 @@
 package services
 
 import (
-	"database/sql"
-	"log"
+	"time"
 
 	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
 	"github.com/romiras/go-openvz-api/models"
-	openvzcmd "github.com/romiras/go-openvz-cmd"
+	"github.com/romiras/go-openvz-api/repositories"
 )
 
 type JobAPIService struct {
-	DB        DBConnection
-	Commander *openvzcmd.POCCommanderStub
+	JobRepo   repositories.JobRepository
+	Commander commanders.Commander
+	Jobs      *JobService
+	Events    *events.Bus
 }
 
-func NewJobAPIService(db DBConnection, cmd *openvzcmd.POCCommanderStub) *JobAPIService {
+func NewJobAPIService(db DBConnection, cmd commanders.Commander, jobs *JobService, bus *events.Bus) *JobAPIService {
 	return &JobAPIService{
-		DB:        db,
+		JobRepo:   repositories.NewJobRepository(db),
 		Commander: cmd,
+		Jobs:      jobs,
+		Events:    bus,
 	}
 }
 
@@ -43,48 +48,120 @@ func (srv *JobAPIService) GetById(id string) (*api.GetJobByIdResponse, error) {
 		return nil, err
 	}
 
-	var entityType, entityID *string
-	if job.EntityType.Valid {
-		entityType = &job.EntityType.String
+	info := srv.jobInfo(job)
+
+	return &api.GetJobByIdResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Status:      info.Status,
+		EntityType:  info.EntityType,
+		EntityID:    info.EntityID,
+		Attempts:    info.Attempts,
+		MaxAttempts: info.MaxAttempts,
+		LastError:   info.LastError,
+		NextRunAt:   info.NextRunAt,
+		DryRun:      info.DryRun,
+		CreatedAt:   info.CreatedAt,
+	}, nil
+}
+
+func (srv *JobAPIService) List(req *api.ListJobsRequest) (*api.ListJobsResponse, error) {
+	filter := repositories.JobFilter{
+		Type:         req.Type,
+		EntityID:     req.EntityID,
+		CreatedAfter: req.CreatedAfter,
+		Limit:        req.Limit,
+		Offset:       req.Offset,
 	}
-	if job.EntityID.Valid {
-		entityID = &job.EntityID.String
+	if req.Status != "" {
+		status, _ := models.ParseJobStatus(req.Status)
+		filter.Status = &status
+	}
+	if filter.Limit == 0 {
+		filter.Limit = api.DefaultListLimit
 	}
 
-	return &api.GetJobByIdResponse{
+	jobs, err := srv.JobRepo.List(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*api.JobInfo, 0, len(jobs))
+	for _, job := range jobs {
+		infos = append(infos, srv.jobInfo(job))
+	}
+
+	return &api.ListJobsResponse{
 		ApiResponse: api.ApiResponse{
 			Code:    0,
 			Message: "success",
 		},
-		Status:     srv.getJobStatus(job.Status),
-		EntityType: entityType,
-		EntityID:   entityID,
+		Jobs: infos,
 	}, nil
 }
 
-func (srv *JobAPIService) findJobByID(id string) (*models.Job, error) {
-	var job models.Job
+func (srv *JobAPIService) Cancel(id string) (*api.ApiResponse, error) {
+	_, err := srv.findJobByID(id)
+	if err != nil {
+		return nil, err
+	}
 
-	err := srv.DB.Get(&job, "SELECT id, type, status, entity_type, entity_id FROM jobs WHERE id=? LIMIT 1", id)
-	switch {
-	case err == sql.ErrNoRows:
+	err = srv.Jobs.Cancel(id)
+	if err != nil {
 		return nil, err
-	case err != nil:
-		log.Fatal(err)
 	}
 
-	return &job, nil
+	return &api.ApiResponse{
+		Code:    0,
+		Message: "success",
+	}, nil
 }
 
-func (srv *JobAPIService) getJobStatus(status models.JobStatus) string {
-	switch status {
-	case models.PENDING:
-		return "pending"
-	case models.DONE:
-		return "done"
-	case models.FAILED:
-		return "failed"
-	default:
-		return ""
+func (srv *JobAPIService) jobInfo(job *models.Job) *api.JobInfo {
+	var entityType, entityID *string
+	if job.EntityType.Valid {
+		entityType = &job.EntityType.String
+	}
+	if job.EntityID.Valid {
+		entityID = &job.EntityID.String
+	}
+
+	var lastError *string
+	if job.LastError.Valid {
+		lastError = &job.LastError.String
+	}
+
+	var nextRunAt *time.Time
+	if job.NextRunAt.Valid && job.Status == models.PENDING {
+		nextRunAt = &job.NextRunAt.Time
 	}
+
+	return &api.JobInfo{
+		ID:          job.ID,
+		Type:        job.Type,
+		Status:      srv.getJobStatus(job.Status),
+		EntityType:  entityType,
+		EntityID:    entityID,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+		LastError:   lastError,
+		NextRunAt:   nextRunAt,
+		DryRun:      job.DryRun,
+		CreatedAt:   job.CreatedAt,
+	}
+}
+
+func (srv *JobAPIService) findJobByID(id string) (*models.Job, error) {
+	job, err := srv.JobRepo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (srv *JobAPIService) getJobStatus(status models.JobStatus) string {
+	return models.JobStatusNames[status]
 }
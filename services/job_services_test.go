@@ -0,0 +1,197 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func TestListJobs(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+	srv := NewJobAPIService(db, nil, j, j.Events)
+
+	j.RegisterHandler("noop", func(ctx context.Context, job *models.Job) (string, error) {
+		return "c1", nil
+	})
+	var ids []string
+	for i, jobType := range []string{"noop", "other", "noop"} {
+		ids = append(ids, enqueueTestJob(t, j, jobType, struct{}{}))
+		db.MustExec("UPDATE jobs SET created_at=? WHERE id=?", time.Date(2020, 1, 1, i, 0, 0, 0, time.UTC), ids[i])
+	}
+	if _, err := j.consumeJob(); err != nil {
+		t.Fatal(err)
+	}
+
+	after := time.Date(2020, 1, 1, 0, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name string
+		req  api.ListJobsRequest
+		want []string
+	}{
+		{"all", api.ListJobsRequest{}, ids},
+		{"status", api.ListJobsRequest{Status: "pending"}, ids[1:]},
+		{"type", api.ListJobsRequest{Type: "noop"}, []string{ids[0], ids[2]}},
+		{"entity", api.ListJobsRequest{EntityID: "c1"}, ids[:1]},
+		{"created after", api.ListJobsRequest{CreatedAfter: &after}, ids[1:]},
+		{"page", api.ListJobsRequest{Limit: 1, Offset: 1}, ids[1:2]},
+	} {
+		if err := api.ValidateListJobsRequest(&tc.req); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		resp, err := srv.List(&tc.req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var got []string
+		for _, job := range resp.Jobs {
+			got = append(got, job.ID)
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: jobs = %v, want %v", tc.name, got, tc.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.want[i] {
+				t.Errorf("%s: jobs = %v, want %v", tc.name, got, tc.want)
+				break
+			}
+		}
+	}
+
+	if err := api.ValidateListJobsRequest(&api.ListJobsRequest{Status: "lost"}); err == nil {
+		t.Error("Unknown status is accepted")
+	}
+	if err := api.ValidateListJobsRequest(&api.ListJobsRequest{Limit: api.MaxListLimit + 1}); err == nil {
+		t.Error("Limit over the maximum is accepted")
+	}
+}
+
+func TestCancelJobs(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+	srv := NewJobAPIService(db, nil, j, j.Events)
+
+	started := make(chan string)
+	j.RegisterHandler("block", func(ctx context.Context, job *models.Job) (string, error) {
+		started <- job.ID
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+
+	pendingID := enqueueTestJob(t, j, "block", struct{}{})
+	if _, err := srv.Cancel(pendingID); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := findTestJob(t, j, pendingID); status != models.CANCELLED {
+		t.Errorf("Status of a cancelled pending job = %v, want cancelled", status)
+	}
+	if _, err := srv.Cancel(pendingID); err != ErrJobNotCancellable {
+		t.Errorf("Cancel() of a cancelled job = %v, want %v", err, ErrJobNotCancellable)
+	}
+
+	runningID := enqueueTestJob(t, j, "block", struct{}{})
+	done := make(chan error)
+	go func() {
+		_, err := j.consumeJob()
+		done <- err
+	}()
+	<-started
+	if _, err := srv.Cancel(runningID); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := findTestJob(t, j, runningID); status != models.CANCELLED {
+		t.Errorf("Status of a cancelled running job = %v, want cancelled", status)
+	}
+}
+
+func TestCancelledJobFinishedByHandler(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+	srv := NewJobAPIService(db, nil, j, j.Events)
+
+	started := make(chan string)
+	results := map[string]error{
+		"ignore-cancel":  nil,
+		"fail-on-cancel": commanders.Permanent(errors.New("broken")),
+	}
+	for jobType, result := range results {
+		result := result
+		j.RegisterHandler(jobType, func(ctx context.Context, job *models.Job) (string, error) {
+			started <- job.ID
+			<-ctx.Done()
+			return "", result
+		})
+	}
+
+	// A handler finishing its work regardless of cancellation gets its job done or failed
+	for jobType, want := range map[string]models.JobStatus{"ignore-cancel": models.DONE, "fail-on-cancel": models.FAILED} {
+		jobID := enqueueTestJob(t, j, jobType, struct{}{})
+		done := make(chan error)
+		go func() {
+			_, err := j.consumeJob()
+			done <- err
+		}()
+		<-started
+		if _, err := srv.Cancel(jobID); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if status, _ := findTestJob(t, j, jobID); status != want {
+			t.Errorf("Status of a %s job = %v, want %v", jobType, status, want)
+		}
+	}
+}
+
+func TestJobDatabaseErrorsAreReturned(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+	srv := NewJobAPIService(db, nil, j, j.Events)
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, call := range map[string]func() error{
+		"GetById":    func() error { _, err := srv.GetById("job-1"); return err },
+		"Cancel":     func() error { _, err := srv.Cancel("job-1"); return err },
+		"GetLogs":    func() error { _, err := srv.GetLogs("job-1"); return err },
+		"FollowLogs": func() error { _, err := srv.FollowLogs("job-1"); return err },
+		"Watch":      func() error { _, _, err := srv.Watch("job-1"); return err },
+	} {
+		if err := call(); err == nil || err == sql.ErrNoRows {
+			t.Errorf("%s() = %v, want an error of the database", name, err)
+		}
+	}
+
+	if _, err := j.Enqueue("noop", func() {}, EnqueueOptions{}); err == nil {
+		t.Error("Enqueue() of a payload which is not JSON succeeded")
+	}
+}
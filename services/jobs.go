@@ -1,126 +1,601 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
 package services
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
 	"github.com/romiras/go-openvz-api/models"
+	"github.com/romiras/go-openvz-api/repositories"
 	openvzcmd "github.com/romiras/go-openvz-cmd"
 )
 
 const (
-	AddContainerType = "add-container"
-	ContainerType    = "container"
+	AddContainerType     = "add-container"
+	UpdateContainerType  = "update-container"
+	DeleteContainerType  = "delete-container"
+	StartContainerType   = "start-container"
+	StopContainerType    = "stop-container"
+	RestartContainerType = "restart-container"
+	SuspendContainerType = "suspend-container"
+	ResumeContainerType  = "resume-container"
+	CloneContainerType   = "clone-container"
+	CreateSnapshotType   = "create-snapshot"
+	DeleteSnapshotType   = "delete-snapshot"
+	RevertSnapshotType   = "revert-snapshot"
+	CreateBackupType     = "create-backup"
+	DeleteBackupType     = "delete-backup"
+	RestoreBackupType    = "restore-backup"
+	ContainerType        = "container"
+
+	DefaultLeaseDuration = 60 * time.Second
+	DefaultMaxAttempts   = 3
+	DefaultBackupDir     = "backups"
 )
 
+// ContainerActionTypes maps API container actions to job types
+var ContainerActionTypes = map[string]string{
+	api.StartAction:   StartContainerType,
+	api.StopAction:    StopContainerType,
+	api.RestartAction: RestartContainerType,
+	api.SuspendAction: SuspendContainerType,
+	api.ResumeAction:  ResumeContainerType,
+}
+
+// ErrJobNotCancellable is returned on cancellation of a job which is already finished
+var ErrJobNotCancellable = errors.New("not-cancellable")
+
+// ErrInvalidContainerState is returned when an action is not allowed in the current container state
+var ErrInvalidContainerState = errors.New("invalid-state")
+
+// containerTransitions defines the container state machine: states a job type may be
+// applied to and the state a container ends up in when the job is done. Jobs keeping
+// a state of a container or removing it have no To state.
+var containerTransitions = map[string]containerTransition{
+	// Parameters are set on containers found on a host
+	UpdateContainerType: {From: []models.ContainerState{models.STOPPED, models.RUNNING, models.SUSPENDED}},
+	// A container may be deleted unless it is being created
+	DeleteContainerType:  {From: []models.ContainerState{models.STOPPED, models.RUNNING, models.SUSPENDED, models.ERROR, models.MISSING}},
+	StartContainerType:   {From: []models.ContainerState{models.STOPPED, models.ERROR}, To: models.RUNNING},
+	StopContainerType:    {From: []models.ContainerState{models.RUNNING, models.SUSPENDED, models.ERROR}, To: models.STOPPED},
+	RestartContainerType: {From: []models.ContainerState{models.RUNNING}, To: models.RUNNING},
+	SuspendContainerType: {From: []models.ContainerState{models.RUNNING}, To: models.SUSPENDED},
+	ResumeContainerType:  {From: []models.ContainerState{models.SUSPENDED}, To: models.RUNNING},
+	// A source of a clone stays stopped
+	CloneContainerType: {From: []models.ContainerState{models.STOPPED}, To: models.STOPPED},
+	// A container restored from a backup is created anew
+	RestoreBackupType: {From: []models.ContainerState{models.STOPPED, models.ERROR}, To: models.STOPPED},
+}
+
 type (
+	containerTransition struct {
+		From []models.ContainerState
+		To   models.ContainerState
+	}
+
 	AddContainerJob struct {
+		// ID of a container is chosen on enqueue, so that the job is found by it before it runs
+		ID         string `json:"id,omitempty"`
 		Name       string `json:"name"`
 		OSTemplate string `json:"ostemplate"`
+		// Parameters are set right after a container is created
+		Parameters openvzcmd.Options `json:"parameters,omitempty"`
+	}
+
+	// ContainerJob is a payload of jobs operating on an existing container
+	ContainerJob struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+
+	// UpdateContainerJob merges Parameters into parameters of a container, or replaces them
+	UpdateContainerJob struct {
+		ContainerJob
+		Parameters api.ContainerParametersPatch `json:"parameters"`
+		Replace    bool                         `json:"replace,omitempty"`
+	}
+
+	// CloneContainerJob copies a container given by ContainerJob to a new one named CloneName
+	CloneContainerJob struct {
+		ContainerJob
+		CloneName  string `json:"clone_name"`
+		OSTemplate string `json:"ostemplate"`
+		// Parameters of a clone, i.e. ones of a source with Overrides applied
+		Parameters openvzcmd.Options `json:"parameters,omitempty"`
+		// Overrides are set on a clone right after it is copied
+		Overrides openvzcmd.Options `json:"overrides,omitempty"`
+	}
+
+	// SnapshotJob is a payload of jobs operating on a snapshot of a container
+	SnapshotJob struct {
+		ContainerJob
+		SnapshotID          string `json:"snapshot_id"`
+		SnapshotName        string `json:"snapshot_name,omitempty"`
+		SnapshotDescription string `json:"snapshot_description,omitempty"`
 	}
 
+	// BackupJob is a payload of jobs operating on a backup of a container
+	BackupJob struct {
+		ContainerJob
+		BackupID string `json:"backup_id"`
+	}
+
+	// RestoreBackupJob restores a backup into a container given by ContainerJob,
+	// which is created if NewContainer is set, or replaced otherwise
+	RestoreBackupJob struct {
+		BackupJob
+		NewContainer bool `json:"new_container,omitempty"`
+	}
+
+	// containerEntity is a payload of a job operating on a container known before the job runs
+	containerEntity interface {
+		containerID() string
+	}
+
+	// JobHandler executes a job and returns ID of a container it operates on.
+	// ctx is cancelled when the job is cancelled.
+	JobHandler func(ctx context.Context, job *models.Job) (string, error)
+
 	JobService struct {
-		DB        DBConnection
-		Commander *openvzcmd.POCCommanderStub
+		JobRepo       repositories.JobRepository
+		ContainerRepo repositories.ContainerRepository
+		SnapshotRepo  repositories.SnapshotRepository
+		BackupRepo    repositories.BackupRepository
+		PolicyRepo    repositories.PolicyRepository
+		Commander     commanders.Commander
+		// Webhooks queues deliveries of events reporting a status of a job or a container
+		Webhooks *WebhookService
+		// BackupDir is a local directory backups are made into, empty if a commander keeps them elsewhere
+		BackupDir string
+		// Host is a name of the host jobs are run on, which jobs are enqueued and claimed for
+		Host string
+		// HostConcurrency limits number of jobs running on the host at once, 0 means no limit
+		HostConcurrency int
+		// LeaseDuration is how long a locked job may go without a heartbeat before it is reaped
+		LeaseDuration time.Duration
+		// DefaultRetryPolicy applies to job types having no own retry policy
+		DefaultRetryPolicy RetryPolicy
+		// DryRun makes every job requested via API a dry run
+		DryRun         bool
+		handlers       map[string]JobHandler
+		dryRunHandlers map[string]JobHandler
+		retryPolicies  map[string]RetryPolicy
+		Events         *events.Bus
+		running        map[string]context.CancelFunc
+		runningMu      sync.Mutex
 	}
 )
 
-func NewJobService(db DBConnection, cmd *openvzcmd.POCCommanderStub) *JobService {
-	return &JobService{
-		DB:        db,
-		Commander: cmd,
+func NewJobService(db DBConnection, cmd commanders.Commander, bus *events.Bus) *JobService {
+	j := &JobService{
+		JobRepo:            repositories.NewJobRepository(db),
+		ContainerRepo:      repositories.NewContainerRepository(db),
+		SnapshotRepo:       repositories.NewSnapshotRepository(db),
+		BackupRepo:         repositories.NewBackupRepository(db),
+		PolicyRepo:         repositories.NewPolicyRepository(db),
+		Commander:          cmd,
+		Webhooks:           NewWebhookService(db),
+		Events:             bus,
+		LeaseDuration:      DefaultLeaseDuration,
+		DefaultRetryPolicy: NewDefaultRetryPolicy(),
+		handlers:           make(map[string]JobHandler),
+		dryRunHandlers:     make(map[string]JobHandler),
+		retryPolicies:      make(map[string]RetryPolicy),
+		running:            make(map[string]context.CancelFunc),
+	}
+
+	j.RegisterHandler(AddContainerType, j.addContainer)
+	j.RegisterHandler(UpdateContainerType, j.updateContainer)
+	j.RegisterHandler(DeleteContainerType, j.deleteContainer)
+	for _, jobType := range ContainerActionTypes {
+		j.RegisterHandler(jobType, j.runContainerAction)
+	}
+	j.RegisterHandler(CloneContainerType, j.cloneContainer)
+	j.RegisterHandler(CreateSnapshotType, j.createSnapshot)
+	j.RegisterHandler(DeleteSnapshotType, j.deleteSnapshot)
+	j.RegisterHandler(RevertSnapshotType, j.revertSnapshot)
+	j.RegisterHandler(CreateBackupType, j.createBackup)
+	j.RegisterHandler(DeleteBackupType, j.deleteBackup)
+	j.RegisterHandler(RestoreBackupType, j.restoreBackup)
+
+	j.RegisterDryRunHandler(AddContainerType, j.dryRunAddContainer)
+	j.RegisterDryRunHandler(UpdateContainerType, j.dryRunUpdateContainer)
+	j.RegisterDryRunHandler(DeleteContainerType, j.dryRunDeleteContainer)
+	for _, jobType := range ContainerActionTypes {
+		j.RegisterDryRunHandler(jobType, j.dryRunContainerAction)
+	}
+	j.RegisterDryRunHandler(CloneContainerType, j.dryRunCloneContainer)
+	j.RegisterDryRunHandler(CreateSnapshotType, j.dryRunCreateSnapshot)
+	j.RegisterDryRunHandler(DeleteSnapshotType, j.dryRunDeleteSnapshot)
+	j.RegisterDryRunHandler(RevertSnapshotType, j.dryRunRevertSnapshot)
+	j.RegisterDryRunHandler(CreateBackupType, j.dryRunCreateBackup)
+	j.RegisterDryRunHandler(DeleteBackupType, j.dryRunDeleteBackup)
+	j.RegisterDryRunHandler(RestoreBackupType, j.dryRunRestoreBackup)
+
+	return j
+}
+
+// RegisterHandler sets a handler executing jobs of given type
+func (j *JobService) RegisterHandler(jobType string, handler JobHandler) {
+	j.handlers[jobType] = handler
+}
+
+// RegisterDryRunHandler sets a handler rendering commands of jobs of given type without
+// changing containers. ctx of the handler makes commanders record commands only.
+func (j *JobService) RegisterDryRunHandler(jobType string, handler JobHandler) {
+	j.dryRunHandlers[jobType] = handler
+}
+
+// Enqueue adds a pending job of given type and returns its ID.
+// The job refers to a container its payload operates on, if it is known before the job runs.
+// Uniqueness of an idempotency key and a reserved name is enforced by the database,
+// so a request racing with another one gets its job or ErrDuplicateName.
+func (j *JobService) Enqueue(jobType string, payloadJob interface{}, opts EnqueueOptions) (string, error) {
+	payload, err := json.Marshal(payloadJob)
+	if err != nil {
+		return "", err
+	}
+
+	job := &models.Job{
+		ID:          uuid.New().String(),
+		Type:        jobType,
+		Status:      models.PENDING,
+		Payload:     payload,
+		MaxAttempts: j.RetryPolicy(jobType).MaxAttempts,
+	}
+	if entity, ok := payloadJob.(containerEntity); ok && entity.containerID() != "" {
+		job.EntityType = sql.NullString{String: ContainerType, Valid: true}
+		job.EntityID = sql.NullString{String: entity.containerID(), Valid: true}
+	}
+
+	opts.Host = j.Host
+	err = j.JobRepo.Create(job, opts)
+	if err != nil {
+		if replayedID, replayErr := j.Replay(opts); replayErr != nil || replayedID != "" {
+			return replayedID, replayErr
+		}
+		if opts.ReservedName != "" {
+			if reserved, _ := j.JobRepo.IsNameReserved(opts.ReservedName); reserved {
+				return "", ErrDuplicateName
+			}
+		}
+		return "", err
 	}
+
+	return job.ID, nil
+}
+
+func (p AddContainerJob) containerID() string {
+	return p.ID
+}
+
+func (p ContainerJob) containerID() string {
+	return p.ID
 }
 
-func (j *JobService) ConsumeJobs(jobInterval time.Duration) {
-	var err error
+// submit enqueues a job requested via API, or only renders its commands if it is a dry run
+func (j *JobService) submit(jobType string, job interface{}, opts EnqueueOptions, dryRun bool) (*api.JobResponse, error) {
+	if dryRun || j.DryRun {
+		jobID, commands, err := j.dryRun(jobType, job)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := jobResponse(jobID)
+		resp.DryRun = true
+		resp.Commands = commands
+
+		return resp, nil
+	}
+
+	jobID, err := j.Enqueue(jobType, job, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return jobResponse(jobID), nil
+}
+
+// checkNameIsFree returns ErrDuplicateName if a container with given name exists or is being created
+func (j *JobService) checkNameIsFree(name string) error {
+	reserved, err := j.JobRepo.IsNameReserved(name)
+	if err != nil {
+		return err
+	}
+	found, err := j.ContainerRepo.HasName(name)
+	if err != nil {
+		return err
+	}
+	if reserved || found {
+		return ErrDuplicateName
+	}
+
+	return nil
+}
+
+// ConsumeJobs runs a pool of workers processing jobs and a reaper of expired leases.
+// A worker waits jobInterval only when there are no jobs to pick.
+func (j *JobService) ConsumeJobs(workers int, jobInterval time.Duration) {
+	var wg sync.WaitGroup
+
+	// Jobs enqueued before the host is named would be never claimed otherwise
+	if j.Host != "" {
+		adopted, err := j.JobRepo.Adopt(j.Host)
+		if err != nil {
+			log.Printf("Jobs without a host are not adopted: %s", err.Error())
+		} else if adopted > 0 {
+			log.Printf("Adopted %d job(s) without a host.", adopted)
+		}
+	}
+
+	go j.reapJobs(jobInterval)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.work(jobInterval)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (j *JobService) work(jobInterval time.Duration) {
 	for {
-		err = j.consumeJob()
+		picked, err := j.consumeJob()
 		if err != nil {
 			log.Println(err.Error()) // just log...
 		}
-		time.Sleep(jobInterval)
+		if !picked {
+			time.Sleep(jobInterval)
+		}
 	}
 }
 
-func (j *JobService) consumeJob() error {
-	var err error
-
-	job := j.pickJob()
+func (j *JobService) consumeJob() (bool, error) {
+	job, err := j.claimJob()
+	if err != nil {
+		return false, err
+	}
 	if job == nil {
 		log.Printf("No jobs.")
-		return nil
+		return false, nil
 	}
 
-	err = j.lockJob(job.ID)
-	if err != nil {
+	return true, j.runJob(job)
+}
+
+func (j *JobService) runJob(job *models.Job) error {
+	handler, ok := j.handlers[job.Type]
+	if !ok {
+		return j.updateJobStatus(job, "", fmt.Errorf("%w %s", ErrUnknownJobType, job.Type))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = commanders.WithRecorder(ctx, &jobRecorder{Repo: j.JobRepo, Events: j.Events, JobID: job.ID})
+	j.setRunning(job.ID, cancel)
+	defer j.setRunning(job.ID, nil)
+
+	done := make(chan struct{})
+	go j.heartbeat(job, cancel, done)
+
+	j.publishProgress(job.ID, 0)
+	id, err := handler(ctx, job)
+	close(done)
+
+	// A job is cancelled only if its handler gave up, one which finished regardless is done or failed
+	if errors.Is(err, context.Canceled) {
+		cancelled, err := j.JobRepo.MarkCancelled(job)
+		if err == nil && cancelled {
+			j.publishStatus(job, models.JobStatusNames[models.CANCELLED], id, nil)
+		}
 		return err
 	}
 
-	req := j.parseAddContainerRequest(job)
-	if req == nil {
-		return errors.New("CANNOT be parsed - skipped!")
+	return j.updateJobStatus(job, id, err)
+}
+
+func (j *JobService) setRunning(jobID string, cancel context.CancelFunc) {
+	j.runningMu.Lock()
+	defer j.runningMu.Unlock()
+
+	if cancel == nil {
+		delete(j.running, jobID)
+		return
 	}
+	j.running[jobID] = cancel
+}
+
+// heartbeat extends a lease of a running job until done is closed. It also cancels
+// the job when its cancellation was requested, possibly by another process, or its lease
+// is lost, so that a job reaped and claimed again does not run twice at once.
+func (j *JobService) heartbeat(job *models.Job, cancel context.CancelFunc, done <-chan struct{}) {
+	ticker := time.NewTicker(j.LeaseDuration / 3)
+	defer ticker.Stop()
 
-	id := uuid.New().String() // UUID of container
+	extendedAt := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			owned, err := j.JobRepo.Heartbeat(job, time.Now().UTC())
+			switch {
+			case err != nil:
+				log.Println(err.Error())
+				// A lease not extended for its duration may be reaped by any process
+				if time.Since(extendedAt) >= j.LeaseDuration {
+					log.Printf("Lease of job %s has expired, the job is cancelled.", job.ID)
+					cancel()
+					return
+				}
+			case !owned:
+				log.Printf("Lease of job %s is lost, the job is cancelled.", job.ID)
+				cancel()
+				return
+			default:
+				extendedAt = time.Now()
+			}
 
-	err = j.Commander.CreateContainer(req.Name, req.OSTemplate, nil)
+			cancelRequested, err := j.JobRepo.IsCancelRequested(job.ID)
+			if err != nil {
+				log.Println(err.Error())
+			}
+			if cancelRequested {
+				cancel()
+			}
+		}
+	}
+}
 
-	err = j.updateJobStatus(job.ID, id, err)
+// Cancel cancels a pending job, or requests cancellation of a running one.
+// Running jobs of this process are aborted at once, while ones of other processes
+// are aborted on their next heartbeat.
+func (j *JobService) Cancel(jobID string) error {
+	cancelled, err := j.JobRepo.CancelPending(jobID)
 	if err != nil {
 		return err
 	}
+	if cancelled {
+		job, err := j.JobRepo.FindByID(jobID)
+		if err != nil {
+			return err
+		}
+		j.abandonJob(job.Type, job.Payload)
+		j.Events.Publish(JobStatusEventType, jobID, &api.JobStatusEvent{Status: models.JobStatusNames[models.CANCELLED]})
+		return nil
+	}
 
-	_, err = j.DB.Exec("INSERT INTO containers (id, name, os_template) VALUES (?, ?, ?)", id, req.Name, req.OSTemplate)
+	requested, err := j.JobRepo.RequestCancel(jobID)
+	if err != nil {
+		return err
+	}
+	if !requested {
+		return ErrJobNotCancellable
+	}
 
-	return err
+	j.runningMu.Lock()
+	cancel, ok := j.running[jobID]
+	j.runningMu.Unlock()
+	if ok {
+		cancel()
+	}
+
+	return nil
+}
+
+// reapJobs periodically recovers jobs whose lease has expired, e.g. since their
+// process crashed: they are requeued, or failed when out of attempts.
+func (j *JobService) reapJobs(interval time.Duration) {
+	for {
+		err := j.reapExpiredJobs()
+		if err != nil {
+			log.Println(err.Error())
+		}
+		time.Sleep(interval)
+	}
 }
 
-func (j *JobService) pickJob() *models.Job {
-	var job models.Job
+func (j *JobService) reapExpiredJobs() error {
+	expiredAt := time.Now().UTC().Add(-j.LeaseDuration)
 
-	err := j.DB.Get(&job, "SELECT id, payload, type FROM jobs WHERE status=? AND locked_at IS NULL ORDER BY created_at LIMIT 1", models.PENDING)
-	switch {
-	case err == sql.ErrNoRows:
-		return nil
-	case err != nil:
-		log.Fatal(err)
+	failed, requeued, err := j.JobRepo.ReapExpired(expiredAt, "lease expired")
+	if len(failed) > 0 {
+		log.Printf("Failed %d expired job(s).", len(failed))
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d expired job(s).", requeued)
+	}
+	for _, job := range failed {
+		j.abandonJob(job.Type, job.Payload)
+		j.publishStatus(job, models.JobStatusNames[models.FAILED], "", errors.New(job.LastError.String))
 	}
 
-	return &job
+	return err
 }
 
-func (j *JobService) parseAddContainerRequest(job *models.Job) *api.AddContainerRequest {
-	var err error
-	var req *api.AddContainerRequest
-
-	switch job.Type {
-	case AddContainerType:
-		err = json.Unmarshal(job.Payload, &req)
-		if err != nil {
-			log.Fatal(err)
+func canTransition(jobType string, state models.ContainerState) bool {
+	for _, from := range containerTransitions[jobType].From {
+		if from == state {
+			return true
 		}
 	}
 
-	return req
+	return false
 }
 
-func (j *JobService) lockJob(jobID string) error {
-	_, err := j.DB.Exec("UPDATE jobs SET locked_at=? WHERE id=?", time.Now().UTC(), jobID)
-	return err
+// claimJob leases the oldest pending job of the host, see JobRepository.Claim
+func (j *JobService) claimJob() (*models.Job, error) {
+	limit := j.HostConcurrency
+	if limit < 0 {
+		limit = 0
+	}
+
+	return j.JobRepo.Claim(j.Host, uuid.New().String(), time.Now().UTC(), limit)
 }
 
-func (j *JobService) updateJobStatus(jobID, id string, err error) error {
-	if err != nil {
-		_, err = j.DB.Exec("UPDATE jobs SET status=?, error_descr=?, locked_at=NULL WHERE id=?", models.FAILED, err.Error(), jobID)
-		return err
+// updateJobStatus completes a job, or schedules its retry when err is retryable
+// and attempts are left.
+func (j *JobService) updateJobStatus(job *models.Job, id string, err error) error {
+	var updated bool
+	var dbErr error
+	var status models.JobStatus
+
+	switch {
+	case err != nil && j.willRetry(job, err):
+		status = models.PENDING
+		nextRunAt := time.Now().UTC().Add(j.RetryPolicy(job.Type).Delay(job.Attempts))
+		updated, dbErr = j.JobRepo.ScheduleRetry(job, err.Error(), nextRunAt)
+	case err != nil:
+		status = models.FAILED
+		updated, dbErr = j.JobRepo.MarkFailed(job, err.Error())
+	default:
+		status = models.DONE
+		updated, dbErr = j.JobRepo.MarkDone(job, ContainerType, id)
+	}
+	if dbErr != nil {
+		return dbErr
+	}
+	// The lease was lost, e.g. reaped, so the job is someone else's now
+	if !updated {
+		log.Printf("Lease of job %s is lost, its status is not updated.", job.ID)
+		return nil
 	}
-	_, err = j.DB.Exec("UPDATE jobs SET status=?, locked_at=NULL, entity_type=?, entity_id=? WHERE id=?", models.DONE, ContainerType, id, jobID)
 
-	return err
+	if status == models.DONE {
+		j.publishProgress(job.ID, 100)
+	}
+	j.publishStatus(job, models.JobStatusNames[status], id, err)
+
+	return nil
+}
+
+func (j *JobService) setContainerState(id string, state models.ContainerState) error {
+	return j.ContainerRepo.SetState(id, state)
 }
@@ -0,0 +1,228 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+// enqueueTestJob adds a pending job of given type
+func enqueueTestJob(t *testing.T, j *JobService, jobType string, payload interface{}) string {
+	t.Helper()
+
+	jobID, err := j.Enqueue(jobType, payload, EnqueueOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return jobID
+}
+
+// findTestJob returns a job with its status and error
+func findTestJob(t *testing.T, j *JobService, jobID string) (models.JobStatus, string) {
+	t.Helper()
+
+	job, err := j.JobRepo.FindByID(jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return job.Status, job.LastError.String
+}
+
+func TestJobDispatchedToRegisteredHandler(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+
+	var handled *models.Job
+	j.RegisterHandler("noop", func(ctx context.Context, job *models.Job) (string, error) {
+		handled = job
+		return "", nil
+	})
+	j.RegisterHandler("broken", func(ctx context.Context, job *models.Job) (string, error) {
+		return "", commanders.Permanent(errors.New("broken"))
+	})
+
+	noopID := enqueueTestJob(t, j, "noop", struct{}{})
+	brokenID := enqueueTestJob(t, j, "broken", struct{}{})
+	unknownID := enqueueTestJob(t, j, "unknown", struct{}{})
+	runTestJobs(t, j)
+
+	if handled == nil || handled.ID != noopID {
+		t.Errorf("Handled job = %+v, want %s", handled, noopID)
+	}
+	if status, _ := findTestJob(t, j, noopID); status != models.DONE {
+		t.Errorf("Status = %v, want done", status)
+	}
+	if status, descr := findTestJob(t, j, brokenID); status != models.FAILED || descr != "broken" {
+		t.Errorf("Status = %v %q, want failed with its error", status, descr)
+	}
+	if status, descr := findTestJob(t, j, unknownID); status != models.FAILED || descr != "unknown job type unknown" {
+		t.Errorf("Status = %v %q, want failed as unknown", status, descr)
+	}
+}
+
+func TestConcurrentClaimsPickEachJobOnce(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+
+	const jobs, workers = 20, 4
+	for i := 0; i < jobs; i++ {
+		enqueueTestJob(t, j, "noop", struct{}{})
+	}
+
+	var mu sync.Mutex
+	claimed := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := j.claimJob()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if job == nil {
+					return
+				}
+				mu.Lock()
+				claimed[job.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != jobs {
+		t.Errorf("Claimed %d jobs, want %d", len(claimed), jobs)
+	}
+	for id, n := range claimed {
+		if n != 1 {
+			t.Errorf("Job %s is claimed %d times", id, n)
+		}
+	}
+}
+
+func TestClaimRespectsHostConcurrency(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+	j.HostConcurrency = 1
+
+	enqueueTestJob(t, j, "noop", struct{}{})
+	enqueueTestJob(t, j, "noop", struct{}{})
+
+	first, err := j.claimJob()
+	if err != nil || first == nil {
+		t.Fatalf("claimJob() = %v, %v", first, err)
+	}
+	if job, err := j.claimJob(); err != nil || job != nil {
+		t.Fatalf("claimJob() over the limit = %v, %v, want none", job, err)
+	}
+
+	if err := j.updateJobStatus(first, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if job, err := j.claimJob(); err != nil || job == nil {
+		t.Errorf("claimJob() after a job is done = %v, %v", job, err)
+	}
+}
+
+func TestJobsRunOnTheirHost(t *testing.T) {
+	db := newTestDB(t)
+	first := NewJobService(db, nil, events.NewBus())
+	first.Host = "host-1"
+	second := NewJobService(db, nil, events.NewBus())
+	second.Host = "host-2"
+
+	jobID := enqueueTestJob(t, first, "noop", struct{}{})
+	if job, err := second.claimJob(); err != nil || job != nil {
+		t.Fatalf("claimJob() of another host = %v, %v, want none", job, err)
+	}
+	if job, err := first.claimJob(); err != nil || job == nil || job.ID != jobID {
+		t.Errorf("claimJob() = %v, %v, want job %s", job, err, jobID)
+	}
+}
+
+func TestReapExpiredJobs(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+	j.DefaultRetryPolicy.MaxAttempts = 2
+
+	jobID := enqueueTestJob(t, j, "noop", struct{}{})
+	expire := func() *models.Job {
+		t.Helper()
+
+		job, err := j.claimJob()
+		if err != nil || job == nil || job.ID != jobID {
+			t.Fatalf("claimJob() = %v, %v, want %s", job, err, jobID)
+		}
+		db.MustExec("UPDATE jobs SET heartbeat_at=? WHERE id=?", time.Now().UTC().Add(-2*j.LeaseDuration), jobID)
+		if err := j.reapExpiredJobs(); err != nil {
+			t.Fatal(err)
+		}
+
+		return job
+	}
+
+	// The first expired lease requeues the job, and its worker cannot finish it anymore
+	stale := expire()
+	if status, _ := findTestJob(t, j, jobID); status != models.PENDING {
+		t.Errorf("Status after the first lease = %v, want pending", status)
+	}
+	if err := j.updateJobStatus(stale, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := findTestJob(t, j, jobID); status != models.PENDING {
+		t.Errorf("Status updated by a stale worker = %v, want pending", status)
+	}
+
+	// The last attempt fails the job
+	expire()
+	if status, descr := findTestJob(t, j, jobID); status != models.FAILED || descr != "lease expired" {
+		t.Errorf("Status after the last lease = %v %q, want failed", status, descr)
+	}
+}
+
+func TestHeartbeatKeepsLease(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+	j.LeaseDuration = 30 * time.Millisecond
+
+	j.RegisterHandler("slow", func(ctx context.Context, job *models.Job) (string, error) {
+		time.Sleep(4 * j.LeaseDuration)
+		if err := j.reapExpiredJobs(); err != nil {
+			return "", err
+		}
+		return "", nil
+	})
+	jobID := enqueueTestJob(t, j, "slow", struct{}{})
+	runTestJobs(t, j)
+
+	if status, descr := findTestJob(t, j, jobID); status != models.DONE {
+		t.Errorf("Status = %v %q, want done", status, descr)
+	}
+}
@@ -0,0 +1,324 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/models"
+	"github.com/romiras/go-openvz-api/repositories"
+)
+
+// DefaultPreviewRuns is a number of upcoming runs of a policy shown in its preview
+const DefaultPreviewRuns = 5
+
+// containerArtifacts are backups or snapshots of a container made by a policy
+type containerArtifacts struct {
+	ContainerID   string
+	ContainerName string
+	Artifacts     []*retentionArtifact
+}
+
+type PolicyAPIService struct {
+	PolicyRepo    repositories.PolicyRepository
+	ContainerRepo repositories.ContainerRepository
+	BackupRepo    repositories.BackupRepository
+	SnapshotRepo  repositories.SnapshotRepository
+}
+
+func NewPolicyAPIService(db DBConnection) *PolicyAPIService {
+	return &PolicyAPIService{
+		PolicyRepo:    repositories.NewPolicyRepository(db),
+		ContainerRepo: repositories.NewContainerRepository(db),
+		BackupRepo:    repositories.NewBackupRepository(db),
+		SnapshotRepo:  repositories.NewSnapshotRepository(db),
+	}
+}
+
+// Create adds a policy, which first runs at the next time of its schedule
+func (srv *PolicyAPIService) Create(req *api.AddPolicyRequest) (*api.PolicyResponse, error) {
+	schedule, err := api.ParseSchedule(req.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	policy := &models.Policy{
+		ID:          uuid.New().String(),
+		Name:        req.Name,
+		Kind:        req.Kind,
+		Schedule:    req.Schedule,
+		KeepLast:    req.KeepLast,
+		KeepDaily:   req.KeepDaily,
+		KeepWeekly:  req.KeepWeekly,
+		KeepMonthly: req.KeepMonthly,
+		NextRunAt:   sql.NullTime{Time: schedule.Next(now), Valid: true},
+		CreatedAt:   now,
+	}
+	err = srv.PolicyRepo.Create(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.PolicyResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Policy: policyInfo(policy, []string{}),
+	}, nil
+}
+
+func (srv *PolicyAPIService) List() (*api.ListPoliciesResponse, error) {
+	policies, err := srv.PolicyRepo.List()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*api.PolicyInfo, 0, len(policies))
+	for _, policy := range policies {
+		containerIDs, err := srv.PolicyRepo.ContainerIDs(policy.ID)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, policyInfo(policy, containerIDs))
+	}
+
+	return &api.ListPoliciesResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Policies: infos,
+	}, nil
+}
+
+func (srv *PolicyAPIService) GetById(id string) (*api.PolicyResponse, error) {
+	policy, err := srv.PolicyRepo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return srv.policyResponse(policy)
+}
+
+// Delete deletes a policy. Backups and snapshots made by it are kept, but no longer pruned.
+func (srv *PolicyAPIService) Delete(id string) (*api.ApiResponse, error) {
+	_, err := srv.PolicyRepo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv.PolicyRepo.Delete(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ApiResponse{
+		Code:    0,
+		Message: "success",
+	}, nil
+}
+
+// Attach attaches a policy to an existing container. Attaching it again changes nothing.
+func (srv *PolicyAPIService) Attach(req *api.AttachPolicyRequest) (*api.PolicyResponse, error) {
+	policy, err := srv.PolicyRepo.FindByID(req.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = srv.ContainerRepo.FindByID(req.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv.PolicyRepo.Attach(policy.ID, req.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return srv.policyResponse(policy)
+}
+
+func (srv *PolicyAPIService) Detach(id, containerID string) (*api.PolicyResponse, error) {
+	policy, err := srv.PolicyRepo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv.PolicyRepo.Detach(policy.ID, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return srv.policyResponse(policy)
+}
+
+// Preview shows upcoming runs of a policy, and which backups or snapshots made by it
+// are kept or pruned by its rules now
+func (srv *PolicyAPIService) Preview(id string, runs int) (*api.PolicyPreviewResponse, error) {
+	policy, err := srv.PolicyRepo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule, err := api.ParseSchedule(policy.Schedule)
+	if err != nil {
+		return nil, err
+	}
+
+	nextRuns := make([]time.Time, 0, runs)
+	next := time.Now().UTC()
+	for i := 0; i < runs; i++ {
+		next = schedule.Next(next)
+		nextRuns = append(nextRuns, next)
+	}
+
+	retentions, err := srv.evaluate(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.PolicyPreviewResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		NextRuns:   nextRuns,
+		Containers: retentions,
+	}, nil
+}
+
+// evaluate applies retention rules of a policy to artifacts it made, separately for every container.
+// Artifacts are found by the policy which made them, so that ones of containers detached from it
+// or deleted are pruned too.
+func (srv *PolicyAPIService) evaluate(policy *models.Policy) ([]*api.ContainerRetention, error) {
+	groups, err := srv.artifacts(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	retentions := make([]*api.ContainerRetention, 0, len(groups))
+	for _, group := range groups {
+		keep, prune := retain(policy, group.Artifacts)
+		retentions = append(retentions, &api.ContainerRetention{
+			ContainerID:   group.ContainerID,
+			ContainerName: group.ContainerName,
+			Keep:          keep,
+			Prune:         prune,
+		})
+	}
+
+	return retentions, nil
+}
+
+// artifacts returns backups or snapshots made by a policy, grouped by containers. Containers attached
+// to the policy come first, even if it made nothing of them yet.
+func (srv *PolicyAPIService) artifacts(policy *models.Policy) ([]*containerArtifacts, error) {
+	groups := make([]*containerArtifacts, 0)
+	byContainer := make(map[string]*containerArtifacts)
+	group := func(containerID, containerName string) *containerArtifacts {
+		g, ok := byContainer[containerID]
+		if !ok {
+			g = &containerArtifacts{ContainerID: containerID, ContainerName: containerName, Artifacts: make([]*retentionArtifact, 0)}
+			byContainer[containerID] = g
+			groups = append(groups, g)
+		}
+		return g
+	}
+
+	containerIDs, err := srv.PolicyRepo.ContainerIDs(policy.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, containerID := range containerIDs {
+		container, err := srv.ContainerRepo.FindByID(containerID)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		group(container.ID, container.Name)
+	}
+
+	if policy.Kind == models.PolicyBackup {
+		// Backups outlive containers and keep their names
+		backups, err := srv.BackupRepo.ListByPolicy(policy.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, backup := range backups {
+			g := group(backup.ContainerID, backup.ContainerName)
+			g.Artifacts = append(g.Artifacts, &retentionArtifact{ID: backup.ID, State: backup.State, CreatedAt: backup.CreatedAt})
+		}
+
+		return groups, nil
+	}
+
+	snapshots, err := srv.SnapshotRepo.ListByPolicy(policy.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, snapshot := range snapshots {
+		g, ok := byContainer[snapshot.ContainerID]
+		if !ok {
+			// Snapshots are deleted along with their containers
+			container, err := srv.ContainerRepo.FindByID(snapshot.ContainerID)
+			if err == sql.ErrNoRows {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			g = group(container.ID, container.Name)
+		}
+		g.Artifacts = append(g.Artifacts, &retentionArtifact{ID: snapshot.ID, State: snapshot.State, CreatedAt: snapshot.CreatedAt})
+	}
+
+	return groups, nil
+}
+
+func (srv *PolicyAPIService) policyResponse(policy *models.Policy) (*api.PolicyResponse, error) {
+	containerIDs, err := srv.PolicyRepo.ContainerIDs(policy.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.PolicyResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Policy: policyInfo(policy, containerIDs),
+	}, nil
+}
+
+func policyInfo(policy *models.Policy, containerIDs []string) *api.PolicyInfo {
+	info := &api.PolicyInfo{
+		Policy:       policy,
+		ContainerIDs: containerIDs,
+	}
+	if policy.NextRunAt.Valid {
+		info.NextRunAt = &policy.NextRunAt.Time
+	}
+
+	return info
+}
@@ -0,0 +1,267 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/models"
+	"github.com/romiras/go-openvz-api/repositories"
+)
+
+const DefaultReconcileInterval = 60 * time.Second
+
+// hostStates maps states of containers on a host to their states in the database
+var hostStates = map[string]models.ContainerState{
+	commanders.HostStopped:   models.STOPPED,
+	commanders.HostRunning:   models.RUNNING,
+	commanders.HostSuspended: models.SUSPENDED,
+}
+
+// ReconcileService compares containers in the database to ones on a host and reports drift.
+// Optionally, unmanaged containers are imported and vanished ones are marked as missing.
+type ReconcileService struct {
+	ContainerRepo repositories.ContainerRepository
+	JobRepo       repositories.JobRepository
+	Commander     commanders.Commander
+	// ImportUnmanaged makes containers found on a host only be added to the database
+	ImportUnmanaged bool
+	// MarkVanished makes containers not found on a host be marked as missing
+	MarkVanished bool
+	report       *api.DriftResponse
+	mu           sync.Mutex
+}
+
+func NewReconcileService(db DBConnection, cmd commanders.Commander) *ReconcileService {
+	return &ReconcileService{
+		ContainerRepo: repositories.NewContainerRepository(db),
+		JobRepo:       repositories.NewJobRepository(db),
+		Commander:     cmd,
+	}
+}
+
+// Run reconciles containers every interval
+func (srv *ReconcileService) Run(interval time.Duration) {
+	for {
+		if _, err := srv.Reconcile(); err != nil {
+			log.Printf("Reconciliation failed: %s", err.Error())
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Drift returns the latest drift report. A new report is made if refresh is set or there is none yet.
+func (srv *ReconcileService) Drift(refresh bool) (*api.DriftResponse, error) {
+	srv.mu.Lock()
+	report := srv.report
+	srv.mu.Unlock()
+
+	if report == nil || refresh {
+		return srv.Reconcile()
+	}
+
+	return report, nil
+}
+
+// Reconcile compares containers in the database to ones on a host. Containers being created
+// are skipped, as they may be found on a host before their jobs are done.
+func (srv *ReconcileService) Reconcile() (*api.DriftResponse, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	hostContainers, err := srv.Commander.ListContainers(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := srv.ContainerRepo.ListAll()
+	if err != nil {
+		return nil, err
+	}
+
+	onHost := make(map[string]commanders.HostContainer, len(hostContainers))
+	for _, hc := range hostContainers {
+		onHost[hc.Name] = hc
+	}
+
+	drift := make([]*api.DriftItem, 0)
+	for _, container := range containers {
+		if container.State == models.CREATING {
+			continue
+		}
+
+		hc, ok := onHost[container.Name]
+		if !ok {
+			item, err := srv.reconcileMissing(container)
+			if err != nil {
+				return nil, err
+			}
+			drift = append(drift, item)
+			continue
+		}
+		delete(onHost, container.Name)
+
+		items, err := srv.reconcileFound(container, hc)
+		if err != nil {
+			return nil, err
+		}
+		drift = append(drift, items...)
+	}
+
+	names := make([]string, 0, len(onHost))
+	for name := range onHost {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		reserved, err := srv.JobRepo.IsNameReserved(name)
+		if err != nil {
+			return nil, err
+		}
+		if reserved {
+			continue
+		}
+
+		item, err := srv.reconcileUnmanaged(onHost[name])
+		if err != nil {
+			return nil, err
+		}
+		drift = append(drift, item)
+	}
+
+	srv.report = &api.DriftResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		CheckedAt: time.Now().UTC(),
+		Drift:     drift,
+	}
+	if len(drift) > 0 {
+		log.Printf("Found %d drifted container(s).", len(drift))
+	}
+
+	return srv.report, nil
+}
+
+func (srv *ReconcileService) reconcileMissing(container *models.Container) (*api.DriftItem, error) {
+	item := &api.DriftItem{
+		Kind:        api.DriftMissing,
+		Name:        container.Name,
+		ContainerID: container.ID,
+	}
+
+	if srv.MarkVanished && container.State != models.MISSING {
+		if err := srv.ContainerRepo.SetState(container.ID, models.MISSING); err != nil {
+			return nil, err
+		}
+		item.Resolution = api.DriftMarked
+	}
+
+	return item, nil
+}
+
+// reconcileFound compares a container to its counterpart on a host. A container marked
+// as missing is restored once it appears on a host again.
+func (srv *ReconcileService) reconcileFound(container *models.Container, hc commanders.HostContainer) ([]*api.DriftItem, error) {
+	items := make([]*api.DriftItem, 0)
+	mismatch := func(field, expected, actual string) *api.DriftItem {
+		item := &api.DriftItem{
+			Kind:        api.DriftMismatch,
+			Name:        container.Name,
+			ContainerID: container.ID,
+			HostID:      hc.ID,
+			Field:       field,
+			Expected:    expected,
+			Actual:      actual,
+		}
+		items = append(items, item)
+		return item
+	}
+
+	state := hostStates[hc.State]
+	if container.State != state {
+		item := mismatch("state", string(container.State), string(state))
+		if srv.MarkVanished && container.State == models.MISSING {
+			if err := srv.ContainerRepo.SetState(container.ID, state); err != nil {
+				return nil, err
+			}
+			item.Resolution = api.DriftRestored
+		}
+	}
+	if hc.OSTemplate != "" && hc.OSTemplate != container.OSTemplate {
+		mismatch("ostemplate", container.OSTemplate, hc.OSTemplate)
+	}
+	for _, name := range api.ParameterMismatches(container.Parameters, hc.Parameters) {
+		mismatch(name, api.FormatParameter(container.Parameters, name), api.FormatParameter(hc.Parameters, name))
+	}
+
+	return items, nil
+}
+
+// reconcileUnmanaged reports a container found on a host only, and imports it if asked to.
+// A container whose OS template is not reported is not imported, as it could not be cloned.
+func (srv *ReconcileService) reconcileUnmanaged(hc commanders.HostContainer) (*api.DriftItem, error) {
+	item := &api.DriftItem{
+		Kind:   api.DriftUnmanaged,
+		Name:   hc.Name,
+		HostID: hc.ID,
+	}
+	if !srv.ImportUnmanaged {
+		return item, nil
+	}
+	if hc.OSTemplate == "" {
+		log.Printf("Container %s is not imported, its OS template is not reported by a host", hc.Name)
+		return item, nil
+	}
+
+	container := &models.Container{
+		ID:         uuid.New().String(),
+		Name:       hc.Name,
+		OSTemplate: hc.OSTemplate,
+		State:      hostStates[hc.State],
+	}
+	err := srv.ContainerRepo.Create(container)
+	if err != nil {
+		return nil, err
+	}
+	parameters := make(map[string]string, len(hc.Parameters))
+	for name, value := range hc.Parameters {
+		if value != "" {
+			parameters[name] = value
+		}
+	}
+	if len(parameters) > 0 {
+		err = srv.ContainerRepo.SetParameters(container.ID, parameters)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	item.ContainerID = container.ID
+	item.Resolution = api.DriftImported
+
+	return item, nil
+}
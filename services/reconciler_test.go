@@ -0,0 +1,306 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+	"github.com/romiras/go-openvz-api/repositories"
+	openvzcmd "github.com/romiras/go-openvz-cmd"
+)
+
+// addTestContainer adds a stopped container to the database
+func addTestContainer(t *testing.T, repo repositories.ContainerRepository, id, name string, parameters map[string]string) {
+	t.Helper()
+
+	err := repo.Create(&models.Container{ID: id, Name: name, OSTemplate: "centos-7", State: models.STOPPED})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SetParameters(id, parameters); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcileReportsDrift(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	cmd := commanders.NewFakeCommander()
+	srv := NewReconcileService(db, cmd)
+
+	addTestContainer(t, srv.ContainerRepo, "c1", "ct1", map[string]string{api.HostnameParam: "ct1.example.com"})
+	addTestContainer(t, srv.ContainerRepo, "c2", "ct2", map[string]string{})
+	addTestContainer(t, srv.ContainerRepo, "c3", "ct3", map[string]string{})
+	if err := srv.ContainerRepo.SetState("c3", models.CREATING); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.CreateContainer(ctx, "ct1", "centos-7", openvzcmd.Options{api.HostnameParam: "ct1.local"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.StartContainer(ctx, "ct1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.CreateContainer(ctx, "ct4", "debian-10", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := srv.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*api.DriftItem{
+		{Kind: api.DriftMismatch, Name: "ct1", ContainerID: "c1", HostID: "ct1", Field: "state", Expected: "stopped", Actual: "running"},
+		{Kind: api.DriftMismatch, Name: "ct1", ContainerID: "c1", HostID: "ct1", Field: api.HostnameParam, Expected: "ct1.example.com", Actual: "ct1.local"},
+		{Kind: api.DriftMissing, Name: "ct2", ContainerID: "c2"},
+		{Kind: api.DriftUnmanaged, Name: "ct4", HostID: "ct4"},
+	}
+	if !reflect.DeepEqual(report.Drift, want) {
+		t.Errorf("Drift = %+v, want %+v", report.Drift, want)
+	}
+
+	// Nothing is changed unless asked to
+	container, err := srv.ContainerRepo.FindByID("c2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if container.State != models.STOPPED {
+		t.Errorf("State = %s, want stopped", container.State)
+	}
+
+	cached, err := srv.Drift(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached != report {
+		t.Error("Drift() made a new report instead of returning the latest one")
+	}
+}
+
+func TestReconcileResolvesDrift(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	cmd := commanders.NewFakeCommander()
+	srv := NewReconcileService(db, cmd)
+	srv.ImportUnmanaged = true
+	srv.MarkVanished = true
+
+	addTestContainer(t, srv.ContainerRepo, "c1", "ct1", map[string]string{})
+	if err := cmd.CreateContainer(ctx, "ct2", "debian-10", openvzcmd.Options{api.HostnameParam: "ct2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := srv.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drift) != 2 || report.Drift[0].Resolution != api.DriftMarked || report.Drift[1].Resolution != api.DriftImported {
+		t.Fatalf("Drift = %+v, want ct1 marked and ct2 imported", report.Drift)
+	}
+
+	imported, err := srv.ContainerRepo.FindByID(report.Drift[1].ContainerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Name != "ct2" || imported.OSTemplate != "debian-10" || imported.State != models.STOPPED || imported.Parameters[api.HostnameParam] != "ct2" {
+		t.Errorf("Imported container = %+v", imported)
+	}
+	missing, err := srv.ContainerRepo.FindByID("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing.State != models.MISSING {
+		t.Errorf("State = %s, want missing", missing.State)
+	}
+
+	// A missing container which appears again is restored
+	if err := cmd.CreateContainer(ctx, "ct1", "centos-7", nil); err != nil {
+		t.Fatal(err)
+	}
+	report, err = srv.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drift) != 1 || report.Drift[0].Resolution != api.DriftRestored {
+		t.Fatalf("Drift = %+v, want ct1 restored", report.Drift)
+	}
+	if report, err = srv.Reconcile(); err != nil || len(report.Drift) != 0 {
+		t.Errorf("Drift = %+v, %v, want none", report, err)
+	}
+}
+
+func TestReconcileComparesTypedParameters(t *testing.T) {
+	db := newTestDB(t)
+	cmd := commanders.NewFakeCommander()
+	srv := NewReconcileService(db, cmd)
+
+	addTestContainer(t, srv.ContainerRepo, "c1", "ct1", map[string]string{
+		api.HostnameParam:     "ct1.example.com",
+		api.MemSizeParam:      "1",
+		api.MemSizeUnitsParam: "G",
+		api.IPAddParam:        "10.0.0.2/24,10.0.0.3",
+		api.CPUsParam:         "2",
+	})
+	err := cmd.CreateContainer(context.Background(), "ct1", "centos-7", map[string]string{
+		api.HostnameParam:     "CT1.example.com",
+		api.MemSizeParam:      "1024",
+		api.MemSizeUnitsParam: "M",
+		api.IPAddParam:        "10.0.0.3,10.0.0.2/24",
+		api.CPUsParam:         "4",
+		api.SizeParam:         "10",
+		api.SizeUnitsParam:    "G",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := srv.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*api.DriftItem{{
+		Kind:        api.DriftMismatch,
+		Name:        "ct1",
+		ContainerID: "c1",
+		HostID:      "ct1",
+		Field:       api.CPUsParam,
+		Expected:    "2",
+		Actual:      "4",
+	}}
+	if !reflect.DeepEqual(report.Drift, want) {
+		t.Errorf("Drift = %+v, want %+v", report.Drift, want)
+	}
+}
+
+func TestReconcileImportsParametersOfUnmanagedContainers(t *testing.T) {
+	db := newTestDB(t)
+	cmd := commanders.NewFakeCommander()
+	srv := NewReconcileService(db, cmd)
+	srv.ImportUnmanaged = true
+
+	parameters := map[string]string{api.HostnameParam: "ct1", api.NameserverParam: "8.8.8.8,8.8.4.4"}
+	if err := cmd.CreateContainer(context.Background(), "ct1", "centos-7", parameters); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := srv.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drift) != 1 || report.Drift[0].Resolution != api.DriftImported {
+		t.Fatalf("Drift = %+v, want ct1 imported", report.Drift)
+	}
+
+	container, err := srv.ContainerRepo.FindByID(report.Drift[0].ContainerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(container.Parameters, parameters) {
+		t.Errorf("Parameters = %v, want %v", container.Parameters, parameters)
+	}
+
+	report, err = srv.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drift) != 0 {
+		t.Errorf("Drift after import = %+v, want none", report.Drift)
+	}
+}
+
+// noTemplateCommander is a fake host reporting no OS templates of containers
+type noTemplateCommander struct {
+	*commanders.FakeCommander
+}
+
+func (cmd noTemplateCommander) ListContainers(ctx context.Context) ([]commanders.HostContainer, error) {
+	containers, err := cmd.FakeCommander.ListContainers(ctx)
+	for i := range containers {
+		containers[i].OSTemplate = ""
+	}
+
+	return containers, err
+}
+
+func TestReconcileSkipsImportOfContainersWithoutTemplate(t *testing.T) {
+	db := newTestDB(t)
+	cmd := noTemplateCommander{commanders.NewFakeCommander()}
+	srv := NewReconcileService(db, cmd)
+	srv.ImportUnmanaged = true
+
+	if err := cmd.CreateContainer(context.Background(), "ct1", "centos-7", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := srv.Reconcile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Drift) != 1 || report.Drift[0].Kind != api.DriftUnmanaged || report.Drift[0].Resolution != "" {
+		t.Fatalf("Drift = %+v, want ct1 unmanaged and not imported", report.Drift)
+	}
+	if found, err := srv.ContainerRepo.HasName("ct1"); err != nil || found {
+		t.Errorf("Container ct1 is imported without an OS template: %v", err)
+	}
+}
+
+func TestDeleteMissingContainerDropsOnlyItsRow(t *testing.T) {
+	db := newTestDB(t)
+	cmd := commanders.NewFakeCommander()
+	jobs := NewJobService(db, cmd, events.NewBus())
+	srv := NewContainerAPIService(db, cmd, jobs)
+
+	addTestContainer(t, srv.ContainerRepo, "c1", "ct1", map[string]string{})
+	if err := srv.ContainerRepo.SetState("c1", models.MISSING); err != nil {
+		t.Fatal(err)
+	}
+	// A container of the same name on a host is not the one which went missing
+	if err := cmd.CreateContainer(context.Background(), "ct1", "centos-7", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := srv.Delete("c1", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestJobs(t, jobs)
+
+	job, err := jobs.JobRepo.FindByID(resp.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.DONE {
+		t.Fatalf("Status = %v, want done", job.Status)
+	}
+	if _, err := srv.ContainerRepo.FindByID("c1"); err == nil {
+		t.Error("Container c1 is not deleted from the database")
+	}
+	hostContainers, err := cmd.ListContainers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hostContainers) != 1 {
+		t.Errorf("Host containers = %+v, want ct1 left", hostContainers)
+	}
+}
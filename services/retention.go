@@ -0,0 +1,111 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+type (
+	// retentionArtifact is a backup or a snapshot made by a policy
+	retentionArtifact struct {
+		ID        string
+		State     string
+		CreatedAt time.Time
+	}
+
+	// retentionRule keeps the latest artifacts of Keep latest periods, which artifacts are
+	// told apart by period
+	retentionRule struct {
+		Name   string
+		Keep   int
+		period func(a *retentionArtifact) string
+	}
+)
+
+func retentionRules(policy *models.Policy) []retentionRule {
+	return []retentionRule{
+		{Name: "last", Keep: policy.KeepLast, period: func(a *retentionArtifact) string {
+			return a.ID
+		}},
+		{Name: "daily", Keep: policy.KeepDaily, period: func(a *retentionArtifact) string {
+			return a.CreatedAt.UTC().Format("2006-01-02")
+		}},
+		{Name: "weekly", Keep: policy.KeepWeekly, period: func(a *retentionArtifact) string {
+			year, week := a.CreatedAt.UTC().ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{Name: "monthly", Keep: policy.KeepMonthly, period: func(a *retentionArtifact) string {
+			return a.CreatedAt.UTC().Format("2006-01")
+		}},
+	}
+}
+
+// retain splits artifacts made by a policy into ones kept by its rules and ones to be pruned.
+// Only ready artifacts are kept, failed ones are pruned, and ones in other states are left
+// for their jobs to finish, or for a user once their deletion fails.
+func retain(policy *models.Policy, artifacts []*retentionArtifact) ([]*api.RetentionDecision, []*api.RetentionDecision) {
+	ready := make([]*retentionArtifact, 0, len(artifacts))
+	failed := make([]*retentionArtifact, 0)
+	for _, a := range artifacts {
+		switch a.State {
+		case models.BackupReady:
+			ready = append(ready, a)
+		case models.BackupFailed:
+			failed = append(failed, a)
+		}
+	}
+	sort.SliceStable(ready, func(i, j int) bool {
+		return ready[i].CreatedAt.After(ready[j].CreatedAt)
+	})
+
+	reasons := make(map[string][]string)
+	for _, rule := range retentionRules(policy) {
+		kept, last := 0, ""
+		for _, a := range ready {
+			if kept >= rule.Keep {
+				break
+			}
+			if period := rule.period(a); period != last {
+				reasons[a.ID] = append(reasons[a.ID], rule.Name)
+				kept++
+				last = period
+			}
+		}
+	}
+
+	keep := make([]*api.RetentionDecision, 0)
+	prune := make([]*api.RetentionDecision, 0)
+	for _, a := range ready {
+		decision := &api.RetentionDecision{ID: a.ID, State: a.State, CreatedAt: a.CreatedAt, Reasons: reasons[a.ID]}
+		if len(decision.Reasons) > 0 {
+			keep = append(keep, decision)
+		} else {
+			prune = append(prune, decision)
+		}
+	}
+	for _, a := range failed {
+		prune = append(prune, &api.RetentionDecision{ID: a.ID, State: a.State, CreatedAt: a.CreatedAt})
+	}
+
+	return keep, prune
+}
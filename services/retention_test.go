@@ -0,0 +1,62 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func decisionIDs(decisions []*api.RetentionDecision) []string {
+	ids := make([]string, 0, len(decisions))
+	for _, decision := range decisions {
+		ids = append(ids, decision.ID)
+	}
+
+	return ids
+}
+
+func TestRetain(t *testing.T) {
+	day := time.Date(2021, 3, 10, 3, 0, 0, 0, time.UTC)
+	artifacts := []*retentionArtifact{
+		{ID: "a1", State: models.BackupReady, CreatedAt: day.AddDate(0, -1, 0)},
+		{ID: "a2", State: models.BackupReady, CreatedAt: day.AddDate(0, 0, -2)},
+		{ID: "a3", State: models.BackupReady, CreatedAt: day.AddDate(0, 0, -1)},
+		{ID: "a4", State: models.BackupReady, CreatedAt: day.AddDate(0, 0, -1).Add(time.Hour)},
+		{ID: "a5", State: models.BackupFailed, CreatedAt: day},
+		{ID: "a6", State: models.BackupCreating, CreatedAt: day.Add(time.Hour)},
+		{ID: "a7", State: models.BackupReady, CreatedAt: day},
+	}
+
+	keep, prune := retain(&models.Policy{KeepLast: 1, KeepDaily: 2, KeepMonthly: 2}, artifacts)
+	if ids := decisionIDs(keep); !reflect.DeepEqual(ids, []string{"a7", "a4", "a1"}) {
+		t.Errorf("Kept %v", ids)
+	}
+	if ids := decisionIDs(prune); !reflect.DeepEqual(ids, []string{"a3", "a2", "a5"}) {
+		t.Errorf("Pruned %v", ids)
+	}
+	if reasons := keep[0].Reasons; !reflect.DeepEqual(reasons, []string{"last", "daily", "monthly"}) {
+		t.Errorf("Reasons = %v", reasons)
+	}
+	if reasons := keep[2].Reasons; !reflect.DeepEqual(reasons, []string{"monthly"}) {
+		t.Errorf("Reasons = %v", reasons)
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+const (
+	DefaultRetryBackoff    = 5 * time.Second
+	DefaultRetryMaxBackoff = 10 * time.Minute
+	DefaultRetryJitter     = 0.2
+
+	BackupRetryBackoff    = time.Minute
+	BackupRetryMaxBackoff = time.Hour
+)
+
+// ErrUnknownJobType is returned for jobs having no registered handler
+var ErrUnknownJobType = errors.New("unknown job type")
+
+// RetryPolicy defines how a failed job is retried
+type RetryPolicy struct {
+	// MaxAttempts limits number of times a job is run
+	MaxAttempts int
+	// Backoff is a delay before the first retry, doubled for every next one
+	Backoff time.Duration
+	// MaxBackoff caps a delay between retries
+	MaxBackoff time.Duration
+	// Jitter is a fraction of a delay to randomly add or subtract
+	Jitter float64
+}
+
+func NewDefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: DefaultMaxAttempts,
+		Backoff:     DefaultRetryBackoff,
+		MaxBackoff:  DefaultRetryMaxBackoff,
+		Jitter:      DefaultRetryJitter,
+	}
+}
+
+// Delay returns a delay before a job is run again after given number of attempts
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	delay := p.Backoff
+	for i := 1; i < attempts && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	jitter := float64(delay) * p.Jitter * (2*rand.Float64() - 1)
+
+	return delay + time.Duration(jitter)
+}
+
+// SetRetryPolicy overrides the default retry policy for jobs of given type
+func (j *JobService) SetRetryPolicy(jobType string, policy RetryPolicy) {
+	j.retryPolicies[jobType] = policy
+}
+
+// RetryPolicy returns a retry policy of jobs of given type
+func (j *JobService) RetryPolicy(jobType string) RetryPolicy {
+	if policy, ok := j.retryPolicies[jobType]; ok {
+		return policy
+	}
+
+	return j.DefaultRetryPolicy
+}
+
+// willRetry reports whether a job failed with err is going to be run again
+func (j *JobService) willRetry(job *models.Job, err error) bool {
+	return isRetryable(err) && job.Attempts < job.MaxAttempts
+}
+
+// isRetryable classifies job errors: invalid jobs, ones of missing entities and permanent commander errors
+// are not retried
+func isRetryable(err error) bool {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, ErrInvalidContainerState), errors.Is(err, ErrUnknownJobType), errors.Is(err, ErrDamagedArchive):
+		return false
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return false
+	case errors.Is(err, sql.ErrNoRows):
+		// An entity of a job is deleted, and will not appear on retry
+		return false
+	default:
+		return commanders.IsRetryable(err)
+	}
+}
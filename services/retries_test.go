@@ -0,0 +1,142 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Backoff: time.Second, MaxBackoff: 10 * time.Second}
+
+	for attempts, want := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 8 * time.Second,
+		5: 10 * time.Second,
+		9: 10 * time.Second,
+	} {
+		if delay := policy.Delay(attempts); delay != want {
+			t.Errorf("Delay(%d) = %v, want %v", attempts, delay, want)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := policy.Delay(2); delay < time.Second || delay > 3*time.Second {
+			t.Fatalf("Delay(2) with jitter = %v, want within 1s..3s", delay)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("exit status 1"), true},
+		{ErrInvalidContainerState, false},
+		{fmt.Errorf("%w %s", ErrUnknownJobType, "noop"), false},
+		{&json.SyntaxError{}, false},
+		{&json.UnmarshalTypeError{}, false},
+		{sql.ErrNoRows, false},
+		{fmt.Errorf("container: %w", sql.ErrNoRows), false},
+		{commanders.Permanent(errors.New("container exists")), false},
+		{&exec.Error{Name: "prlctl", Err: exec.ErrNotFound}, false},
+	} {
+		if got := isRetryable(tc.err); got != tc.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestFailedJobIsRetried(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+	j.SetRetryPolicy("flaky", RetryPolicy{MaxAttempts: 2, Backoff: time.Hour})
+
+	runs := 0
+	j.RegisterHandler("flaky", func(ctx context.Context, job *models.Job) (string, error) {
+		runs++
+		return "", errors.New("exit status 1")
+	})
+	jobID := enqueueTestJob(t, j, "flaky", struct{}{})
+
+	runTestJobs(t, j)
+	var job models.Job
+	if err := db.Get(&job, "SELECT status, attempts, max_attempts, last_error, next_run_at FROM jobs WHERE id=?", jobID); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.PENDING || job.Attempts != 1 || job.MaxAttempts != 2 || job.LastError.String != "exit status 1" {
+		t.Errorf("Job after the first attempt = %+v", job)
+	}
+	if !job.NextRunAt.Valid || time.Until(job.NextRunAt.Time) < 30*time.Minute {
+		t.Errorf("Next run at %v, want in about an hour", job.NextRunAt)
+	}
+	if runs != 1 {
+		t.Fatalf("Runs = %d, want the retry to wait for its backoff", runs)
+	}
+
+	db.MustExec("UPDATE jobs SET next_run_at=? WHERE id=?", time.Now().UTC(), jobID)
+	runTestJobs(t, j)
+	if status, descr := findTestJob(t, j, jobID); status != models.FAILED || descr != "exit status 1" {
+		t.Errorf("Status after the last attempt = %v %q, want failed", status, descr)
+	}
+	if runs != 2 {
+		t.Errorf("Runs = %d, want 2", runs)
+	}
+}
+
+func TestRetryPolicyOfJobType(t *testing.T) {
+	db := newTestDB(t)
+	j := NewJobService(db, nil, events.NewBus())
+	j.SetRetryPolicy("delete", RetryPolicy{MaxAttempts: 1})
+	j.SetRetryPolicy("backup", RetryPolicy{MaxAttempts: 2, Backoff: time.Hour})
+
+	for _, jobType := range []string{"delete", "backup"} {
+		j.RegisterHandler(jobType, func(ctx context.Context, job *models.Job) (string, error) {
+			return "", errors.New("exit status 1")
+		})
+	}
+	deleteID := enqueueTestJob(t, j, "delete", struct{}{})
+	backupID := enqueueTestJob(t, j, "backup", struct{}{})
+
+	runTestJobs(t, j)
+	if status, descr := findTestJob(t, j, deleteID); status != models.FAILED || descr != "exit status 1" {
+		t.Errorf("Status of a job having no retries = %v %q, want failed", status, descr)
+	}
+
+	var job models.Job
+	if err := db.Get(&job, "SELECT status, next_run_at FROM jobs WHERE id=?", backupID); err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != models.PENDING || !job.NextRunAt.Valid || time.Until(job.NextRunAt.Time) < 30*time.Minute {
+		t.Errorf("Job retried with its own policy = %+v, want pending for about an hour", job)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"log"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+const DefaultSchedulerInterval = 60 * time.Second
+
+// SchedulerService runs due policies, making backups or snapshots of containers they are attached to,
+// and prunes ones which are no longer retained by policies or have expired
+type SchedulerService struct {
+	Policies  *PolicyAPIService
+	Backups   *BackupAPIService
+	Snapshots *SnapshotAPIService
+}
+
+func NewSchedulerService(policies *PolicyAPIService, backups *BackupAPIService, snapshots *SnapshotAPIService) *SchedulerService {
+	return &SchedulerService{
+		Policies:  policies,
+		Backups:   backups,
+		Snapshots: snapshots,
+	}
+}
+
+// Run checks policies every interval
+func (srv *SchedulerService) Run(interval time.Duration) {
+	for {
+		srv.Tick(time.Now().UTC())
+		time.Sleep(interval)
+	}
+}
+
+// Tick runs policies due at now and prunes backups and snapshots. Runs missed while
+// the server was down are made up with a single run.
+func (srv *SchedulerService) Tick(now time.Time) {
+	due, err := srv.Policies.PolicyRepo.ListDue(now)
+	if err != nil {
+		log.Printf("Policies are not run: %s", err.Error())
+	}
+	for _, policy := range due {
+		srv.runPolicy(policy, now)
+	}
+
+	policies, err := srv.Policies.PolicyRepo.List()
+	if err != nil {
+		log.Printf("Policies are not pruned: %s", err.Error())
+	}
+	for _, policy := range policies {
+		srv.prunePolicy(policy)
+	}
+
+	srv.pruneExpiredBackups(now)
+}
+
+// runPolicy claims a run of a policy and makes a backup or a snapshot of every container it is attached to
+func (srv *SchedulerService) runPolicy(policy *models.Policy, now time.Time) {
+	schedule, err := api.ParseSchedule(policy.Schedule)
+	if err != nil {
+		log.Printf("Policy %s is not run: %s", policy.ID, err.Error())
+		return
+	}
+
+	claimed, err := srv.Policies.PolicyRepo.Reschedule(policy.ID, now, schedule.Next(now))
+	if err != nil || !claimed {
+		if err != nil {
+			log.Printf("Policy %s is not run: %s", policy.ID, err.Error())
+		}
+		return
+	}
+
+	containerIDs, err := srv.Policies.PolicyRepo.ContainerIDs(policy.ID)
+	if err != nil {
+		log.Printf("Policy %s is not run: %s", policy.ID, err.Error())
+		return
+	}
+
+	name := policy.Name + " " + now.Format("2006-01-02 15:04")
+	for _, containerID := range containerIDs {
+		if policy.Kind == models.PolicyBackup {
+			_, err = srv.Backups.Create(&api.AddBackupRequest{
+				ContainerID: containerID,
+				Description: name,
+				PolicyID:    policy.ID,
+			}, false)
+		} else {
+			_, err = srv.Snapshots.Create(&api.AddSnapshotRequest{
+				ContainerID: containerID,
+				Name:        name,
+				PolicyID:    policy.ID,
+			}, false)
+		}
+		if err != nil {
+			log.Printf("Policy %s made no %s of container %s: %s", policy.ID, policy.Kind, containerID, err.Error())
+		}
+	}
+}
+
+// prunePolicy deletes backups or snapshots made by a policy which are no longer retained by its rules
+func (srv *SchedulerService) prunePolicy(policy *models.Policy) {
+	retentions, err := srv.Policies.evaluate(policy)
+	if err != nil {
+		log.Printf("Policy %s is not pruned: %s", policy.ID, err.Error())
+		return
+	}
+
+	for _, retention := range retentions {
+		for _, decision := range retention.Prune {
+			if policy.Kind == models.PolicyBackup {
+				_, err = srv.Backups.Delete(decision.ID, false)
+			} else {
+				_, err = srv.Snapshots.Delete(retention.ContainerID, decision.ID, false)
+			}
+			// An artifact may be in use by another job, it is pruned on a later tick then
+			if err != nil && err != ErrInvalidBackupState && err != ErrInvalidSnapshotState && err != ErrInvalidContainerState {
+				log.Printf("Policy %s did not prune %s %s: %s", policy.ID, policy.Kind, decision.ID, err.Error())
+			}
+		}
+	}
+}
+
+// pruneExpiredBackups deletes backups retained no longer than now
+func (srv *SchedulerService) pruneExpiredBackups(now time.Time) {
+	backups, err := srv.Backups.BackupRepo.ListExpired(now)
+	if err != nil {
+		log.Printf("Expired backups are not pruned: %s", err.Error())
+		return
+	}
+
+	for _, backup := range backups {
+		_, err = srv.Backups.Delete(backup.ID, false)
+		if err != nil && err != ErrInvalidBackupState {
+			log.Printf("Expired backup %s is not deleted: %s", backup.ID, err.Error())
+		}
+	}
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+	"github.com/romiras/go-openvz-api/repositories"
+)
+
+func newTestScheduler(t *testing.T) (*SchedulerService, *ContainerAPIService, *JobService) {
+	t.Helper()
+
+	db := newTestDB(t)
+	cmd := commanders.NewFakeCommander()
+	jobs := NewJobService(db, cmd, events.NewBus())
+	srv := NewSchedulerService(NewPolicyAPIService(db), NewBackupAPIService(db, jobs), NewSnapshotAPIService(db, jobs))
+
+	return srv, NewContainerAPIService(db, cmd, jobs), jobs
+}
+
+// listTestBackups lists backups of a container
+func listTestBackups(t *testing.T, srv *SchedulerService, containerID string) []*api.BackupInfo {
+	t.Helper()
+
+	list, err := srv.Backups.List(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return list.Backups
+}
+
+func TestSchedulerRunsAndPrunesPolicy(t *testing.T) {
+	srv, containers, jobs := newTestScheduler(t)
+	id := createTestContainer(t, containers, jobs, "web", nil)
+
+	req := &api.AddPolicyRequest{Name: "nightly", Kind: models.PolicyBackup, Schedule: "@daily", KeepLast: 1}
+	if err := api.ValidateAddPolicyRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Policies.Create(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policyID := resp.Policy.ID
+	if _, err := srv.Policies.Attach(&api.AttachPolicyRequest{ID: policyID, ContainerID: id}); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := srv.Policies.PolicyRepo.FindByID(policyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextRunAt := policy.NextRunAt.Time
+
+	srv.Tick(nextRunAt.Add(-time.Minute))
+	if backups := listTestBackups(t, srv, id); len(backups) != 0 {
+		t.Fatalf("Backups = %+v, want none before the policy is due", backups)
+	}
+
+	srv.Tick(nextRunAt)
+	runTestJobs(t, jobs)
+	srv.Tick(nextRunAt.AddDate(0, 0, 1))
+	runTestJobs(t, jobs)
+	backups := listTestBackups(t, srv, id)
+	if len(backups) != 2 || backups[0].State != models.BackupReady || backups[1].State != models.BackupReady {
+		t.Fatalf("Backups = %+v, want two ready ones", backups)
+	}
+	kept := backups[1].ID
+
+	preview, err := srv.Policies.Preview(policyID, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.NextRuns) != 3 || len(preview.Containers) != 1 || len(preview.Containers[0].Prune) != 1 {
+		t.Errorf("Preview = %+v, want 3 runs and a backup to prune", preview)
+	}
+
+	// The policy is not due again, so the tick only prunes it
+	srv.Tick(nextRunAt.AddDate(0, 0, 1))
+	runTestJobs(t, jobs)
+	if backups = listTestBackups(t, srv, id); len(backups) != 1 || backups[0].ID != kept {
+		t.Errorf("Backups = %+v, want the latest one", backups)
+	}
+}
+
+func TestPrunePolicyPrunesBackupsOfDetachedAndDeletedContainers(t *testing.T) {
+	srv, _, _ := newTestScheduler(t)
+	resp, err := srv.Policies.Create(&api.AddPolicyRequest{Name: "nightly", Kind: models.PolicyBackup, Schedule: "@daily", KeepLast: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policyID := resp.Policy.ID
+
+	now := time.Now().UTC()
+	for _, containerID := range []string{"c1", "c2", "c3"} {
+		addTestContainer(t, srv.Policies.ContainerRepo, containerID, "ct-"+containerID, map[string]string{})
+		if _, err := srv.Policies.Attach(&api.AttachPolicyRequest{ID: policyID, ContainerID: containerID}); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			err := srv.Backups.BackupRepo.Create(&models.Backup{
+				ID:            containerID + "-" + string(rune('a'+i)),
+				ContainerID:   containerID,
+				ContainerName: "ct-" + containerID,
+				State:         models.BackupReady,
+				PolicyID:      sql.NullString{String: policyID, Valid: true},
+				CreatedAt:     now.Add(time.Duration(i) * time.Hour),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := srv.Policies.Detach(policyID, "c2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Policies.ContainerRepo.Delete("c3"); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := srv.Policies.PolicyRepo.FindByID(policyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.prunePolicy(policy)
+
+	for id, want := range map[string]string{
+		"c1-a": models.BackupDeleting, "c1-b": models.BackupReady,
+		"c2-a": models.BackupDeleting, "c2-b": models.BackupReady,
+		"c3-a": models.BackupDeleting, "c3-b": models.BackupReady,
+	} {
+		backup, err := srv.Backups.BackupRepo.FindByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if backup.State != want {
+			t.Errorf("Backup %s state = %s, want %s", id, backup.State, want)
+		}
+	}
+}
+
+func TestPruneExpiredBackupsSkipsOuterArchivesAndFailedDeletions(t *testing.T) {
+	srv, _, jobs := newTestScheduler(t)
+	jobs.BackupDir = t.TempDir()
+
+	now := time.Now().UTC()
+	for id, archive := range map[string]string{
+		// made before -backupdir is changed
+		"outer": filepath.Join(t.TempDir(), "vzdump-101.tar"),
+		// unknown to a host, so that its deletion fails for good
+		"unknown": filepath.Join(jobs.BackupDir, "vzdump-102.tar"),
+	} {
+		err := srv.Backups.BackupRepo.Create(&models.Backup{
+			ID:            id,
+			ContainerID:   "c1",
+			ContainerName: "ct1",
+			HostID:        sql.NullString{String: id, Valid: true},
+			Archive:       sql.NullString{String: archive, Valid: true},
+			State:         models.BackupReady,
+			ExpiresAt:     sql.NullTime{Time: now.Add(-time.Hour), Valid: true},
+			CreatedAt:     now.AddDate(0, 0, -1),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv.Tick(now)
+	runTestJobs(t, jobs)
+	if _, err := srv.Backups.BackupRepo.FindByID("outer"); err != sql.ErrNoRows {
+		t.Errorf("Backup with an outer archive is found after it is pruned: %v", err)
+	}
+	assertBackupState(t, jobs, "unknown", models.BackupDeleteFailed)
+
+	srv.Tick(now.Add(time.Minute))
+	deletes, err := jobs.JobRepo.List(repositories.JobFilter{Type: DeleteBackupType, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deletes) != 2 {
+		t.Errorf("Delete jobs = %d, want 2 without a retry of the failed deletion", len(deletes))
+	}
+	assertBackupState(t, jobs, "unknown", models.BackupDeleteFailed)
+}
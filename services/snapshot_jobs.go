@@ -0,0 +1,166 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func (j *JobService) createSnapshot(ctx context.Context, job *models.Job) (string, error) {
+	payload, snapshot, err := j.parseSnapshotJob(job)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	hostID, err := j.Commander.CreateSnapshot(ctx, payload.Name, snapshot.ID, snapshot.Name, snapshot.Description)
+	if err != nil {
+		return payload.ID, j.failSnapshotJob(ctx, job, snapshot, models.SnapshotFailed, err)
+	}
+
+	return payload.ID, j.SnapshotRepo.MarkReady(snapshot.ID, hostID)
+}
+
+func (j *JobService) deleteSnapshot(ctx context.Context, job *models.Job) (string, error) {
+	payload, snapshot, err := j.parseSnapshotJob(job)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	// A snapshot which failed to be taken exists in the database only
+	if snapshot.HostID.Valid {
+		err = j.Commander.DeleteSnapshot(ctx, payload.Name, snapshot.HostID.String)
+		if err != nil {
+			return payload.ID, j.failSnapshotJob(ctx, job, snapshot, models.SnapshotReady, err)
+		}
+	}
+
+	return payload.ID, j.SnapshotRepo.Delete(snapshot.ID)
+}
+
+// revertSnapshot switches a container to a snapshot. Parameters of the container are restored
+// as they were when the snapshot was taken, and its state is read from a host.
+func (j *JobService) revertSnapshot(ctx context.Context, job *models.Job) (string, error) {
+	payload, snapshot, err := j.parseSnapshotJob(job)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	err = j.Commander.RevertSnapshot(ctx, payload.Name, snapshot.HostID.String)
+	if err != nil {
+		return payload.ID, j.failSnapshotJob(ctx, job, snapshot, models.SnapshotReady, err)
+	}
+
+	err = j.SnapshotRepo.SetState(snapshot.ID, models.SnapshotReady)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	parameters, err := snapshot.Parameters()
+	if err != nil {
+		return payload.ID, err
+	}
+	err = j.ContainerRepo.SetParameters(payload.ID, parameters)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	j.refreshContainerState(ctx, payload.ID, payload.Name)
+
+	return payload.ID, nil
+}
+
+// failSnapshotJob sets a state of a snapshot when its job fails for good, and returns err
+func (j *JobService) failSnapshotJob(ctx context.Context, job *models.Job, snapshot *models.Snapshot, state string, err error) error {
+	if ctx.Err() == nil && j.willRetry(job, err) {
+		return err
+	}
+
+	if stateErr := j.SnapshotRepo.SetState(snapshot.ID, state); stateErr != nil {
+		return stateErr
+	}
+
+	return err
+}
+
+// refreshContainerState sets a state of a container as it is found on a host
+func (j *JobService) refreshContainerState(ctx context.Context, id, name string) {
+	hostContainers, err := j.Commander.ListContainers(ctx)
+	if err != nil {
+		log.Printf("State of container %s is not refreshed: %s", name, err.Error())
+		return
+	}
+
+	for _, hc := range hostContainers {
+		if hc.Name != name {
+			continue
+		}
+		if err := j.setContainerState(id, hostStates[hc.State]); err != nil {
+			log.Println(err.Error())
+		}
+		return
+	}
+
+	log.Printf("Container %s is not found on a host", name)
+}
+
+func (j *JobService) parseSnapshotJob(job *models.Job) (*SnapshotJob, *models.Snapshot, error) {
+	var payload SnapshotJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return &payload, nil, err
+	}
+
+	snapshot, err := j.SnapshotRepo.FindByID(payload.ID, payload.SnapshotID)
+
+	return &payload, snapshot, err
+}
+
+func (j *JobService) dryRunCreateSnapshot(ctx context.Context, job *models.Job) (string, error) {
+	var payload SnapshotJob
+
+	err := json.Unmarshal(job.Payload, &payload)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = j.Commander.CreateSnapshot(ctx, payload.Name, payload.SnapshotID, payload.SnapshotName, payload.SnapshotDescription)
+
+	return payload.ID, err
+}
+
+func (j *JobService) dryRunDeleteSnapshot(ctx context.Context, job *models.Job) (string, error) {
+	payload, snapshot, err := j.parseSnapshotJob(job)
+	if err != nil || !snapshot.HostID.Valid {
+		return payload.ID, err
+	}
+
+	return payload.ID, j.Commander.DeleteSnapshot(ctx, payload.Name, snapshot.HostID.String)
+}
+
+func (j *JobService) dryRunRevertSnapshot(ctx context.Context, job *models.Job) (string, error) {
+	payload, snapshot, err := j.parseSnapshotJob(job)
+	if err != nil {
+		return payload.ID, err
+	}
+
+	return payload.ID, j.Commander.RevertSnapshot(ctx, payload.Name, snapshot.HostID.String)
+}
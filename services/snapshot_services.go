@@ -0,0 +1,263 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/models"
+	"github.com/romiras/go-openvz-api/repositories"
+)
+
+// ErrInvalidSnapshotState is returned when an operation is not allowed in the current snapshot state
+var ErrInvalidSnapshotState = errors.New("invalid-snapshot-state")
+
+// snapshotContainerStates are states of containers which can be snapshotted or reverted
+var snapshotContainerStates = []models.ContainerState{models.STOPPED, models.RUNNING, models.SUSPENDED}
+
+type SnapshotAPIService struct {
+	SnapshotRepo  repositories.SnapshotRepository
+	ContainerRepo repositories.ContainerRepository
+	Jobs          *JobService
+}
+
+func NewSnapshotAPIService(db DBConnection, jobs *JobService) *SnapshotAPIService {
+	return &SnapshotAPIService{
+		SnapshotRepo:  repositories.NewSnapshotRepository(db),
+		ContainerRepo: repositories.NewContainerRepository(db),
+		Jobs:          jobs,
+	}
+}
+
+// Create records a snapshot and enqueues a job taking it. A dry run only renders commands of the job.
+func (srv *SnapshotAPIService) Create(req *api.AddSnapshotRequest, dryRun bool) (*api.AddSnapshotResponse, error) {
+	container, err := srv.findSnapshotContainer(req.ContainerID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := SnapshotJob{
+		ContainerJob: ContainerJob{
+			ID:   container.ID,
+			Name: container.Name,
+		},
+		SnapshotID:          uuid.New().String(),
+		SnapshotName:        req.Name,
+		SnapshotDescription: req.Description,
+	}
+
+	if dryRun || srv.Jobs.DryRun {
+		resp, err := srv.Jobs.submit(CreateSnapshotType, payload, EnqueueOptions{}, true)
+		if err != nil {
+			return nil, err
+		}
+
+		return &api.AddSnapshotResponse{
+			ApiResponse: resp.ApiResponse,
+			JobID:       resp.JobID,
+			DryRun:      true,
+			Commands:    resp.Commands,
+		}, nil
+	}
+
+	parameters, err := json.Marshal(container.Parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &models.Snapshot{
+		ID:             payload.SnapshotID,
+		ContainerID:    container.ID,
+		Name:           req.Name,
+		Description:    req.Description,
+		State:          models.SnapshotCreating,
+		ParametersJSON: sql.NullString{String: string(parameters), Valid: true},
+		PolicyID:       sql.NullString{String: req.PolicyID, Valid: req.PolicyID != ""},
+		CreatedAt:      time.Now().UTC(),
+	}
+	err = srv.SnapshotRepo.Create(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	jobID, err := srv.Jobs.Enqueue(CreateSnapshotType, payload, EnqueueOptions{})
+	if err != nil {
+		if stateErr := srv.SnapshotRepo.SetState(snapshot.ID, models.SnapshotFailed); stateErr != nil {
+			return nil, stateErr
+		}
+		return nil, err
+	}
+
+	return &api.AddSnapshotResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		JobID:    jobID,
+		Snapshot: snapshotInfo(snapshot),
+	}, nil
+}
+
+func (srv *SnapshotAPIService) List(containerID string) (*api.ListSnapshotsResponse, error) {
+	_, err := srv.ContainerRepo.FindByID(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := srv.SnapshotRepo.List(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*api.SnapshotInfo, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		infos = append(infos, snapshotInfo(snapshot))
+	}
+
+	return &api.ListSnapshotsResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Snapshots: infos,
+	}, nil
+}
+
+func (srv *SnapshotAPIService) GetById(containerID, id string) (*api.GetSnapshotByIdResponse, error) {
+	snapshot, err := srv.SnapshotRepo.FindByID(containerID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.GetSnapshotByIdResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Snapshot: snapshotInfo(snapshot),
+	}, nil
+}
+
+// Delete enqueues a job deleting a snapshot which is ready or failed to be taken
+func (srv *SnapshotAPIService) Delete(containerID, id string, dryRun bool) (*api.JobResponse, error) {
+	container, err := srv.ContainerRepo.FindByID(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return srv.submitSnapshotJob(DeleteSnapshotType, container, id, dryRun, models.SnapshotDeleting, models.SnapshotReady, models.SnapshotFailed)
+}
+
+// Revert enqueues a job switching a container to a snapshot which is ready
+func (srv *SnapshotAPIService) Revert(containerID, id string, dryRun bool) (*api.JobResponse, error) {
+	container, err := srv.findSnapshotContainer(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	return srv.submitSnapshotJob(RevertSnapshotType, container, id, dryRun, models.SnapshotReverting, models.SnapshotReady)
+}
+
+// submitSnapshotJob moves a snapshot to state to, if it is in one of from states, and submits a job.
+// The snapshot is moved back if the job is not enqueued. A dry run leaves the snapshot as it is.
+func (srv *SnapshotAPIService) submitSnapshotJob(jobType string, container *models.Container, id string, dryRun bool, to string, from ...string) (*api.JobResponse, error) {
+	snapshot, err := srv.SnapshotRepo.FindByID(container.ID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	dryRun = dryRun || srv.Jobs.DryRun
+	if dryRun {
+		if !isOneOf(snapshot.State, from) {
+			return nil, ErrInvalidSnapshotState
+		}
+	} else {
+		ok, err := srv.SnapshotRepo.Transition(snapshot.ID, to, from...)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrInvalidSnapshotState
+		}
+	}
+
+	resp, err := srv.Jobs.submit(jobType, SnapshotJob{
+		ContainerJob: ContainerJob{
+			ID:   container.ID,
+			Name: container.Name,
+		},
+		SnapshotID: snapshot.ID,
+	}, EnqueueOptions{}, dryRun)
+	if err != nil && !dryRun {
+		if stateErr := srv.SnapshotRepo.SetState(snapshot.ID, snapshot.State); stateErr != nil {
+			return nil, stateErr
+		}
+	}
+
+	return resp, err
+}
+
+// findSnapshotContainer returns a container if it can be snapshotted or reverted
+func (srv *SnapshotAPIService) findSnapshotContainer(id string) (*models.Container, error) {
+	container, err := srv.ContainerRepo.FindByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isOneOfStates(container.State, snapshotContainerStates) {
+		return nil, ErrInvalidContainerState
+	}
+
+	return container, nil
+}
+
+func isOneOf(s string, values []string) bool {
+	for _, v := range values {
+		if s == v {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isOneOfStates(state models.ContainerState, states []models.ContainerState) bool {
+	for _, s := range states {
+		if state == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func snapshotInfo(snapshot *models.Snapshot) *api.SnapshotInfo {
+	return &api.SnapshotInfo{
+		ID:          snapshot.ID,
+		ContainerID: snapshot.ContainerID,
+		Name:        snapshot.Name,
+		Description: snapshot.Description,
+		State:       snapshot.State,
+		PolicyID:    snapshot.PolicyID.String,
+		CreatedAt:   snapshot.CreatedAt,
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/models"
+)
+
+func TestSnapshotLifecycle(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+	snapshots := &SnapshotAPIService{SnapshotRepo: jobs.SnapshotRepo, ContainerRepo: jobs.ContainerRepo, Jobs: jobs}
+	id := createTestContainer(t, srv, jobs, "web", map[string]json.RawMessage{"hostname": json.RawMessage(`"before"`)})
+
+	req := &api.AddSnapshotRequest{ContainerID: id, Name: "before upgrade"}
+	if err := api.ValidateAddSnapshotRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	created, err := snapshots.Create(req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Snapshot.State != models.SnapshotCreating {
+		t.Errorf("State = %s, want %s", created.Snapshot.State, models.SnapshotCreating)
+	}
+	assertJobDone(t, jobs, created.JobID)
+	snapshotID := created.Snapshot.ID
+
+	update := &api.UpdateContainerRequest{ID: id, Parameters: map[string]json.RawMessage{"hostname": json.RawMessage(`"after"`)}}
+	if err := api.ValidateUpdateContainerRequest(update); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := srv.Update(update, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, updated.JobID)
+
+	reverted, err := snapshots.Revert(id, snapshotID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshots.Delete(id, snapshotID, false); err != ErrInvalidSnapshotState {
+		t.Errorf("Delete() of a reverting snapshot = %v, want %v", err, ErrInvalidSnapshotState)
+	}
+	assertJobDone(t, jobs, reverted.JobID)
+	container := assertContainer(t, srv, id, models.STOPPED)
+	if container.Parameters["hostname"] != "before" {
+		t.Errorf("Parameters = %v, want ones of the snapshot", container.Parameters)
+	}
+
+	got, err := snapshots.GetById(id, snapshotID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Snapshot.State != models.SnapshotReady || got.Snapshot.Name != "before upgrade" {
+		t.Errorf("Snapshot = %+v, want a ready one", got.Snapshot)
+	}
+
+	deleted, err := snapshots.Delete(id, snapshotID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, deleted.JobID)
+	list, err := snapshots.List(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Snapshots) != 0 {
+		t.Errorf("Snapshots = %+v, want none", list.Snapshots)
+	}
+}
+
+func TestSnapshotDryRun(t *testing.T) {
+	srv, jobs, _ := newTestContainerService(t)
+	snapshots := &SnapshotAPIService{SnapshotRepo: jobs.SnapshotRepo, ContainerRepo: jobs.ContainerRepo, Jobs: jobs}
+	id := createTestContainer(t, srv, jobs, "web", nil)
+
+	created, err := snapshots.Create(&api.AddSnapshotRequest{ContainerID: id, Name: "nightly"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.DryRun || len(created.Commands) != 1 || created.Snapshot != nil {
+		t.Errorf("Create() = %+v, want a rendered command", created)
+	}
+
+	list, err := snapshots.List(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Snapshots) != 0 {
+		t.Errorf("Snapshots = %+v, want none", list.Snapshots)
+	}
+}
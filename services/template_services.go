@@ -0,0 +1,118 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/repositories"
+)
+
+// ErrUnknownTemplate is returned when a container is created from a template not found on a host
+var ErrUnknownTemplate = errors.New("unknown-template")
+
+// TemplateAPIService keeps a catalog of OS templates found on a host
+type TemplateAPIService struct {
+	TemplateRepo repositories.TemplateRepository
+	Commander    commanders.Commander
+	mu           sync.Mutex
+}
+
+func NewTemplateAPIService(db DBConnection, cmd commanders.Commander) *TemplateAPIService {
+	return &TemplateAPIService{
+		TemplateRepo: repositories.NewTemplateRepository(db),
+		Commander:    cmd,
+	}
+}
+
+// Run discovers templates on a host every interval, or once if interval is not positive
+func (srv *TemplateAPIService) Run(interval time.Duration) {
+	for {
+		if err := srv.Sync(); err != nil {
+			log.Printf("Templates are not discovered: %s", err.Error())
+		}
+		if interval <= 0 {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Sync replaces templates in the catalog with ones found on a host
+func (srv *TemplateAPIService) Sync() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	templates, err := srv.Commander.ListTemplates(context.Background())
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(templates))
+	for _, template := range templates {
+		names = append(names, template.Name)
+	}
+
+	return srv.TemplateRepo.Sync(names, time.Now().UTC())
+}
+
+// List lists templates in the catalog, discovering them on a host first if refresh is set
+func (srv *TemplateAPIService) List(refresh bool) (*api.ListTemplatesResponse, error) {
+	if refresh {
+		if err := srv.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	templates, err := srv.TemplateRepo.List()
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ListTemplatesResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Templates: templates,
+	}, nil
+}
+
+// checkTemplate returns ErrUnknownTemplate unless a template is in the catalog.
+// Any template is accepted while the catalog is empty, e.g. before templates are discovered.
+func checkTemplate(repo repositories.TemplateRepository, name string) error {
+	found, err := repo.Exists(name)
+	if err != nil || found {
+		return err
+	}
+
+	n, err := repo.Count()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrUnknownTemplate
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"testing"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+)
+
+func TestCreateContainerRejectsUnknownTemplate(t *testing.T) {
+	srv, jobs, cmd := newTestContainerService(t)
+	templates := &TemplateAPIService{TemplateRepo: srv.TemplateRepo, Commander: cmd}
+
+	// Any template is accepted until templates are discovered
+	createTestContainer(t, srv, jobs, "web", nil)
+
+	list, err := templates.List(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Templates) != len(commanders.FakeTemplates) {
+		t.Fatalf("Templates = %+v, want %v", list.Templates, commanders.FakeTemplates)
+	}
+
+	req := &api.AddContainerRequest{Name: "db", OSTemplate: "centos-6-x86_64"}
+	if _, err := srv.Create(req, "", false); err != ErrUnknownTemplate {
+		t.Errorf("Create() = %v, want %v", err, ErrUnknownTemplate)
+	}
+	if _, err := srv.Create(req, "", true); err != ErrUnknownTemplate {
+		t.Errorf("Create() of a dry run = %v, want %v", err, ErrUnknownTemplate)
+	}
+
+	req.OSTemplate = commanders.FakeTemplates[0]
+	resp, err := srv.Create(req, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertJobDone(t, jobs, resp.JobID)
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/models"
+	"github.com/romiras/go-openvz-api/repositories"
+)
+
+const webhookSecretSize = 32
+
+type WebhookAPIService struct {
+	WebhookRepo repositories.WebhookRepository
+}
+
+func NewWebhookAPIService(db DBConnection) *WebhookAPIService {
+	return &WebhookAPIService{
+		WebhookRepo: repositories.NewWebhookRepository(db),
+	}
+}
+
+func (srv *WebhookAPIService) Create(req *api.AddWebhookRequest) (*api.AddWebhookResponse, error) {
+	secret := req.Secret
+	if secret == "" {
+		buf := make([]byte, webhookSecretSize)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(buf)
+	}
+
+	webhook := &models.Webhook{
+		ID:        uuid.New().String(),
+		URL:       req.URL,
+		Secret:    secret,
+		Events:    strings.Join(req.Events, ","),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err := srv.WebhookRepo.Create(webhook)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.AddWebhookResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Webhook: webhookInfo(webhook),
+		Secret:  secret,
+	}, nil
+}
+
+func (srv *WebhookAPIService) List() (*api.ListWebhooksResponse, error) {
+	webhooks, err := srv.WebhookRepo.List()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*api.WebhookInfo, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		infos = append(infos, webhookInfo(webhook))
+	}
+
+	return &api.ListWebhooksResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Webhooks: infos,
+	}, nil
+}
+
+func (srv *WebhookAPIService) GetById(id string) (*api.GetWebhookByIdResponse, error) {
+	webhook, err := srv.findWebhookByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.GetWebhookByIdResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Webhook: webhookInfo(webhook),
+	}, nil
+}
+
+func (srv *WebhookAPIService) Delete(id string) (*api.ApiResponse, error) {
+	_, err := srv.findWebhookByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = srv.WebhookRepo.Delete(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ApiResponse{
+		Code:    0,
+		Message: "success",
+	}, nil
+}
+
+// ListDeliveries returns a delivery history of a webhook, the latest first
+func (srv *WebhookAPIService) ListDeliveries(id string) (*api.ListWebhookDeliveriesResponse, error) {
+	_, err := srv.findWebhookByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := srv.WebhookRepo.ListDeliveries(id, api.MaxListLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*api.WebhookDeliveryInfo, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		info := &api.WebhookDeliveryInfo{
+			ID:        delivery.ID,
+			Event:     delivery.Event,
+			Status:    delivery.Status,
+			Attempts:  delivery.Attempts,
+			CreatedAt: delivery.CreatedAt,
+		}
+		if delivery.ResponseCode.Valid {
+			code := int(delivery.ResponseCode.Int64)
+			info.ResponseCode = &code
+		}
+		if delivery.LastError.Valid {
+			info.LastError = &delivery.LastError.String
+		}
+		if delivery.DeliveredAt.Valid {
+			info.DeliveredAt = &delivery.DeliveredAt.Time
+		}
+		infos = append(infos, info)
+	}
+
+	return &api.ListWebhookDeliveriesResponse{
+		ApiResponse: api.ApiResponse{
+			Code:    0,
+			Message: "success",
+		},
+		Deliveries: infos,
+	}, nil
+}
+
+func (srv *WebhookAPIService) findWebhookByID(id string) (*models.Webhook, error) {
+	return srv.WebhookRepo.FindByID(id)
+}
+
+func webhookInfo(webhook *models.Webhook) *api.WebhookInfo {
+	return &api.WebhookInfo{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    webhook.EventList(),
+		CreatedAt: webhook.CreatedAt,
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+	"github.com/romiras/go-openvz-api/repositories"
+)
+
+const (
+	DefaultWebhookMaxAttempts = 8
+	DefaultWebhookTimeout     = 10 * time.Second
+
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+	WebhookSignatureHeader = "X-Webhook-Signature"
+)
+
+// WebhookService queues events for webhooks subscribed to them and delivers them.
+// Events are queued by ones reporting a status as soon as it is written, rather than
+// received from the bus, which drops events for slow subscribers.
+type WebhookService struct {
+	WebhookRepo repositories.WebhookRepository
+	Client      *http.Client
+	RetryPolicy RetryPolicy
+}
+
+func NewWebhookService(db DBConnection) *WebhookService {
+	policy := NewDefaultRetryPolicy()
+	policy.MaxAttempts = DefaultWebhookMaxAttempts
+
+	return &WebhookService{
+		WebhookRepo: repositories.NewWebhookRepository(db),
+		Client:      &http.Client{Timeout: DefaultWebhookTimeout},
+		RetryPolicy: policy,
+	}
+}
+
+// Run delivers queued deliveries, checking the queue every interval
+func (srv *WebhookService) Run(interval time.Duration) {
+	for {
+		delivered, err := srv.deliverNext()
+		if err != nil {
+			log.Println(err.Error())
+		}
+		if !delivered {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// Enqueue persists a delivery of an event for every webhook subscribed to it
+func (srv *WebhookService) Enqueue(eventType, subject string, data interface{}) error {
+	now := time.Now().UTC()
+	payload, err := json.Marshal(&events.Event{
+		Type:    eventType,
+		Subject: subject,
+		Time:    now,
+		Data:    data,
+	})
+	if err != nil {
+		return err
+	}
+
+	webhooks, err := srv.WebhookRepo.List()
+	if err != nil {
+		return err
+	}
+
+	var deliveries []*models.WebhookDelivery
+	for _, webhook := range webhooks {
+		if !subscribed(webhook, eventType) {
+			continue
+		}
+
+		deliveries = append(deliveries, &models.WebhookDelivery{
+			ID:        uuid.New().String(),
+			WebhookID: webhook.ID,
+			Event:     eventType,
+			Payload:   payload,
+			Status:    models.DeliveryPending,
+			NextRunAt: now,
+			CreatedAt: now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	// Deliveries are added at once, so that an event may be enqueued again when it fails
+	return srv.WebhookRepo.AddDeliveries(deliveries)
+}
+
+func subscribed(webhook *models.Webhook, eventType string) bool {
+	for _, event := range webhook.EventList() {
+		if event == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deliverNext claims a due delivery and sends it. A claim postpones the delivery
+// past the request timeout, so that it is retried if this process crashes.
+func (srv *WebhookService) deliverNext() (bool, error) {
+	now := time.Now().UTC()
+	delivery, err := srv.WebhookRepo.ClaimDelivery(now, now.Add(2*srv.Client.Timeout))
+	if err != nil || delivery == nil {
+		return false, err
+	}
+
+	webhook, err := srv.WebhookRepo.FindByID(delivery.WebhookID)
+	if err == sql.ErrNoRows {
+		return true, srv.WebhookRepo.MarkDeliveryFailed(delivery.ID, sql.NullInt64{}, "webhook is deleted")
+	}
+	if err != nil {
+		return true, err
+	}
+
+	code, err := srv.send(webhook, delivery)
+
+	return true, srv.updateDelivery(delivery, code, err)
+}
+
+func (srv *WebhookService) send(webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, delivery.Event)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookSignatureHeader, Sign(webhook.Secret, delivery.Payload))
+
+	resp, err := srv.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+func (srv *WebhookService) updateDelivery(delivery *models.WebhookDelivery, code int, err error) error {
+	var responseCode sql.NullInt64
+	if code != 0 {
+		responseCode = sql.NullInt64{Int64: int64(code), Valid: true}
+	}
+
+	if err == nil {
+		return srv.WebhookRepo.MarkDelivered(delivery.ID, responseCode, time.Now().UTC())
+	}
+
+	if delivery.Attempts >= srv.RetryPolicy.MaxAttempts {
+		return srv.WebhookRepo.MarkDeliveryFailed(delivery.ID, responseCode, err.Error())
+	}
+
+	nextRunAt := time.Now().UTC().Add(srv.RetryPolicy.Delay(delivery.Attempts))
+	return srv.WebhookRepo.ScheduleDelivery(delivery.ID, responseCode, err.Error(), nextRunAt)
+}
+
+// Sign returns a signature of a webhook payload: hex encoded HMAC-SHA256 of it keyed with secret
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,253 @@
+/*
+ * Copyright 2020 Roman Miro
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package services
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romiras/go-openvz-api/api"
+	"github.com/romiras/go-openvz-api/commanders"
+	"github.com/romiras/go-openvz-api/events"
+	"github.com/romiras/go-openvz-api/models"
+	"github.com/romiras/go-openvz-api/repositories"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver records requests and fails as many first ones as given
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	requests []webhookRequest
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	rcv.requests = append(rcv.requests, webhookRequest{header: r.Header, body: body})
+	if len(rcv.requests) <= rcv.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func newWebhookTest(t *testing.T, failures int, events ...string) (*WebhookService, *webhookReceiver, *api.AddWebhookResponse) {
+	t.Helper()
+
+	db := newTestDB(t)
+	rcv := &webhookReceiver{failures: failures}
+	server := httptest.NewServer(rcv)
+	t.Cleanup(server.Close)
+
+	req := &api.AddWebhookRequest{URL: server.URL, Events: events}
+	if err := api.ValidateAddWebhookRequest(req); err != nil {
+		t.Fatal(err)
+	}
+	created, err := NewWebhookAPIService(db).Create(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewWebhookService(db)
+	srv.RetryPolicy.Backoff = 0
+	srv.RetryPolicy.Jitter = 0
+
+	return srv, rcv, created
+}
+
+func deliver(t *testing.T, srv *WebhookService) bool {
+	t.Helper()
+
+	delivered, err := srv.deliverNext()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return delivered
+}
+
+func enqueueWebhookEvent(t *testing.T, srv *WebhookService, eventType, subject string, data interface{}) {
+	t.Helper()
+
+	if err := srv.Enqueue(eventType, subject, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWebhookDeliveryIsSignedAndRetried(t *testing.T) {
+	srv, rcv, created := newWebhookTest(t, 1, api.JobDoneEvent)
+
+	enqueueWebhookEvent(t, srv, api.JobDoneEvent, "job-1", &api.JobStatusEvent{Status: "done"})
+	enqueueWebhookEvent(t, srv, api.JobFailedEvent, "job-2", &api.JobStatusEvent{Status: "failed"})
+
+	if !deliver(t, srv) || !deliver(t, srv) {
+		t.Fatal("delivery is not retried")
+	}
+	if deliver(t, srv) {
+		t.Fatal("an event the webhook is not subscribed to is delivered")
+	}
+
+	if len(rcv.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(rcv.requests))
+	}
+	first, second := rcv.requests[0], rcv.requests[1]
+	if first.header.Get(WebhookDeliveryHeader) != second.header.Get(WebhookDeliveryHeader) {
+		t.Error("a retry has another delivery ID")
+	}
+	for _, req := range rcv.requests {
+		if got := req.header.Get(WebhookEventHeader); got != api.JobDoneEvent {
+			t.Errorf("got event %q, want %q", got, api.JobDoneEvent)
+		}
+		if got, want := req.header.Get(WebhookSignatureHeader), Sign(created.Secret, req.body); got != want {
+			t.Errorf("got signature %q, want %q", got, want)
+		}
+	}
+
+	deliveries, err := (&WebhookAPIService{WebhookRepo: srv.WebhookRepo}).ListDeliveries(created.Webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries.Deliveries) != 1 {
+		t.Fatalf("got %d deliveries, want 1", len(deliveries.Deliveries))
+	}
+	if d := deliveries.Deliveries[0]; d.Status != models.DeliveryDelivered || d.Attempts != 2 || d.ResponseCode == nil || *d.ResponseCode != http.StatusOK {
+		t.Errorf("got delivery %+v", d)
+	}
+}
+
+func TestWebhookDeliveryFailsOutOfAttempts(t *testing.T) {
+	srv, rcv, created := newWebhookTest(t, 10, api.JobFailedEvent)
+	srv.RetryPolicy.MaxAttempts = 3
+
+	enqueueWebhookEvent(t, srv, api.JobFailedEvent, "job-1", &api.JobStatusEvent{Status: "failed"})
+	for deliver(t, srv) {
+	}
+
+	if len(rcv.requests) != 3 {
+		t.Fatalf("got %d requests, want 3", len(rcv.requests))
+	}
+	deliveries, err := (&WebhookAPIService{WebhookRepo: srv.WebhookRepo}).ListDeliveries(created.Webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := deliveries.Deliveries[0]; d.Status != models.DeliveryFailed || d.ResponseCode == nil || *d.ResponseCode != http.StatusInternalServerError {
+		t.Errorf("got delivery %+v", d)
+	}
+}
+
+func TestDeliveryOfDeletedWebhookFails(t *testing.T) {
+	srv, rcv, created := newWebhookTest(t, 0, api.ContainerCreatedEvent)
+
+	enqueueWebhookEvent(t, srv, api.ContainerCreatedEvent, "ct-1", &api.ContainerEvent{ID: "ct-1"})
+	if _, err := (&WebhookAPIService{WebhookRepo: srv.WebhookRepo}).Delete(created.Webhook.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if !deliver(t, srv) || deliver(t, srv) {
+		t.Fatal("a delivery of a deleted webhook is not finished at once")
+	}
+	if len(rcv.requests) != 0 {
+		t.Fatalf("got %d requests of a deleted webhook", len(rcv.requests))
+	}
+}
+
+func TestWebhookDeliveryOfCrashedWorkerIsRedelivered(t *testing.T) {
+	srv, rcv, _ := newWebhookTest(t, 0, api.ContainerCreatedEvent)
+
+	enqueueWebhookEvent(t, srv, api.ContainerCreatedEvent, "ct-1", &api.ContainerEvent{ID: "ct-1"})
+
+	// A worker claims the delivery and crashes before sending it
+	now := time.Now().UTC()
+	claimed, err := srv.WebhookRepo.ClaimDelivery(now, now)
+	if err != nil || claimed == nil {
+		t.Fatalf("delivery is not claimed: %v", err)
+	}
+
+	if !deliver(t, srv) {
+		t.Fatal("delivery is not redelivered once its claim expires")
+	}
+	if len(rcv.requests) != 1 || rcv.requests[0].header.Get(WebhookDeliveryHeader) != claimed.ID {
+		t.Fatalf("got %d requests, want the claimed delivery", len(rcv.requests))
+	}
+}
+
+func TestJobEventsAreQueuedWithoutSubscribers(t *testing.T) {
+	srv, rcv, _ := newWebhookTest(t, 0, api.ContainerCreatedEvent)
+
+	j := NewJobService(newTestDB(t), commanders.NewFakeCommander(), events.NewBus())
+	j.Webhooks = srv
+	j.publishContainer(api.ContainerCreatedEvent, "ct-1", "c1", "centos-7-x86_64")
+
+	if !deliver(t, srv) || len(rcv.requests) != 1 {
+		t.Fatal("event of a container is not delivered")
+	}
+}
+
+// failingDeliveriesRepo fails to add as many first deliveries as given
+type failingDeliveriesRepo struct {
+	repositories.WebhookRepository
+	failures int
+}
+
+func (r *failingDeliveriesRepo) AddDeliveries(deliveries []*models.WebhookDelivery) error {
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("database is locked")
+	}
+
+	return r.WebhookRepository.AddDeliveries(deliveries)
+}
+
+func TestJobEventsAreQueuedAgainOnFailures(t *testing.T) {
+	srv, rcv, _ := newWebhookTest(t, 0, api.ContainerCreatedEvent)
+	srv.WebhookRepo = &failingDeliveriesRepo{WebhookRepository: srv.WebhookRepo, failures: WebhookEnqueueAttempts - 1}
+
+	j := NewJobService(newTestDB(t), commanders.NewFakeCommander(), events.NewBus())
+	j.Webhooks = srv
+	j.publishContainer(api.ContainerCreatedEvent, "ct-1", "c1", "centos-7-x86_64")
+
+	if !deliver(t, srv) || len(rcv.requests) != 1 {
+		t.Fatal("event of a container is not delivered")
+	}
+	if deliver(t, srv) {
+		t.Error("event of a container is delivered twice")
+	}
+}
+
+func TestValidateAddWebhookRequest(t *testing.T) {
+	for _, req := range []*api.AddWebhookRequest{
+		{Events: []string{api.JobDoneEvent}},
+		{URL: "ftp://example.com", Events: []string{api.JobDoneEvent}},
+		{URL: "http://example.com"},
+		{URL: "http://example.com", Events: []string{"job.lost"}},
+	} {
+		if err := api.ValidateAddWebhookRequest(req); err == nil {
+			t.Errorf("Request %+v is valid", req)
+		}
+	}
+}